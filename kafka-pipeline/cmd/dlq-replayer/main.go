@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/config"
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/kafka"
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/models"
+	"go.uber.org/zap"
+)
+
+// -------------------------------------------------------------------------------
+// DLQ Replayer is an operator tool, not a service: it reads the dead letter
+// topic once, up to its current end, and exits.
+//
+//	dlq-replayer list   [filters]             # inspect what is in the DLQ
+//	dlq-replayer replay [filters] [options]   # republish matching entries
+//
+// Replay sends the ORIGINAL key, value and headers back to the original topic
+// (or -target-topic), so the normal consumer reprocesses them after the bug
+// that put them in the DLQ has been fixed.
+//
+// LOOP PROTECTION:
+// Every replayed record carries a dlq.replay_count header. The consumer copies
+// it into the envelope's original headers if the record fails again, so the
+// next replay sees the incremented count. Entries at -max-replays are skipped:
+// a poison message fails on every replay and must be fixed, not retried.
+//
+// The DLQ itself is never modified — Kafka has no deletes. Replaying the same
+// entry twice is up to the operator; use filters (and -dry-run first).
+// -------------------------------------------------------------------------------
+
+const replayCountHeader = "dlq.replay_count"
+
+type filter struct {
+	sourceTopic string
+	errorType   string
+	key         string
+	since       time.Time
+	until       time.Time
+}
+
+func (f filter) matches(env *models.DeadLetterEnvelope) bool {
+	if f.sourceTopic != "" && env.OriginalTopic != f.sourceTopic {
+		return false
+	}
+	if f.errorType != "" && env.ErrorType != f.errorType {
+		return false
+	}
+	if f.key != "" && env.OriginalKey != f.key {
+		return false
+	}
+	if !f.since.IsZero() && env.LastFailedAt.Before(f.since) {
+		return false
+	}
+	if !f.until.IsZero() && env.LastFailedAt.After(f.until) {
+		return false
+	}
+	return true
+}
+
+func main() {
+	if len(os.Args) < 2 || (os.Args[1] != "list" && os.Args[1] != "replay") {
+		fmt.Fprintln(os.Stderr, "usage: dlq-replayer <list|replay> [flags]")
+		os.Exit(2)
+	}
+	cmd := os.Args[1]
+
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	configPath := fs.String("config", "configs/config.yaml", "path to config file")
+	dlqTopic := fs.String("dlq-topic", "", "DLQ topic to read (default: kafka.consumer.dlq_topic)")
+	sourceTopic := fs.String("source-topic", "", "only entries that failed on this topic")
+	errorType := fs.String("error-type", "", "only entries with this error type (e.g. DESERIALIZATION)")
+	key := fs.String("key", "", "only entries with this original key")
+	since := fs.String("since", "", "only entries that last failed at or after this RFC3339 time")
+	until := fs.String("until", "", "only entries that last failed at or before this RFC3339 time")
+	limit := fs.Int("limit", 0, "stop after this many matching entries (0 = no limit)")
+	targetTopic := fs.String("target-topic", "", "replay: publish here instead of each entry's original topic")
+	dryRun := fs.Bool("dry-run", false, "replay: show what would be published without publishing")
+	rate := fs.Int("rate", 50, "replay: max messages per second")
+	maxReplays := fs.Int("max-replays", 3, "replay: skip entries already replayed this many times")
+	fs.Parse(os.Args[2:])
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load config: %v\n", err)
+		os.Exit(1)
+	}
+	if *dlqTopic == "" {
+		*dlqTopic = cfg.Kafka.Consumer.DLQTopic
+	}
+
+	f := filter{sourceTopic: *sourceTopic, errorType: *errorType, key: *key}
+	if f.since, err = parseTime(*since); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid -since: %v\n", err)
+		os.Exit(2)
+	}
+	if f.until, err = parseTime(*until); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid -until: %v\n", err)
+		os.Exit(2)
+	}
+
+	logger, _ := zap.NewDevelopment()
+	defer logger.Sync()
+	logger = logger.Named("dlq-replayer")
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	switch cmd {
+	case "list":
+		err = list(ctx, cfg, *dlqTopic, f, *limit)
+	case "replay":
+		err = replay(ctx, cfg, *dlqTopic, f, *limit, replayOptions{
+			targetTopic: *targetTopic,
+			dryRun:      *dryRun,
+			rate:        *rate,
+			maxReplays:  *maxReplays,
+		}, logger)
+	}
+	if err != nil {
+		logger.Error("dlq-replayer failed", zap.Error(err))
+		os.Exit(1)
+	}
+}
+
+// errLimitReached stops the DLQ scan early once -limit entries matched.
+var errLimitReached = errors.New("limit reached")
+
+// scan decodes every DLQ record and calls fn for the ones that match f.
+func scan(ctx context.Context, cfg *config.Config, dlqTopic string, f filter, limit int, fn func(*sarama.ConsumerMessage, *models.DeadLetterEnvelope) error) error {
+	matched := 0
+	err := kafka.ReplayTopic(ctx, &cfg.Kafka, dlqTopic, func(msg *sarama.ConsumerMessage) error {
+		var env models.DeadLetterEnvelope
+		if err := json.Unmarshal(msg.Value, &env); err != nil {
+			fmt.Fprintf(os.Stderr, "skipping undecodable DLQ record %d/%d: %v\n", msg.Partition, msg.Offset, err)
+			return nil
+		}
+		if !f.matches(&env) {
+			return nil
+		}
+		if err := fn(msg, &env); err != nil {
+			return err
+		}
+		matched++
+		if limit > 0 && matched >= limit {
+			return errLimitReached
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errLimitReached) {
+		return err
+	}
+	return nil
+}
+
+func list(ctx context.Context, cfg *config.Config, dlqTopic string, f filter, limit int) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "DLQ_OFFSET\tSOURCE\tKEY\tERROR_TYPE\tREPLAYS\tLAST_FAILED_AT\tERROR")
+
+	err := scan(ctx, cfg, dlqTopic, f, limit, func(msg *sarama.ConsumerMessage, env *models.DeadLetterEnvelope) error {
+		fmt.Fprintf(w, "%d/%d\t%s/%d@%d\t%s\t%s\t%d\t%s\t%s\n",
+			msg.Partition, msg.Offset,
+			env.OriginalTopic, env.OriginalPartition, env.OriginalOffset,
+			env.OriginalKey,
+			env.ErrorType,
+			replayCount(env),
+			env.LastFailedAt.Format(time.RFC3339),
+			env.ErrorMessage,
+		)
+		return nil
+	})
+	w.Flush()
+	return err
+}
+
+type replayOptions struct {
+	targetTopic string
+	dryRun      bool
+	rate        int
+	maxReplays  int
+}
+
+func replay(ctx context.Context, cfg *config.Config, dlqTopic string, f filter, limit int, opts replayOptions, logger *zap.Logger) error {
+	var producer *kafka.Producer
+	if !opts.dryRun {
+		var err error
+		producer, err = kafka.NewProducer(&cfg.Kafka, logger.Named("producer"))
+		if err != nil {
+			return err
+		}
+		defer producer.Close()
+	}
+
+	ticker := time.NewTicker(time.Second / time.Duration(max(opts.rate, 1)))
+	defer ticker.Stop()
+
+	var replayed, skipped int
+	err := scan(ctx, cfg, dlqTopic, f, limit, func(msg *sarama.ConsumerMessage, env *models.DeadLetterEnvelope) error {
+		count := replayCount(env)
+		if count >= opts.maxReplays {
+			skipped++
+			logger.Warn("skipping entry at max replays — fix the message or raise -max-replays",
+				zap.String("dlq_offset", fmt.Sprintf("%d/%d", msg.Partition, msg.Offset)),
+				zap.String("key", env.OriginalKey),
+				zap.Int("replay_count", count),
+			)
+			return nil
+		}
+
+		topic := env.OriginalTopic
+		if opts.targetTopic != "" {
+			topic = opts.targetTopic
+		}
+		headers := replayHeaders(env, count+1)
+
+		if opts.dryRun {
+			replayed++
+			logger.Info("dry run — would replay",
+				zap.String("dlq_offset", fmt.Sprintf("%d/%d", msg.Partition, msg.Offset)),
+				zap.String("topic", topic),
+				zap.String("key", env.OriginalKey),
+				zap.Int("replay_count", count+1),
+			)
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		if _, _, err := producer.ProduceRaw(ctx, topic, -1, env.OriginalKey, env.OriginalValue, headers); err != nil {
+			return fmt.Errorf("replaying DLQ record %d/%d: %w", msg.Partition, msg.Offset, err)
+		}
+		replayed++
+		return nil
+	})
+
+	logger.Info("replay finished",
+		zap.Bool("dry_run", opts.dryRun),
+		zap.Int("replayed", replayed),
+		zap.Int("skipped_max_replays", skipped),
+	)
+	return err
+}
+
+// replayHeaders returns the original headers minus the ones the producer and
+// consumer set themselves, plus the replay bookkeeping.
+//
+// The ingest time is reset: a replay is a new entry into the pipeline, and the
+// original one — days ago, often — would swamp the pipeline latency metric.
+// It is kept as dlq.original_ingested_at.
+func replayHeaders(env *models.DeadLetterEnvelope, count int) map[string]string {
+	headers := make(map[string]string, len(env.OriginalHeaders)+2)
+	for k, v := range env.OriginalHeaders {
+		switch k {
+		case kafka.ProducedAtHeader, kafka.SourceTopicHeader:
+			continue
+		case kafka.IngestedAtHeader:
+			headers["dlq.original_ingested_at"] = v
+			continue
+		}
+		headers[k] = v
+	}
+	headers[replayCountHeader] = strconv.Itoa(count)
+	headers["dlq.replayed_at"] = time.Now().UTC().Format(time.RFC3339Nano)
+	return headers
+}
+
+func replayCount(env *models.DeadLetterEnvelope) int {
+	n, _ := strconv.Atoi(env.OriginalHeaders[replayCountHeader])
+	return n
+}
+
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/config"
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/enrichment"
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/models"
+	"go.uber.org/zap"
+)
+
+// lookups are the reference data enrich decorates a transaction with.
+type lookups struct {
+	riskTiers *enrichment.Lookup
+	geo       *enrichment.Lookup
+	mcc       *enrichment.Lookup
+}
+
+func newLookups(cfg config.EnrichmentConfig, riskTiers *enrichment.RiskTierTable, logger *zap.Logger) (*lookups, error) {
+	geo, err := enrichment.LoadGeoDB(cfg.GeoIPFile)
+	if err != nil {
+		return nil, err
+	}
+	mcc, err := enrichment.LoadMerchantCategories(cfg.MerchantCategoriesFile)
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("enrichment data loaded",
+		zap.Int("geo_ranges", geo.Len()),
+		zap.Int("merchants", mcc.Len()),
+	)
+
+	return &lookups{
+		riskTiers: enrichment.NewLookup(riskTiers, cfg.CacheTTL, logger),
+		geo:       enrichment.NewLookup(geo, cfg.CacheTTL, logger),
+		mcc:       enrichment.NewLookup(mcc, cfg.CacheTTL, logger),
+	}, nil
+}
+
+func (l *lookups) Close() {
+	l.riskTiers.Close()
+	l.geo.Close()
+	l.mcc.Close()
+}
+
+// merchantOf returns the merchant side of txn: the payee of a payment, the
+// payer of a refund. Transfers are between people and have none.
+func merchantOf(txn *models.Transaction) string {
+	switch txn.Type {
+	case models.TypePayment:
+		return txn.ReceiverID
+	case models.TypeRefund:
+		return txn.SenderID
+	default:
+		return ""
+	}
+}
+
+func enrich(ctx context.Context, txn *models.Transaction, fraud *models.FraudResult, lookups *lookups) models.EnrichedTransaction {
+	riskTier := models.RiskTierLow
+	if fraud.RiskScore > 0.4 {
+		riskTier = models.RiskTierMedium
+	}
+	if fraud.RiskScore > 0.7 {
+		riskTier = models.RiskTierHigh
+	}
+
+	status := models.StatusApproved
+	if fraud.Decision == "REJECT" {
+		status = models.StatusRejected
+	} else if fraud.Decision == "REVIEW" {
+		status = models.StatusFlagged
+	}
+
+	txn.Status = status
+	now := time.Now().UTC()
+	txn.ProcessedAt = &now
+
+	// merchant_category holds a code or nothing: an unknown merchant leaves
+	// it empty rather than UNKNOWN.
+	var category string
+	if merchant := merchantOf(txn); merchant != "" {
+		if category = lookups.mcc.Resolve(ctx, merchant); category == enrichment.Unknown {
+			category = ""
+		}
+	}
+
+	return models.EnrichedTransaction{
+		Transaction:      *txn,
+		SenderRiskTier:   riskTier,
+		ReceiverRiskTier: lookups.riskTiers.Resolve(ctx, txn.ReceiverID),
+		GeoLocation:      lookups.geo.Resolve(ctx, txn.Metadata["ip_address"]),
+		MerchantCategory: category,
+		EnrichedAt:       now,
+	}
+}
@@ -3,17 +3,24 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"hash/fnv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/config"
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/enrichment"
 	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/health"
 	kafkapkg "github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/kafka"
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/metrics"
 	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/middleware"
 	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/models"
 	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/runner"
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/serde"
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/statestore"
 	"go.uber.org/zap"
 )
 
@@ -24,65 +31,136 @@ import (
 // THE PROBLEM:
 // We have two streams: raw transactions and fraud results. We need to combine
 // them into a single enriched event. But they arrive on different topics at
-// different times — the fraud result might arrive 50ms to 5s after the raw txn.
+// different times — the fraud result might arrive 50ms to 5s after the raw txn,
+// and during consumer lag or a rebalance it can even arrive BEFORE it.
 //
-// THE SOLUTION: LOCAL STATE STORE
-// We maintain an in-memory cache (simulating a RocksDB state store or Redis).
-// When a raw transaction arrives, we store it. When a fraud result arrives,
-// we look up the transaction, merge them, and emit the enriched event.
+// THE SOLUTION: SYMMETRIC WINDOWED JOIN OVER A LOCAL STATE STORE
+// We maintain a local state store (in memory or an embedded on-disk store).
+// Whichever side arrives first is buffered; whichever arrives second looks up
+// the other, merges them, and emits the enriched event. Buffered entries wait
+// at most the join window. Transactions that expire unmatched are sent to the
+// unjoined topic (if enabled) rather than silently dropped.
 //
-// REAL-WORLD CONSIDERATIONS:
-//   - In Kafka Streams (Java), this is a KTable join — state is backed by a
-//     changelog topic and survives restarts.
-//   - In Go, you either use an external store (Redis, DynamoDB) or accept
-//     that in-memory state is lost on restart (acceptable if you can replay).
-//   - The timeout (TTL) prevents memory leaks from unmatched events.
+// FAULT TOLERANCE — THE KTABLE TRICK:
+//   - Every store write is also produced to a compacted changelog topic,
+//     exactly like a Kafka Streams KTable.
+//   - On partition assignment we replay the changelog for the partitions we
+//     now own, so half-joined state survives restarts AND rebalances. State
+//     of partitions we lost is dropped, and never evicted by us again: the
+//     new owner's changelog would get our tombstones.
+//   - The timeout (TTL) prevents unbounded growth from unmatched events;
+//     evictions are written to the changelog as tombstones.
 //
 // PARTITION CO-LOCATION:
 // This join ONLY works because both topics use the same partition key (sender_id).
 // If a transaction is on partition 3 of the raw topic, its fraud result will
 // also be on partition 3 of the fraud results topic. This means a single
 // consumer instance sees both halves of the join.
+//
+// ENRICHMENT:
+// The joined event is decorated with reference data: the receiver's risk tier
+// (a GlobalTable over the risk tier topic), the client's location (a local
+// CIDR database) and the merchant's category code (a merchant directory).
+// See internal/enrichment — each lookup is cached, breaker-guarded and
+// best-effort.
 // -------------------------------------------------------------------------------
 func main() {
 	configPath := flag.String("config", "configs/config.yaml", "path to config file")
 	flag.Parse()
 
-	runner.Run(*configPath, func(ctx context.Context, cfg *config.Config, producer *kafkapkg.Producer, logger *zap.Logger, healthSrv *health.Server) error {
+	runner.Run(*configPath, func(ctx context.Context, cfg *config.Config, producer *kafkapkg.Producer, codec serde.Deserializer, logger *zap.Logger, healthSrv *health.Server) error {
 		logger = logger.Named("enricher")
 
-		store := newJoinStore(5 * time.Minute)
+		local, err := statestore.Open(cfg.StateStore, "enricher-join")
+		if err != nil {
+			return fmt.Errorf("opening join store: %w", err)
+		}
+		changelog := statestore.NewChangelogStore(local, producer, &cfg.Kafka, cfg.Kafka.Topics.JoinChangelog.Name, logger)
+		defer changelog.Close()
 
 		outputTopic := cfg.Kafka.Topics.EnrichedTransactions.Name
+		unjoinedTopic := ""
+		if cfg.Enricher.EmitUnjoined {
+			unjoinedTopic = cfg.Kafka.Topics.Unjoined.Name
+		}
+
+		store := newJoinStore(ctx, changelog, producer, cfg.Enricher.JoinWindow,
+			func(ctx context.Context, entry *joinEntry) error {
+				return handleExpired(ctx, entry, producer, unjoinedTopic, logger)
+			}, logger)
 
+		// Every instance holds the tier of every user: receivers are not
+		// co-partitioned with the senders we consume by.
+		riskTopic := cfg.Kafka.Topics.RiskTiers.Name
+		riskLocal, err := statestore.Open(cfg.StateStore, "enricher-risk-tiers")
+		if err != nil {
+			return fmt.Errorf("opening risk tier store: %w", err)
+		}
+		riskTable := statestore.NewGlobalTable(riskLocal, &cfg.Kafka, riskTopic, logger)
+		defer riskTable.Close()
+		if err := riskTable.Restore(ctx); err != nil {
+			return err
+		}
+		lookups, err := newLookups(cfg.Enricher.Lookups, enrichment.NewRiskTierTable(riskTable, func(raw []byte, v any) error {
+			return codec.Deserialize(riskTopic, raw, v)
+		}), logger.Named("lookups"))
+		if err != nil {
+			return fmt.Errorf("loading enrichment data: %w", err)
+		}
+		defer lookups.Close()
+
+		// A table that stopped following would keep serving stale tiers
+		// without anyone noticing, so losing the topic stops the service.
+		ctx, cancel := context.WithCancelCause(ctx)
+		defer cancel(nil)
+		go func() {
+			if err := riskTable.Follow(ctx); err != nil {
+				cancel(err)
+			}
+		}()
+
+		limiter := middleware.NewAdaptiveLimiter("enricher", cfg.Kafka.Consumer.AdaptiveLimit, logger)
 		handler := middleware.Chain(
 			middleware.Recovery(logger),
 			middleware.Logging(logger),
+			middleware.AdaptiveLimit(limiter),
 			middleware.Timeout(15*time.Second),
 		)(func(ctx context.Context, key []byte, value []byte, headers map[string]string) error {
-			// Determine which topic this message came from via header.
-			sourceTopic := headers["source_topic"]
-			if sourceTopic == "" {
-				// Fallback: try to detect by deserializing
-				return handleAutoDetect(ctx, value, store, producer, outputTopic, logger)
+			// The consumer group tells us exactly which topic and partition this
+			// record came from — no need to guess from the payload shape.
+			md, ok := kafkapkg.MetadataFromContext(ctx)
+			if !ok {
+				return fmt.Errorf("message metadata missing from context")
 			}
 
-			switch sourceTopic {
+			switch md.Topic {
 			case cfg.Kafka.Topics.Transactions.Name:
-				return handleRawTransaction(value, store, logger)
+				var txn models.Transaction
+				if err := codec.Deserialize(md.Topic, value, &txn); err != nil {
+					return fmt.Errorf("deserializing transaction: %w", err)
+				}
+				return handleRawTransaction(ctx, md.Partition, &txn, store, lookups, producer, outputTopic, logger)
 			case cfg.Kafka.Topics.FraudResults.Name:
-				return handleFraudResult(ctx, value, store, producer, outputTopic, logger)
+				var result models.FraudResult
+				if err := codec.Deserialize(md.Topic, value, &result); err != nil {
+					return fmt.Errorf("deserializing fraud result: %w", err)
+				}
+				return handleFraudResult(ctx, md.Partition, &result, store, lookups, producer, outputTopic, logger)
 			default:
-				return fmt.Errorf("unexpected source topic: %s", sourceTopic)
+				return fmt.Errorf("unexpected source topic: %s", md.Topic)
 			}
 		})
 
 		// consumer group subscribes to both topics.
 		consumerCfg := cfg.Kafka
-		consumerCfg.Consumer.GroupID = "enricher-v1"
+		consumerCfg.Consumer.GroupID = config.EnricherGroupID
+		// The store is partitioned like the input: keep partition p of every
+		// input and retry topic on the instance restoring its state.
+		consumerCfg.Consumer.CoPartitioned = true
 
 		cg, err := kafkapkg.NewConsumerGroup(
 			&consumerCfg,
+			cfg.Service,
 			[]string{
 				cfg.Kafka.Topics.Transactions.Name,
 				cfg.Kafka.Topics.FraudResults.Name,
@@ -94,51 +172,91 @@ func main() {
 		if err != nil {
 			return fmt.Errorf("creating consumer group: %w", err)
 		}
+		limiter.PauseFetches(cg)
+
+		// Rebuild join state for the partitions we were just given before
+		// consuming any of them. Both input topics are co-partitioned with the
+		// changelog, so the partition numbers are the same across all three.
+		cg.OnPartitionsAssigned(func(ctx context.Context, claims map[string][]int32) error {
+			seen := make(map[int32]bool)
+			var partitions []int32
+			for _, topicPartitions := range claims {
+				for _, p := range topicPartitions {
+					if !seen[p] {
+						seen[p] = true
+						partitions = append(partitions, p)
+					}
+				}
+			}
+			return changelog.Restore(ctx, partitions)
+		})
+		cg.OnPartitionsRevoked(changelog.Revoke)
 
+		healthSrv.RegisterReadinessCheck("consumer_lag", cg.CheckLag)
 		healthSrv.SetReady(true)
-		return cg.Run(ctx)
+		if err := cg.Run(ctx); err != nil {
+			return err
+		}
+		if cause := context.Cause(ctx); !errors.Is(cause, context.Canceled) {
+			return cause
+		}
+		return nil
 	})
 }
 
-// handleAutoDetect tries to determine the message type by attempting deserialization.
-func handleAutoDetect(ctx context.Context, value []byte, store *joinStore, producer *kafkapkg.Producer, outputTopic string, logger *zap.Logger) error {
-	// Try fraud result first (smaller, more specific structure).
-	var fr models.FraudResult
-	if err := json.Unmarshal(value, &fr); err == nil && fr.TransactionID != "" && fr.Decision != "" {
-		return handleFraudResult(ctx, value, store, producer, outputTopic, logger)
+// handleRawTransaction joins a transaction against a buffered fraud result.
+// partition is the input partition, which the changelog entry is pinned to.
+func handleRawTransaction(ctx context.Context, partition int32, txn *models.Transaction, store *joinStore, lookups *lookups, producer *kafkapkg.Producer, outputTopic string, logger *zap.Logger) error {
+	unlock := store.Lock(ctx, txn.ID)
+	defer unlock()
+
+	result, ok, err := store.GetFraudResult(txn.ID)
+	if err != nil {
+		return fmt.Errorf("reading join store: %w", err)
+	}
+	if !ok {
+		if err := store.StoreTransaction(ctx, partition, txn); err != nil {
+			return fmt.Errorf("storing transaction for join: %w", err)
+		}
+		metrics.JoinEvents.WithLabelValues("transaction", "buffered").Inc()
+		logger.Debug("stored transaction for join", zap.String("txn_id", txn.ID))
+		return nil
 	}
 
-	// Must be a raw transaction.
-	return handleRawTransaction(value, store, logger)
+	// The fraud result beat its transaction here — late-arriving left side.
+	metrics.JoinEvents.WithLabelValues("transaction", "matched").Inc()
+	return emitEnriched(ctx, txn, result, store, lookups, producer, outputTopic, logger)
 }
 
-func handleRawTransaction(value []byte, store *joinStore, logger *zap.Logger) error {
-	var txn models.Transaction
-	if err := json.Unmarshal(value, &txn); err != nil {
-		return fmt.Errorf("deserializing transaction: %w", err)
-	}
-	store.StoreTransaction(txn.ID, &txn)
-	logger.Debug("stored transaction for join", zap.String("txn_id", txn.ID))
-	return nil
-}
+// handleFraudResult joins a fraud result against the stored transaction.
+func handleFraudResult(ctx context.Context, partition int32, result *models.FraudResult, store *joinStore, lookups *lookups, producer *kafkapkg.Producer, outputTopic string, logger *zap.Logger) error {
+	unlock := store.Lock(ctx, result.TransactionID)
+	defer unlock()
 
-func handleFraudResult(ctx context.Context, value []byte, store *joinStore, producer *kafkapkg.Producer, outputTopic string, logger *zap.Logger) error {
-	var result models.FraudResult
-	if err := json.Unmarshal(value, &result); err != nil {
-		return fmt.Errorf("deserializing fraud result: %w", err)
+	txn, ok, err := store.GetTransaction(result.TransactionID)
+	if err != nil {
+		return fmt.Errorf("reading join store: %w", err)
 	}
-
-	txn, ok := store.GetTransaction(result.TransactionID)
 	if !ok {
-		logger.Warn("transaction not found for fraud result — join miss",
+		logger.Debug("transaction not found for fraud result — buffering until it arrives",
 			zap.String("txn_id", result.TransactionID),
 		)
 
-		store.StoreFraudResult(result.TransactionID, &result)
+		if err := store.StoreFraudResult(ctx, partition, result); err != nil {
+			return fmt.Errorf("storing fraud result for join: %w", err)
+		}
+		metrics.JoinEvents.WithLabelValues("fraud_result", "buffered").Inc()
 		return nil
 	}
 
-	enriched := enrich(txn, &result)
+	metrics.JoinEvents.WithLabelValues("fraud_result", "matched").Inc()
+	return emitEnriched(ctx, txn, result, store, lookups, producer, outputTopic, logger)
+}
+
+// emitEnriched produces the joined event and clears both halves from the store.
+// The caller must hold the store lock for the transaction ID.
+func emitEnriched(ctx context.Context, txn *models.Transaction, result *models.FraudResult, store *joinStore, lookups *lookups, producer *kafkapkg.Producer, outputTopic string, logger *zap.Logger) error {
+	enriched := enrich(ctx, txn, result, lookups)
 
 	_, _, err := producer.ProduceMessage(ctx, outputTopic, txn.SenderID, enriched, map[string]string{
 		"fraud_decision": result.Decision,
@@ -149,7 +267,9 @@ func handleFraudResult(ctx context.Context, value []byte, store *joinStore, prod
 	}
 
 	// Clean up the state store.
-	store.Delete(result.TransactionID)
+	if err := store.Delete(ctx, result.TransactionID); err != nil {
+		return fmt.Errorf("cleaning up join store: %w", err)
+	}
 
 	logger.Debug("enriched transaction produced",
 		zap.String("txn_id", txn.ID),
@@ -158,123 +278,239 @@ func handleFraudResult(ctx context.Context, value []byte, store *joinStore, prod
 	return nil
 }
 
-func enrich(txn *models.Transaction, fraud *models.FraudResult) models.EnrichedTransaction {
-	riskTier := "LOW"
-	if fraud.RiskScore > 0.4 {
-		riskTier = "MEDIUM"
-	}
-	if fraud.RiskScore > 0.7 {
-		riskTier = "HIGH"
+// handleExpired is called for each join entry whose partner never arrived
+// within the join window, just before the entry is evicted. An error keeps the
+// entry in the store so the next eviction pass retries it.
+func handleExpired(ctx context.Context, entry *joinEntry, producer *kafkapkg.Producer, unjoinedTopic string, logger *zap.Logger) error {
+	if entry.FraudResult != nil {
+		metrics.JoinEvents.WithLabelValues("fraud_result", "expired").Inc()
+		logger.Warn("fraud result expired without a transaction — join miss",
+			zap.String("txn_id", entry.FraudResult.TransactionID),
+		)
+		return nil
 	}
 
-	status := models.StatusApproved
-	if fraud.Decision == "REJECT" {
-		status = models.StatusRejected
-	} else if fraud.Decision == "REVIEW" {
-		status = models.StatusFlagged
+	txn := entry.Transaction
+	metrics.JoinEvents.WithLabelValues("transaction", "expired").Inc()
+	logger.Warn("transaction expired without a fraud result — join miss",
+		zap.String("txn_id", txn.ID),
+		zap.Bool("emitted_unjoined", unjoinedTopic != ""),
+	)
+	if unjoinedTopic == "" {
+		return nil
 	}
 
-	txn.Status = status
-	now := time.Now().UTC()
-	txn.ProcessedAt = &now
-
-	return models.EnrichedTransaction{
-		Transaction:      *txn,
-		SenderRiskTier:   riskTier,
-		ReceiverRiskTier: "LOW",     // Simulated lookup
-		GeoLocation:      "IND-DEL", // Simulated geo lookup
-		MerchantCategory: "5411",    // Simulated MCC lookup
-		EnrichedAt:       now,
+	_, _, err := producer.ProduceMessage(ctx, unjoinedTopic, txn.SenderID, txn, map[string]string{
+		"unjoined_reason": "fraud_result_timeout",
+		"stored_at":       entry.StoredAt.UTC().Format(time.RFC3339Nano),
+	})
+	if err != nil {
+		return fmt.Errorf("producing unjoined transaction: %w", err)
 	}
+	return nil
 }
 
 // -------------------------------------------------------------------------------
-// joinStore is a simple in-memory state store with TTL.
-// In production, replace with Redis or a compacted Kafka topic.
+// joinStore holds both halves of the join in a changelog-backed state store,
+// with TTL. Each entry remembers its partition so evictions can write their
+// tombstone to the right changelog partition.
+//
+// The two sides of one transaction are consumed from different topics, i.e. on
+// different goroutines. Without a lock both could miss each other, both get
+// buffered, and the pair would expire unjoined. Lock(id) makes check-then-store
+// atomic per transaction ID.
 // -------------------------------------------------------------------------------
 
-type joinStore struct {
-	mu           sync.RWMutex
-	transactions map[string]*txnEntry
-	fraudResults map[string]*frEntry
-	ttl          time.Duration
-}
+const (
+	txnKeyPrefix   = "txn/"
+	fraudKeyPrefix = "fraud/"
+)
 
-type txnEntry struct {
-	txn      *models.Transaction
-	storedAt time.Time
+type joinStore struct {
+	store    *statestore.ChangelogStore
+	producer *kafkapkg.Producer
+	ttl      time.Duration
+	onExpire func(ctx context.Context, entry *joinEntry) error
+	locks    [64]sync.Mutex
+	logger   *zap.Logger
 }
 
-type frEntry struct {
-	result   *models.FraudResult
-	storedAt time.Time
+type joinEntry struct {
+	Partition   int32               `json:"partition"`
+	StoredAt    time.Time           `json:"stored_at"`
+	Transaction *models.Transaction `json:"transaction,omitempty"`
+	FraudResult *models.FraudResult `json:"fraud_result,omitempty"`
 }
 
-func newJoinStore(ttl time.Duration) *joinStore {
+func newJoinStore(ctx context.Context, store *statestore.ChangelogStore, producer *kafkapkg.Producer, ttl time.Duration, onExpire func(context.Context, *joinEntry) error, logger *zap.Logger) *joinStore {
 	s := &joinStore{
-		transactions: make(map[string]*txnEntry),
-		fraudResults: make(map[string]*frEntry),
-		ttl:          ttl,
+		store:    store,
+		producer: producer,
+		ttl:      ttl,
+		onExpire: onExpire,
+		logger:   logger,
 	}
-	go s.evictLoop()
+	go s.evictLoop(ctx)
 	return s
 }
 
-func (s *joinStore) StoreTransaction(id string, txn *models.Transaction) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	s.transactions[id] = &txnEntry{txn: txn, storedAt: time.Now()}
+// Lock serializes join decisions for one transaction ID and returns the unlock
+// func. In exactly_once mode unlocking waits for the end of ctx's transaction:
+// its writes only reach the store on commit.
+func (s *joinStore) Lock(ctx context.Context, id string) func() {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	mu := &s.locks[h.Sum32()%uint32(len(s.locks))]
+	mu.Lock()
+	return func() { kafkapkg.AtTxnEnd(ctx, mu.Unlock) }
+}
+
+// StoreTransaction saves txn, pinning its changelog entry to the input partition.
+func (s *joinStore) StoreTransaction(ctx context.Context, partition int32, txn *models.Transaction) error {
+	return s.put(ctx, txnKeyPrefix+txn.ID, &joinEntry{
+		Partition:   partition,
+		StoredAt:    time.Now(),
+		Transaction: txn,
+	})
+}
+
+func (s *joinStore) StoreFraudResult(ctx context.Context, partition int32, result *models.FraudResult) error {
+	return s.put(ctx, fraudKeyPrefix+result.TransactionID, &joinEntry{
+		Partition:   partition,
+		StoredAt:    time.Now(),
+		FraudResult: result,
+	})
+}
+
+func (s *joinStore) GetTransaction(id string) (*models.Transaction, bool, error) {
+	entry, ok, err := s.get(txnKeyPrefix + id)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	return entry.Transaction, true, nil
+}
+
+func (s *joinStore) GetFraudResult(id string) (*models.FraudResult, bool, error) {
+	entry, ok, err := s.get(fraudKeyPrefix + id)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	return entry.FraudResult, true, nil
 }
 
-func (s *joinStore) StoreFraudResult(id string, result *models.FraudResult) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	s.fraudResults[id] = &frEntry{result: result, storedAt: time.Now()}
+// Delete removes both halves of the join for id.
+func (s *joinStore) Delete(ctx context.Context, id string) error {
+	for _, key := range []string{txnKeyPrefix + id, fraudKeyPrefix + id} {
+		entry, ok, err := s.load(key)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		if err := s.store.Delete(ctx, entry.Partition, key); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func (s *joinStore) GetTransaction(id string) (*models.Transaction, bool) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	entry, ok := s.transactions[id]
-	if !ok || time.Since(entry.storedAt) > s.ttl {
-		return nil, false
+func (s *joinStore) put(ctx context.Context, key string, entry *joinEntry) error {
+	raw, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("encoding join entry: %w", err)
 	}
-	return entry.txn, true
+	return s.store.Put(ctx, entry.Partition, key, raw)
 }
 
-func (s *joinStore) GetFraudResult(id string) (*models.FraudResult, bool) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	entry, ok := s.fraudResults[id]
-	if !ok || time.Since(entry.storedAt) > s.ttl {
-		return nil, false
+// get returns the entry for key if present and not expired.
+func (s *joinStore) get(key string) (*joinEntry, bool, error) {
+	entry, ok, err := s.load(key)
+	if err != nil || !ok || time.Since(entry.StoredAt) > s.ttl {
+		return nil, false, err
 	}
-	return entry.result, true
+	return entry, true, nil
 }
 
-func (s *joinStore) Delete(id string) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	delete(s.transactions, id)
-	delete(s.fraudResults, id)
+func (s *joinStore) load(key string) (*joinEntry, bool, error) {
+	raw, ok, err := s.store.Get(key)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	var entry joinEntry
+	if err := json.Unmarshal(raw, &entry); err != nil {
+		return nil, false, fmt.Errorf("decoding join entry %s: %w", key, err)
+	}
+	return &entry, true, nil
 }
 
-func (s *joinStore) evictLoop() {
+func (s *joinStore) evictLoop(ctx context.Context) {
 	ticker := time.NewTicker(30 * time.Second)
-	for range ticker.C {
-		s.mu.Lock()
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		// Collect first, delete after: ForEach may hold a read transaction
+		// that a nested write would deadlock against.
+		var expired []string
 		now := time.Now()
-		for id, entry := range s.transactions {
-			if now.Sub(entry.storedAt) > s.ttl {
-				delete(s.transactions, id)
+		err := s.store.ForEach(func(key string, raw []byte) error {
+			var entry joinEntry
+			if err := json.Unmarshal(raw, &entry); err != nil {
+				return nil // leave undecodable entries for inspection
+			}
+			if now.Sub(entry.StoredAt) > s.ttl {
+				expired = append(expired, key)
 			}
+			return nil
+		})
+		if err != nil {
+			s.logger.Error("scanning join store for eviction", zap.Error(err))
+			continue
 		}
 
-		for id, entry := range s.fraudResults {
-			if now.Sub(entry.storedAt) > s.ttl {
-				delete(s.fraudResults, id)
+		for _, key := range expired {
+			if err := s.evict(ctx, key); err != nil {
+				s.logger.Error("evicting join entry", zap.String("key", key), zap.Error(err))
 			}
 		}
-		s.mu.Unlock()
 	}
 }
+
+// evict re-checks key under the transaction lock — its partner may have
+// arrived since the scan — and hands it to onExpire before deleting it.
+//
+// The entry's partition is held throughout: if it was revoked since the scan,
+// its new owner expires the entry — emitting it here too would report a
+// transaction unjoined twice, or one that its new owner did join.
+//
+// In exactly_once mode the unjoined record and the tombstone commit together.
+// The producer transaction is opened BEFORE taking the lock: handlers already
+// hold a transaction when they lock, so the opposite order would deadlock.
+func (s *joinStore) evict(ctx context.Context, key string) error {
+	id := strings.TrimPrefix(strings.TrimPrefix(key, txnKeyPrefix), fraudKeyPrefix)
+
+	return s.producer.Transact(ctx, func(ctx context.Context) error {
+		unlock := s.Lock(ctx, id)
+		defer unlock()
+
+		entry, ok, err := s.load(key)
+		if err != nil || !ok || time.Since(entry.StoredAt) <= s.ttl {
+			return err
+		}
+		release, owned := s.store.Hold(entry.Partition)
+		if !owned {
+			return nil
+		}
+		defer kafkapkg.AtTxnEnd(ctx, release)
+		if err := s.onExpire(ctx, entry); err != nil {
+			return err
+		}
+		return s.store.Delete(ctx, entry.Partition, key)
+	})
+}
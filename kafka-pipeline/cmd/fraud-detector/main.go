@@ -2,19 +2,21 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"flag"
 	"fmt"
-	"math"
 	"time"
 
 	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/config"
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/features"
 	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/health"
 	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/kafka"
 	kafkapkg "github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/kafka"
 	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/middleware"
 	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/models"
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/rules"
 	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/runner"
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/serde"
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/statestore"
 	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/pkg/circuitbreaker"
 	"go.uber.org/zap"
 )
@@ -29,33 +31,76 @@ func main() {
 	configPath := flag.String("config", "configs/config.yaml", "path to string file")
 	flag.Parse()
 
-	runner.Run(*configPath, func(ctx context.Context, cfg *config.Config, producer *kafka.Producer, logger *zap.Logger, healthSrv *health.Server) error {
+	runner.Run(*configPath, func(ctx context.Context, cfg *config.Config, producer *kafka.Producer, codec serde.Deserializer, logger *zap.Logger, healthSrv *health.Server) error {
 		logger = logger.Named("fraud-detector")
 
 		cb := circuitbreaker.New(circuitbreaker.Config{
-			Name:             "fraud_scoring_api",
-			FailureThreshold: 5,
-			SuccessThreshold: 2,
-			Timeout:          30 * time.Second,
+			Name:                 "fraud_scoring_api",
+			Window:               30 * time.Second,
+			MinRequests:          20,
+			FailureRateThreshold: 0.5,
+			SlowCallDuration:     time.Second,
+			Timeout:              30 * time.Second,
+			SuccessThreshold:     2,
+			OnStateChange: func(name string, from, to circuitbreaker.State) {
+				logger.Warn("circuit breaker state changed",
+					zap.String("breaker", name),
+					zap.Stringer("from", from),
+					zap.Stringer("to", to),
+				)
+			},
 		})
 
+		engine, err := rules.NewEngine("primary", cfg.FraudDetector.RulesFile, transactionSchema, logger.Named("rules"))
+		if err != nil {
+			return fmt.Errorf("loading fraud rules: %w", err)
+		}
+		go engine.Watch(ctx, cfg.FraudDetector.ReloadInterval)
+		logger.Info("fraud rules loaded", zap.String("version", engine.Current().Version))
+		shadows, err := newShadowRunner(ctx, cfg, producer, logger.Named("shadow"))
+		if err != nil {
+			return err
+		}
+		defer shadows.Close()
+
+		local, err := statestore.Open(cfg.StateStore, "fraud-detector-velocity")
+		if err != nil {
+			return fmt.Errorf("opening velocity store: %w", err)
+		}
+		changelog := statestore.NewChangelogStore(local, producer, &cfg.Kafka, cfg.Kafka.Topics.VelocityChangelog.Name, logger)
+		defer changelog.Close()
+		velocity := features.NewVelocityStore(ctx, changelog, producer, logger)
+
+		inputTopic := cfg.Kafka.Topics.Transactions.Name
 		outputTopic := cfg.Kafka.Topics.FraudResults.Name
 
+		limiter := middleware.NewAdaptiveLimiter("fraud-detector", cfg.Kafka.Consumer.AdaptiveLimit, logger)
 		handler := middleware.Chain(
 			middleware.Recovery(logger),
 			middleware.Logging(logger),
+			middleware.RateLimit("fraud-detector", cfg.FraudDetector.RateLimit, logger),
+			middleware.AdaptiveLimit(limiter),
 			middleware.Timeout(10*time.Second),
 		)(func(ctx context.Context, key []byte, value []byte, headers map[string]string) error {
 			var txn models.Transaction
-			if err := json.Unmarshal(value, &txn); err != nil {
+			if err := codec.Deserialize(inputTopic, value, &txn); err != nil {
 				return fmt.Errorf("deserializing transaction: %w", err)
 			}
 
+			md, ok := kafkapkg.MetadataFromContext(ctx)
+			if !ok {
+				return fmt.Errorf("message metadata missing from context")
+			}
+			v, err := velocity.Observe(ctx, md.Partition, txn)
+			if err != nil {
+				return fmt.Errorf("updating velocity features: %w", err)
+			}
+
 			// Score through circuit breaker
 			var result models.FraudResult
-			err := cb.Execute(func() error {
+			err = cb.Execute(func() error {
 				var err error
-				result, err = scoreFraud(ctx, txn)
+				result, err = scoreFraud(ctx, txn, v, engine.Current())
 				return err
 			})
 			if err != nil {
@@ -69,6 +114,7 @@ func main() {
 			if err != nil {
 				return fmt.Errorf("producing fraud result: %w", err)
 			}
+			shadows.compare(ctx, txn, v, result)
 
 			logger.Debug("fraud evaluation complete",
 				zap.String("txn_id", txn.ID),
@@ -80,10 +126,14 @@ func main() {
 
 		// Create Consumer Group
 		consumerCfg := cfg.Kafka
-		consumerCfg.Consumer.GroupID = "fraud-detector-v1"
+		consumerCfg.Consumer.GroupID = config.FraudDetectorGroupID
+		// The store is partitioned like the input: keep partition p of every
+		// input and retry topic on the instance restoring its state.
+		consumerCfg.Consumer.CoPartitioned = true
 
 		cg, err := kafkapkg.NewConsumerGroup(
 			&consumerCfg,
+			cfg.Service,
 			[]string{cfg.Kafka.Topics.Transactions.Name},
 			handler,
 			producer, // DLQ Producer
@@ -93,69 +143,76 @@ func main() {
 		if err != nil {
 			return fmt.Errorf("creating consumer group: %w", err)
 		}
+		limiter.PauseFetches(cg)
 
-		// Register health checks.
-		healthSrv.RegisterReadinessCheck("kafka_consumer", func(ctx context.Context) error {
-			// In a real system, ping the broker or check consumer lag.
-			return nil
+		// Rebuild velocity state for the partitions we were just given before
+		// consuming any of them. Transactions are keyed by sender, so the
+		// changelog partition of a sender is its input partition.
+		cg.OnPartitionsAssigned(func(ctx context.Context, claims map[string][]int32) error {
+			return changelog.Restore(ctx, claims[inputTopic])
 		})
+		cg.OnPartitionsRevoked(changelog.Revoke)
+
+		// Register health checks.
+		healthSrv.RegisterReadinessCheck("consumer_lag", cg.CheckLag)
 		healthSrv.SetReady(true)
 
 		return cg.Run(ctx)
 	})
 }
 
-func scoreFraud(_ context.Context, txn models.Transaction) (models.FraudResult, error) {
-	var riskScore float64
-	var factors []string
-
-	// Factor 1 : Transaction Amount
-	if txn.Amount > 10_000 {
-		riskScore += 0.3
-		factors = append(factors, "high_amount")
-	} else if txn.Amount > 5_000 {
-		riskScore += 0.15
-		factors = append(factors, "elevated_amount")
-	}
-
-	// Factor 2: Refunds are higher risk
-	if txn.Type == models.TypeRefund {
-		riskScore += 0.2
-		factors = append(factors, "refund_type")
-	}
-
-	// Factor 3: Velocity check
-	// Randomly flag ~5% of transactions as velocity anomalies
-	if txn.Amount > 1000 && math.Mod(float64(txn.CreatedAt.UnixNano()), 20) == 0 {
-		riskScore += 0.25
-		factors = append(factors, "velocity_anomaly")
-	}
-
-	// Factor 4: Currency risk
-	highRiskCurrencies := map[string]float64{"EUR": 0.05, "USD": 0.03}
-	if bonus, ok := highRiskCurrencies[txn.Currency]; ok {
-		riskScore += bonus
-		factors = append(factors, "high_risk_currency")
-	}
+// transactionSchema is what fraud rules can refer to. Keep it in step with
+// transactionFacts. sender.* velocity features include the transaction being
+// scored.
+var transactionSchema = rules.Schema{
+	"amount":      rules.KindNumber,
+	"type":        rules.KindString,
+	"currency":    rules.KindString,
+	"sender_id":   rules.KindString,
+	"receiver_id": rules.KindString,
+	"metadata.*":  rules.KindString,
+
+	"sender.txn_count_1m":           rules.KindNumber,
+	"sender.txn_count_1h":           rules.KindNumber,
+	"sender.txn_count_24h":          rules.KindNumber,
+	"sender.amount_sum_1m":          rules.KindNumber,
+	"sender.amount_sum_1h":          rules.KindNumber,
+	"sender.amount_sum_24h":         rules.KindNumber,
+	"sender.distinct_receivers_24h": rules.KindNumber,
+	"sender.new_receiver":           rules.KindBool,
+}
 
-	// Clamp to [0, 1]
-	if riskScore > 1.0 {
-		riskScore = 1.0
+func transactionFacts(txn models.Transaction, v features.Velocity) rules.Facts {
+	facts := rules.Facts{
+		"amount":      txn.Amount,
+		"type":        string(txn.Type),
+		"currency":    txn.Currency,
+		"sender_id":   txn.SenderID,
+		"receiver_id": txn.ReceiverID,
+
+		"sender.txn_count_1m":           float64(v.Count1m),
+		"sender.txn_count_1h":           float64(v.Count1h),
+		"sender.txn_count_24h":          float64(v.Count24h),
+		"sender.amount_sum_1m":          v.Sum1m,
+		"sender.amount_sum_1h":          v.Sum1h,
+		"sender.amount_sum_24h":         v.Sum24h,
+		"sender.distinct_receivers_24h": float64(v.DistinctReceivers24h),
+		"sender.new_receiver":           v.NewReceiver,
 	}
-
-	decision := "APPROVE"
-	if riskScore >= 0.7 {
-		decision = "REJECT"
-	} else if riskScore >= 0.4 {
-		decision = "REVIEW"
+	for k, v := range txn.Metadata {
+		facts["metadata."+k] = v
 	}
+	return facts
+}
 
+func scoreFraud(_ context.Context, txn models.Transaction, v features.Velocity, rs *rules.RuleSet) (models.FraudResult, error) {
+	res := rs.Evaluate(transactionFacts(txn, v))
 	return models.FraudResult{
 		TransactionID: txn.ID,
-		RiskScore:     riskScore,
-		RiskFactors:   factors,
-		Decision:      decision,
+		RiskScore:     res.Score,
+		RiskFactors:   res.Factors,
+		Decision:      res.Decision,
 		EvluatedAt:    time.Now().UTC(),
-		ModelVersion:  "fraud-v2.3.1",
+		ModelVersion:  rs.Version,
 	}, nil
 }
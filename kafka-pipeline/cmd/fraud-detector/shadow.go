@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/config"
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/features"
+	kafkapkg "github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/kafka"
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/metrics"
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/models"
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/rules"
+	"go.uber.org/zap"
+)
+
+// -------------------------------------------------------------------------------
+// Shadow scoring runs candidate rule sets on live traffic next to the primary,
+// so a new model can be judged on real transactions before it decides any.
+//
+// Every transaction is scored by the primary and by each shadow, with the same
+// velocity features. Only the primary's FraudResult goes downstream. Each
+// shadow's verdict is written, next to the primary's, to the shadow results
+// topic and counted in the fraud_shadow metrics.
+//
+// A shadow must never hurt the primary path: its failures and panics are
+// logged and counted, not returned, and its records go through a producer of
+// their own in async mode, so they are sent without waiting for the broker.
+// That producer is never transactional, and a transaction only stages records
+// of the producer running it, so the handler's ctx does not pull shadow results
+// into the primary's commit: with exactly_once they are at-least-once, and a
+// transaction abort does not take them back.
+// -------------------------------------------------------------------------------
+type shadowScorer struct {
+	name   string
+	engine *rules.Engine
+}
+
+type shadowRunner struct {
+	scorers  []shadowScorer
+	producer *kafkapkg.Producer
+	topic    string
+	logger   *zap.Logger
+}
+
+// newShadowRunner loads the configured shadows. Their results are encoded like
+// those of primary, the service's producer.
+func newShadowRunner(ctx context.Context, cfg *config.Config, primary *kafkapkg.Producer, logger *zap.Logger) (*shadowRunner, error) {
+	r := &shadowRunner{
+		topic:  cfg.Kafka.Topics.ShadowResults.Name,
+		logger: logger,
+	}
+	for _, sh := range cfg.FraudDetector.Shadows {
+		engine, err := rules.NewEngine(sh.Name, sh.RulesFile, transactionSchema, logger.Named("rules"))
+		if err != nil {
+			return nil, fmt.Errorf("loading shadow %s: %w", sh.Name, err)
+		}
+		go engine.Watch(ctx, cfg.FraudDetector.ReloadInterval)
+		logger.Info("shadow scorer loaded", zap.String("shadow", sh.Name), zap.String("version", engine.Current().Version))
+		r.scorers = append(r.scorers, shadowScorer{name: sh.Name, engine: engine})
+	}
+	if len(r.scorers) == 0 {
+		return r, nil
+	}
+
+	producerCfg := cfg.Kafka
+	producerCfg.ExactlyOnce = false
+	producerCfg.Producer.Async = true
+	producer, err := kafkapkg.NewProducer(&producerCfg, logger.Named("producer"))
+	if err != nil {
+		return nil, fmt.Errorf("creating shadow results producer: %w", err)
+	}
+	producer.UseSerializer(primary.Serializer())
+	r.producer = producer
+	return r, nil
+}
+
+// Close flushes the shadow results still buffered.
+func (r *shadowRunner) Close() error {
+	if r.producer == nil {
+		return nil
+	}
+	return r.producer.Close()
+}
+
+// compare scores txn with every shadow and publishes each verdict next to
+// primary.
+func (r *shadowRunner) compare(ctx context.Context, txn models.Transaction, v features.Velocity, primary models.FraudResult) {
+	for _, sh := range r.scorers {
+		r.compareOne(ctx, sh, txn, v, primary)
+	}
+}
+
+func (r *shadowRunner) compareOne(ctx context.Context, sh shadowScorer, txn models.Transaction, v features.Velocity, primary models.FraudResult) {
+	defer func() {
+		if p := recover(); p != nil {
+			metrics.ShadowErrors.WithLabelValues(sh.name).Inc()
+			r.logger.Error("PANIC in shadow scorer", zap.String("shadow", sh.name), zap.Any("panic", p))
+		}
+	}()
+
+	result, err := scoreFraud(ctx, txn, v, sh.engine.Current())
+	if err != nil {
+		metrics.ShadowErrors.WithLabelValues(sh.name).Inc()
+		r.logger.Warn("shadow scoring failed", zap.String("shadow", sh.name), zap.String("txn_id", txn.ID), zap.Error(err))
+		return
+	}
+
+	delta := result.RiskScore - primary.RiskScore
+	metrics.ShadowDecisions.WithLabelValues(sh.name, primary.Decision, result.Decision).Inc()
+	metrics.ShadowScoreDelta.WithLabelValues(sh.name).Observe(delta)
+
+	record := models.ShadowResult{
+		TransactionID: txn.ID,
+		Shadow:        sh.name,
+		Primary:       primary,
+		Result:        result,
+		DecisionMatch: result.Decision == primary.Decision,
+		ScoreDelta:    delta,
+	}
+	_, err = r.producer.ProduceAsync(ctx, r.topic, txn.ID, record, map[string]string{
+		"source_transaction_id": txn.ID,
+		"shadow":                sh.name,
+	}, func(_ int32, _ int64, err error) {
+		if err != nil {
+			metrics.ShadowErrors.WithLabelValues(sh.name).Inc()
+			r.logger.Warn("producing shadow result failed", zap.String("shadow", sh.name), zap.String("txn_id", txn.ID), zap.Error(err))
+		}
+	})
+	if err != nil {
+		metrics.ShadowErrors.WithLabelValues(sh.name).Inc()
+		r.logger.Warn("producing shadow result failed", zap.String("shadow", sh.name), zap.String("txn_id", txn.ID), zap.Error(err))
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"flag"
 	"fmt"
 	"math/rand"
+	"sync/atomic"
 	"time"
 
 	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/config"
@@ -12,8 +13,10 @@ import (
 	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/kafka"
 	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/models"
 	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/runner"
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/serde"
 	"github.com/google/uuid"
 	"go.uber.org/zap"
+	"golang.org/x/time/rate"
 )
 
 // Ingester simulates an API gateway that receives payment transactions and
@@ -24,7 +27,7 @@ func main() {
 	tps := flag.Int("tps", 100, "transactions per second to generate")
 	flag.Parse()
 
-	runner.Run(*configPath, func(ctx context.Context, cfg *config.Config, producer *kafka.Producer, logger *zap.Logger, healthSrv *health.Server) error {
+	runner.Run(*configPath, func(ctx context.Context, cfg *config.Config, producer *kafka.Producer, codec serde.Deserializer, logger *zap.Logger, healthSrv *health.Server) error {
 		healthSrv.SetReady(true)
 		logger = logger.Named("ingester")
 		topic := cfg.Kafka.Topics.Transactions.Name
@@ -34,39 +37,60 @@ func main() {
 			zap.String("topic", topic),
 		)
 
-		ticker := time.NewTicker(time.Second / time.Duration(*tps))
-		defer ticker.Stop()
+		// The limiter paces records at exactly *tps, with no per-tick share to
+		// round down. Its burst of 10ms worth lets high rates through without
+		// a timer per record.
+		limiter := rate.NewLimiter(rate.Limit(*tps), max(1, *tps/100))
 
 		currencies := []string{"INR", "USD", "EUR", "GBP", "RUB"}
 		txnTypes := []models.TransactionType{models.TypeTransfer, models.TypePayment, models.TypeRefund}
 		userPool := generateUserPool(1000) // creating 1000 dummy users
 
-		var produced int64
-		for {
-			select {
-			case <-ctx.Done():
-				logger.Info("ingester shutting down", zap.Int64("total_produced", produced))
-				return nil
-			case <-ticker.C:
-				txn := generateTransaction(userPool, currencies, txnTypes)
-
-				_, _, err := producer.ProduceMessage(ctx, topic, txn.SenderID, txn, map[string]string{
-					"idempotency_key": txn.IdempotencyKey,
-					"schema_version":  "1",
-				})
-
-				if err != nil {
-					logger.Error("failed to produce transaction", zap.Error(err))
-					continue
-				}
+		// Delivery callbacks run on the producer's goroutine, hence atomics.
+		var produced, failed atomic.Int64
+		onDelivery := func(_ int32, _ int64, err error) {
+			if err != nil {
+				failed.Add(1)
+				logger.Error("failed to produce transaction", zap.Error(err))
+				return
+			}
+			if n := produced.Add(1); n%1000 == 0 {
+				logger.Info("ingestion progress", zap.Int64("total_produced", n))
+			}
+		}
 
-				produced++
+		for {
+			if err := limiter.Wait(ctx); err != nil {
+				break
+			}
 
-				if produced%1000 == 0 {
-					logger.Info("ingestion progress", zap.Int64("total_produced", produced))
+			txn := generateTransaction(userPool, currencies, txnTypes)
+
+			// With producer.async this returns once the record is buffered,
+			// and blocks only while max_buffered records are unacknowledged.
+			_, err := producer.ProduceAsync(ctx, topic, txn.SenderID, txn, map[string]string{
+				"idempotency_key": txn.IdempotencyKey,
+				"schema_version":  "1",
+			}, onDelivery)
+			if err != nil {
+				if ctx.Err() != nil {
+					break
 				}
+				failed.Add(1)
+				logger.Error("failed to produce transaction", zap.Error(err))
 			}
 		}
+
+		// Records still buffered are reported as Close flushes them, so the
+		// counters are final only after it.
+		if err := producer.Close(); err != nil {
+			logger.Error("failed to close producer", zap.Error(err))
+		}
+		logger.Info("ingester shut down",
+			zap.Int64("total_produced", produced.Load()),
+			zap.Int64("total_failed", failed.Load()),
+		)
+		return nil
 	})
 }
 
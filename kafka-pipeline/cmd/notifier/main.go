@@ -2,18 +2,23 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"time"
+	_ "time/tzdata" // users' quiet hours are in their own timezone; don't depend on the image having zoneinfo
 
 	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/config"
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/dedup"
 	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/health"
 	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/kafka"
 	kafkapkg "github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/kafka"
 	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/middleware"
 	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/models"
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/notify"
 	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/runner"
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/serde"
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/statestore"
 	"go.uber.org/zap"
 )
 
@@ -21,42 +26,121 @@ func main() {
 	configPath := flag.String("config", "configs/config.yaml", "path to config file")
 	flag.Parse()
 
-	runner.Run(*configPath, func(ctx context.Context, cfg *config.Config, producer *kafka.Producer, logger *zap.Logger, healthSrv *health.Server) error {
+	runner.Run(*configPath, func(ctx context.Context, cfg *config.Config, producer *kafka.Producer, codec serde.Deserializer, logger *zap.Logger, healthSrv *health.Server) error {
 		logger = logger.Named("notifier")
 
+		dispatcher, err := notify.NewDispatcher(cfg.Notifier, logger.Named("dispatch"))
+		if err != nil {
+			return fmt.Errorf("setting up notification channels: %w", err)
+		}
+		outputTopic := cfg.Kafka.Topics.Notifications.Name
+
+		// Every instance holds the preferences of every user: the recipients
+		// of a transaction are not co-partitioned with it.
+		prefsTopic := cfg.Kafka.Topics.NotificationPreferences.Name
+		prefsLocal, err := statestore.Open(cfg.StateStore, "notifier-preferences")
+		if err != nil {
+			return fmt.Errorf("opening preference store: %w", err)
+		}
+		prefsTable := statestore.NewGlobalTable(prefsLocal, &cfg.Kafka, prefsTopic, logger)
+		defer prefsTable.Close()
+		if err := prefsTable.Restore(ctx); err != nil {
+			return err
+		}
+		router := notify.NewRouter(notify.NewPreferenceTable(prefsTable, func(raw []byte, v any) error {
+			return codec.Deserialize(prefsTopic, raw, v)
+		}), dispatcher, logger.Named("preferences"))
+
+		// Stale preferences would send to channels users have since opted out
+		// of, so losing the topic stops the service instead of carrying on.
+		ctx, cancel := context.WithCancelCause(ctx)
+		defer cancel(nil)
+		go func() {
+			if err := prefsTable.Follow(ctx); err != nil {
+				cancel(err)
+			}
+		}()
+
+		seen, err := dedup.Open(cfg.Dedup, "notifier")
+		if err != nil {
+			return fmt.Errorf("opening dedup store: %w", err)
+		}
+		defer seen.Close()
+
+		limiter := middleware.NewAdaptiveLimiter("notifier", cfg.Kafka.Consumer.AdaptiveLimit, logger)
 		handler := middleware.Chain(
 			middleware.Recovery(logger),
 			middleware.Logging(logger),
+			middleware.AdaptiveLimit(limiter),
 			middleware.Timeout(10*time.Second),
-			middleware.Dedupilcation(logger, 1*time.Hour),
+			middleware.Dedupilcation(logger, seen),
 		)(func(ctx context.Context, key, value []byte, headers map[string]string) error {
 			var enriched models.EnrichedTransaction
-			if err := json.Unmarshal(value, &enriched); err != nil {
+			if err := codec.Deserialize(cfg.Kafka.Topics.EnrichedTransactions.Name, value, &enriched); err != nil {
 				return fmt.Errorf("deserializing enriched transaction: %w", err)
 			}
 
-			notifications := buildNotification(&enriched)
+			notifications, err := buildNotification(&enriched)
+			if err != nil {
+				return err
+			}
 
+			sent := 0
 			for _, notif := range notifications {
-				if err := sendNotification(ctx, notif, logger); err != nil {
+				notif, ok, err := router.Route(notif, time.Now())
+				if err != nil {
+					return err
+				}
+				if !ok {
+					logger.Debug("notification suppressed by user preferences",
+						zap.String("txn_id", notif.TransactionID),
+						zap.String("user_id", notif.UserID),
+						zap.String("template_id", notif.TemplateID),
+					)
+					continue
+				}
+
+				delivered, err := sendOnce(ctx, seen, dispatcher, notif, logger)
+				if err != nil {
 					return fmt.Errorf("sending notification to %s via %s: %w",
 						notif.UserID, notif.Channel, err)
 				}
+				if !delivered {
+					logger.Debug("notification already sent",
+						zap.String("txn_id", notif.TransactionID),
+						zap.String("user_id", notif.UserID),
+						zap.String("template_id", notif.TemplateID),
+					)
+					continue
+				}
+				notif.SentAt = time.Now().UTC()
+				sent++
+
+				// The delivery log: one record per notification that went out.
+				_, _, err = producer.ProduceMessage(ctx, outputTopic, notif.UserID, notif, map[string]string{
+					"source_transaction_id": notif.TransactionID,
+					"channel":               notif.Channel,
+				})
+				if err != nil {
+					return fmt.Errorf("producing notification record: %w", err)
+				}
 			}
 
 			logger.Info("notifications sent",
 				zap.String("txn_id", enriched.ID),
 				zap.String("status", string(enriched.Status)),
-				zap.Int("notification_count", len(notifications)),
+				zap.Int("notification_count", sent),
+				zap.Int("suppressed_count", len(notifications)-sent),
 			)
 			return nil
 		})
 
 		consumeCfg := cfg.Kafka
-		consumeCfg.Consumer.GroupID = "notifier-v1"
+		consumeCfg.Consumer.GroupID = config.NotifierGroupID
 
 		cg, err := kafkapkg.NewConsumerGroup(
 			&consumeCfg,
+			cfg.Service,
 			[]string{cfg.Kafka.Topics.EnrichedTransactions.Name},
 			handler,
 			producer,
@@ -66,102 +150,159 @@ func main() {
 		if err != nil {
 			return fmt.Errorf("creating consumer group: %w", err)
 		}
+		limiter.PauseFetches(cg)
 
+		healthSrv.RegisterReadinessCheck("consumer_lag", cg.CheckLag)
 		healthSrv.SetReady(true)
-		return cg.Run(ctx)
+		if err := cg.Run(ctx); err != nil {
+			return err
+		}
+		if cause := context.Cause(ctx); !errors.Is(cause, context.Canceled) {
+			return cause
+		}
+		return nil
 	})
 }
 
-func buildNotification(txn *models.EnrichedTransaction) []models.Notification {
-	var notifications []models.Notification
-	now := time.Now().UTC()
+// sendOnce sends notif unless seen says it already went out, and reports
+// whether it sent it. A transaction's notifications are sent one by one: when
+// one fails, the retry of the transaction must not send the earlier ones again.
+// The send is not undone by an aborted transaction, so its key is completed as
+// soon as it succeeds.
+func sendOnce(ctx context.Context, seen dedup.Store, dispatcher *notify.Dispatcher, notif models.Notification, logger *zap.Logger) (bool, error) {
+	key := "notification/" + notif.TransactionID + "/" + notif.UserID + "/" + notif.Channel + "/" + notif.TemplateID
+	status, err := seen.Claim(ctx, key)
+	if err != nil {
+		return false, kafka.DownstreamUnavailable(fmt.Errorf("checking notification key: %w", err))
+	}
+	switch status {
+	case dedup.Completed:
+		return false, nil
+	case dedup.InProgress:
+		return false, kafka.Transient(fmt.Errorf("%w: %s", middleware.ErrInProgress, key))
+	}
+
+	storeCtx := context.WithoutCancel(ctx)
+	if err := dispatcher.Send(ctx, notif); err != nil {
+		if relErr := seen.Release(storeCtx, key); relErr != nil {
+			// The claim lapses after its lease; retries wait until then.
+			logger.Warn("failed to release notification key", zap.String("key", key), zap.Error(relErr))
+		}
+		return false, err
+	}
+	if err := seen.Complete(storeCtx, key); err != nil {
+		// It went out: failing now would send it again.
+		logger.Warn("failed to mark notification sent", zap.String("key", key), zap.Error(err))
+	}
+	return true, nil
+}
+
+// buildNotification returns the notifications for txn, before user
+// preferences are applied. SentAt is set once each is actually delivered.
+// Every status is handled explicitly, so a new one fails loudly here instead
+// of silently notifying nobody.
+func buildNotification(txn *models.EnrichedTransaction) ([]models.Notification, error) {
+	amount := fmt.Sprintf("%.2f", txn.Amount)
 
 	switch txn.Status {
 	case models.StatusApproved:
-		notifications = append(notifications, models.Notification{
-			TransactionID: txn.ID,
-			UserID:        txn.SenderID,
-			Channel:       "push",
-			TemplateID:    "txn_sent_success",
-			Params: map[string]string{
-				"amount":   fmt.Sprintf("%.2f", txn.Amount),
-				"currency": txn.Currency,
-				"receiver": txn.ReceiverID,
+		return []models.Notification{
+			{
+				TransactionID: txn.ID,
+				UserID:        txn.SenderID,
+				Channel:       notify.ChannelPush,
+				TemplateID:    "txn_sent_success",
+				Params: map[string]string{
+					"amount":   amount,
+					"currency": txn.Currency,
+					"receiver": txn.ReceiverID,
+				},
 			},
-			SentAt: now,
-		},
-			models.Notification{
+			{
 				TransactionID: txn.ID,
 				UserID:        txn.ReceiverID,
-				Channel:       "push",
+				Channel:       notify.ChannelPush,
 				TemplateID:    "txn_received",
 				Params: map[string]string{
-					"amount":   fmt.Sprintf("%.2f", txn.Amount),
+					"amount":   amount,
 					"currency": txn.Currency,
 					"sender":   txn.SenderID,
 				},
-				SentAt: now,
 			},
-		)
+		}, nil
 
-	case models.StatusFailed:
-		notifications = append(notifications, models.Notification{
+	case models.StatusRejected:
+		// Declined by fraud screening. The reason stays generic: telling a
+		// fraudster which check fired helps them get around it.
+		return []models.Notification{{
 			TransactionID: txn.ID,
 			UserID:        txn.SenderID,
-			Channel:       "email",
+			Channel:       notify.ChannelEmail,
 			TemplateID:    "txn_rejected",
 			Params: map[string]string{
-				"amount":   fmt.Sprintf("%.2f", txn.Amount),
+				"amount":   amount,
 				"currency": txn.Currency,
-				"reason":   "Transaction flagged by security review",
+				"reason":   "The payment did not pass our security checks.",
 			},
-			SentAt: now,
-		})
+		}}, nil
+
+	case models.StatusFailed:
+		return []models.Notification{{
+			TransactionID: txn.ID,
+			UserID:        txn.SenderID,
+			Channel:       notify.ChannelEmail,
+			TemplateID:    "txn_failed",
+			Params: map[string]string{
+				"amount":   amount,
+				"currency": txn.Currency,
+			},
+		}}, nil
 
 	case models.StatusFlagged:
 		// Notify sender + internal fraud team.
-		notifications = append(notifications,
-			models.Notification{
+		return []models.Notification{
+			{
 				TransactionID: txn.ID,
 				UserID:        txn.SenderID,
-				Channel:       "sms",
+				Channel:       notify.ChannelSMS,
 				TemplateID:    "txn_under_review",
 				Params: map[string]string{
-					"amount":   fmt.Sprintf("%.2f", txn.Amount),
+					"amount":   amount,
 					"currency": txn.Currency,
 				},
-				SentAt: now,
 			},
-			models.Notification{
+			{
 				TransactionID: txn.ID,
 				UserID:        "fraud-team",
-				Channel:       "email",
+				Channel:       notify.ChannelEmail,
 				TemplateID:    "fraud_review_needed",
 				Params: map[string]string{
 					"txn_id":    txn.ID,
-					"amount":    fmt.Sprintf("%.2f", txn.Amount),
+					"amount":    amount,
+					"currency":  txn.Currency,
 					"risk_tier": txn.SenderRiskTier,
 					"sender":    txn.SenderID,
 				},
-				SentAt: now,
 			},
-		)
-	}
+		}, nil
 
-	return notifications
-}
+	case models.StatusCompleted:
+		return []models.Notification{{
+			TransactionID: txn.ID,
+			UserID:        txn.SenderID,
+			Channel:       notify.ChannelPush,
+			TemplateID:    "txn_completed",
+			Params: map[string]string{
+				"amount":   amount,
+				"currency": txn.Currency,
+			},
+		}}, nil
 
-func sendNotification(ctx context.Context, notif models.Notification, logger *zap.Logger) error {
-	select {
-	case <-ctx.Done():
-		return ctx.Err()
-	case <-time.After(5 * time.Millisecond):
-	}
+	case models.StatusPending, models.StatusEnriched:
+		// Intermediate states: the user hears about the outcome, not the steps.
+		return nil, nil
 
-	logger.Debug("notification dispatched",
-		zap.String("user", notif.UserID),
-		zap.String("channel", notif.Channel),
-		zap.String("template", notif.TemplateID),
-	)
-	return nil
+	default:
+		return nil, kafka.Poison(fmt.Errorf("unknown transaction status %q", txn.Status))
+	}
 }
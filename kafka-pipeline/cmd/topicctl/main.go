@@ -0,0 +1,114 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/config"
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/kafka"
+	"go.uber.org/zap"
+)
+
+// -------------------------------------------------------------------------------
+// topicctl reconciles the cluster's topics with the ones declared in a
+// service's config — kafka.topics, plus the retry topics of every service's
+// consumer group (config.ServiceGroupIDs).
+//
+//	topicctl plan  [-config path]   # show how the cluster differs, change nothing
+//	topicctl apply [-config path]   # make the safe changes
+//	topicctl apply -allow-partition-increase   # ...and add partitions
+//
+// Services only create missing topics at startup (unless kafka.topic_drift is
+// "apply"), so a retention or other config change in config.yaml lands here:
+// run plan, review it, run apply.
+//
+// Unsafe changes — fewer partitions, more partitions on a compacted topic, a
+// new replication factor or cleanup policy — are never applied; see
+// kafka.TopicPlan. apply exits 1 while any are left, so they do not go
+// unnoticed in CI. More partitions on other topics move keys, so they count as
+// unsafe too unless -allow-partition-increase asks for them: stop the
+// consumers, and declare the new count on every co-partitioned topic — retry
+// topics included — in the same change.
+// -------------------------------------------------------------------------------
+
+func main() {
+	if len(os.Args) < 2 || (os.Args[1] != "plan" && os.Args[1] != "apply") {
+		fmt.Fprintln(os.Stderr, "usage: topicctl <plan|apply> [flags]")
+		os.Exit(2)
+	}
+	cmd := os.Args[1]
+
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	configPath := fs.String("config", "configs/config.yaml", "path to config file")
+	allowIncrease := fs.Bool("allow-partition-increase", false, "add partitions to topics that are not compacted (keys move: stop their consumers first)")
+	fs.Parse(os.Args[2:])
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load config: %v\n", err)
+		os.Exit(1)
+	}
+
+	logger, _ := zap.NewDevelopment()
+	defer logger.Sync()
+	logger = logger.Named("topicctl")
+
+	admin, err := kafka.NewTopicAdmin(&cfg.Kafka, logger.Named("admin"))
+	if err != nil {
+		logger.Error("failed to connect to kafka", zap.Error(err))
+		os.Exit(1)
+	}
+	defer admin.Close()
+	if *allowIncrease {
+		admin.AllowPartitionIncreases()
+	}
+
+	topics := cfg.Kafka.Topics.All()
+	for _, groupID := range config.ServiceGroupIDs {
+		consumerCfg := cfg.Kafka.Consumer
+		consumerCfg.GroupID = groupID
+		topics = append(topics, kafka.RetryTopicDefs(&consumerCfg)...)
+	}
+	plan, err := admin.Plan(topics)
+	if err != nil {
+		logger.Error("planning failed", zap.Error(err))
+		os.Exit(1)
+	}
+	printPlan(plan)
+
+	if cmd == "apply" {
+		if err := admin.Apply(plan); err != nil {
+			logger.Error("apply failed", zap.Error(err))
+			os.Exit(1)
+		}
+	}
+
+	if unsafe := plan.Unsafe(); cmd == "apply" && len(unsafe) > 0 {
+		fmt.Fprintf(os.Stderr, "%d unsafe change(s) refused — make them by hand\n", len(unsafe))
+		os.Exit(1)
+	}
+}
+
+func printPlan(plan *kafka.TopicPlan) {
+	if len(plan.Changes) == 0 {
+		fmt.Println("All topics match their declarations.")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TOPIC\tSETTING\tCURRENT\tDESIRED\tACTION")
+	for _, c := range plan.Changes {
+		action := "apply"
+		if !c.Safe() {
+			action = "REFUSED: " + c.Unsafe
+		}
+		current := c.Current
+		if current == "" {
+			current = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", c.Topic, c.Setting, current, c.Desired, action)
+	}
+	w.Flush()
+}
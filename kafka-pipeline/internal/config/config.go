@@ -2,6 +2,7 @@ package config
 
 import (
 	"fmt"
+	"math"
 	"os"
 	"strconv"
 	"strings"
@@ -17,10 +18,17 @@ import (
 // version-control non-sensitive config while keeping secrets out of git.
 // -------------------------------------------------------------------------------
 type Config struct {
-	Service ServiceConfig `yaml:"service"`
-	Kafka   KafkaConfig   `yaml:"kafka"`
-	Metrics MetricsConfig `yaml:"metrics"`
-	Health  HealthConfig  `yaml:"health"`
+	Service        ServiceConfig        `yaml:"service"`
+	Kafka          KafkaConfig          `yaml:"kafka"`
+	SchemaRegistry SchemaRegistryConfig `yaml:"schema_registry"`
+	StateStore     StateStoreConfig     `yaml:"state_store"`
+	Dedup          DedupConfig          `yaml:"dedup"`
+	FraudDetector  FraudDetectorConfig  `yaml:"fraud_detector"`
+	Enricher       EnricherConfig       `yaml:"enricher"`
+	Notifier       NotifierConfig       `yaml:"notifier"`
+	Tracing        TracingConfig        `yaml:"tracing"`
+	Metrics        MetricsConfig        `yaml:"metrics"`
+	Health         HealthConfig         `yaml:"health"`
 }
 
 type ServiceConfig struct {
@@ -36,6 +44,12 @@ type KafkaConfig struct {
 	SASLUsername     string   `yaml:"sasl_username"`
 	SASLPassword     string   `yaml:"sasl_password"`
 
+	// ExactlyOnce: consume-transform-produce inside Kafka transactions. Output
+	// records and consumer offsets commit atomically, and consumers only read
+	// committed data. Enable it per service: every produce becomes part of a
+	// transaction, which costs latency and serializes processing.
+	ExactlyOnce bool `yaml:"exactly_once"`
+
 	// Producer tuning
 	Producer ProducerConfig `yaml:"producer"`
 
@@ -45,8 +59,24 @@ type KafkaConfig struct {
 	// Topic declarations. We create topics programmatically rather than
 	// relying on auto-creation, which is a ticking time bomb in prod
 	Topics TopicConfig `yaml:"topics"`
+
+	// TopicDrift: what startup does about existing topics that no longer
+	// match their declaration — TopicDriftReport (default) or TopicDriftApply.
+	// Missing topics are created either way.
+	TopicDrift string `yaml:"topic_drift"`
 }
 
+// What startup does about topic drift.
+const (
+	// TopicDriftReport logs drift and exports it as metrics; cmd/topicctl
+	// applies it.
+	TopicDriftReport = "report"
+	// TopicDriftApply also applies the safe changes — config updates.
+	// Unsafe ones, partition changes among them, are only reported; more
+	// partitions take topicctl apply -allow-partition-increase.
+	TopicDriftApply = "apply"
+)
+
 type ProducerConfig struct {
 	RequiredAcks int           `yaml:"required_acks"`
 	MaxRetries   int           `yaml:"max_retries"`
@@ -66,8 +96,32 @@ type ProducerConfig struct {
 	// MaxInFlight: with idempotent=true, Kafka guarantees ordering even with
 	// MaxInFlight=5. Without idempotent, set this to 1 or accept reordering.
 	MaxInFlight int `yaml:"max_in_flight"`
+	// Async: produce through sarama's AsyncProducer. ProduceAsync returns as
+	// soon as the record is buffered; ProduceMessage still waits for the ack,
+	// but concurrent callers share batches. Not compatible with exactly_once.
+	Async bool `yaml:"async"`
+	// MaxBuffered: async mode only — records handed to the producer but not yet
+	// acknowledged. When full, producing blocks (backpressure) instead of
+	// buffering without bound.
+	MaxBuffered int `yaml:"max_buffered"`
+	// TransactionalID: identity of the transactional producer when
+	// kafka.exactly_once is on. Must be unique per instance AND stable across
+	// its restarts, so the broker can fence a zombie predecessor.
+	// Defaults to <service.name>-<hostname> (the pod name on Kubernetes).
+	TransactionalID string `yaml:"transactional_id"`
 }
 
+// Consumer groups of the pipeline services. Each service sets its own in
+// place of kafka.consumer.group_id; topicctl derives their retry topics.
+const (
+	EnricherGroupID      = "enricher-v1"
+	FraudDetectorGroupID = "fraud-detector-v1"
+	NotifierGroupID      = "notifier-v1"
+)
+
+// ServiceGroupIDs lists every consumer group above.
+var ServiceGroupIDs = []string{EnricherGroupID, FraudDetectorGroupID, NotifierGroupID}
+
 type ConsumerConfig struct {
 	GroupID string `yaml:"group_id"`
 	// SessionTimeout: how long a consumer can be "silent" before the broker
@@ -93,13 +147,73 @@ type ConsumerConfig struct {
 	FetchMinBytes int           `yaml:"fetch_min_bytes"`
 	FetchMaxWait  time.Duration `yaml:"fetch_max_wait"`
 	// MaxProcessingWorkers: number of goroutines processing messages per partition.
-	// >1 means you lose ordering within a partition — only do this if ordering
-	// doesn't matter for your use case.
+	// Messages are routed to workers by key, so ordering is preserved per key
+	// but NOT across keys within a partition. Offsets are committed only up to
+	// the highest contiguous completed message.
 	MaxProcessingWorkers int `yaml:"max_processing_workers"`
 	// DLQ settings
-	DLQTopic     string        `yaml:"dlq_topic"`
+	DLQTopic string `yaml:"dlq_topic"`
+	// MaxRetries / RetryBackoff: in-partition retries with exponential backoff.
+	// Only used when RetryTiers is empty — they block the partition while sleeping.
 	MaxRetries   int           `yaml:"max_retries"`
 	RetryBackoff time.Duration `yaml:"retry_backoff"`
+	// RetryTiers: delays of the non-blocking retry topics, e.g. [1m, 10m].
+	// A failed message is forwarded to <group_id>.retry-<delay> for each tier
+	// in turn and lands in the DLQ after the last one.
+	RetryTiers []time.Duration `yaml:"retry_tiers"`
+	// RetryTopic: template for the retry tier topics. Name is ignored; each
+	// tier's name is derived from the group ID and delay. Partitions MUST
+	// match the topics the groups consume: a retried record goes back to the
+	// partition number it was read from. Consumers check it at startup.
+	RetryTopic TopicDef `yaml:"retry_topic"`
+	// CoPartitioned: the group keeps state per input partition, so partition
+	// p of every topic it reads — retry tiers included — must go to the same
+	// instance. Stateful services set it in code; it replaces the sticky
+	// assignor with the range assignor. Members with different assignors
+	// cannot share a group: switching needs every instance restarted at once.
+	CoPartitioned bool `yaml:"-"`
+	// CommitInterval: how often marked offsets are committed. A crash replays
+	// at most this much processed work.
+	CommitInterval time.Duration `yaml:"commit_interval"`
+	// LagCheck: thresholds for the consumer's readiness check.
+	LagCheck LagCheckConfig `yaml:"lag_check"`
+	// AdaptiveLimit: concurrency limit for handlers wrapped in
+	// middleware.AdaptiveLimit.
+	AdaptiveLimit AdaptiveLimitConfig `yaml:"adaptive_limit"`
+}
+
+// AdaptiveLimitConfig tunes the adaptive concurrency limit: how many messages
+// the handler may process at once. The limit grows while handler latency
+// stays near its long-run average and shrinks when latency climbs or the
+// downstream fails; once reached, fetching pauses until a slot frees up.
+type AdaptiveLimitConfig struct {
+	Enabled      bool `yaml:"enabled"`
+	InitialLimit int  `yaml:"initial_limit"` // default: 10
+	MinLimit     int  `yaml:"min_limit"`     // default: 1
+	MaxLimit     int  `yaml:"max_limit"`     // default: 200
+	// Tolerance: how much slower than its long-run average the handler may
+	// get before the limit shrinks. 2 = twice as slow (default).
+	Tolerance float64 `yaml:"tolerance"`
+	// Smoothing: how far each sample moves the limit toward its new value,
+	// 0–1 (default: 0.2).
+	Smoothing float64 `yaml:"smoothing"`
+	// BackoffRatio: the limit is multiplied by this on every retryable
+	// failure (default: 0.9).
+	BackoffRatio float64 `yaml:"backoff_ratio"`
+}
+
+// LagCheckConfig controls the background lag monitor. It compares committed
+// offsets with high-water marks for the partitions this instance owns, and
+// the readiness check fails once either threshold is crossed.
+type LagCheckConfig struct {
+	// Interval: how often offsets are fetched from the cluster.
+	Interval time.Duration `yaml:"interval"`
+	// MaxLag: most uncommitted records any one partition may have. 0 = no limit.
+	MaxLag int64 `yaml:"max_lag"`
+	// MaxCommitAge: how long a partition may receive new records without its
+	// committed offset moving. 0 = no limit. Retry tier partitions get their
+	// tier delay on top.
+	MaxCommitAge time.Duration `yaml:"max_commit_age"`
 }
 
 type TopicConfig struct {
@@ -108,6 +222,43 @@ type TopicConfig struct {
 	EnrichedTransactions TopicDef `yaml:"enriched_transactions"`
 	Notifications        TopicDef `yaml:"notifications"`
 	DLQ                  TopicDef `yaml:"dlq"`
+	// JoinChangelog backs the enricher's join store. It MUST be compacted and
+	// have the same partition count as the topics the enricher consumes.
+	JoinChangelog TopicDef `yaml:"join_changelog"`
+	// VelocityChangelog backs the fraud-detector's per-sender velocity store.
+	// It MUST be compacted and have the same partition count as Transactions.
+	VelocityChangelog TopicDef `yaml:"velocity_changelog"`
+	// ShadowResults receives the verdicts of shadow fraud scorers next to the
+	// primary's, for offline comparison.
+	ShadowResults TopicDef `yaml:"shadow_results"`
+	// Unjoined receives transactions whose fraud result never arrived within
+	// the join window, so they can be reconciled instead of silently dropped.
+	Unjoined TopicDef `yaml:"unjoined"`
+	// NotificationPreferences holds each user's latest notification settings,
+	// keyed by user ID. Written by the profile service; MUST be compacted —
+	// the notifier loads all of it at startup.
+	NotificationPreferences TopicDef `yaml:"notification_preferences"`
+	// RiskTiers holds each user's latest receiver risk tier, keyed by user ID.
+	// Written by the risk team's scoring jobs; MUST be compacted — the
+	// enricher loads all of it at startup.
+	RiskTiers TopicDef `yaml:"risk_tiers"`
+}
+
+// All returns every declared topic.
+func (t *TopicConfig) All() []TopicDef {
+	return []TopicDef{
+		t.Transactions,
+		t.FraudResults,
+		t.EnrichedTransactions,
+		t.Notifications,
+		t.DLQ,
+		t.JoinChangelog,
+		t.VelocityChangelog,
+		t.ShadowResults,
+		t.Unjoined,
+		t.NotificationPreferences,
+		t.RiskTiers,
+	}
 }
 
 type TopicDef struct {
@@ -120,6 +271,202 @@ type TopicDef struct {
 	ExtraConfig       map[string]string `yaml:"extra_config,omitempty"`
 }
 
+// SchemaRegistryConfig controls how record values are serialized. Without a
+// URL every topic is plain JSON.
+type SchemaRegistryConfig struct {
+	// URL of a Confluent-compatible schema registry.
+	URL      string        `yaml:"url"`
+	Username string        `yaml:"username"`
+	Password string        `yaml:"password"`
+	Timeout  time.Duration `yaml:"timeout"`
+	// AutoRegister: register new schema versions at startup once they pass the
+	// compatibility check. Keep it off in prod and register schemas from CI,
+	// so no running service can publish a schema by accident.
+	AutoRegister bool `yaml:"auto_register"`
+	// Schemas: topic name → schema file in internal/models/schemas. The
+	// extension picks the format: .avsc (Avro), .proto (Protobuf — producers
+	// must then pass generated messages), .json (JSON Schema). Topics not
+	// listed stay plain JSON.
+	Schemas map[string]string `yaml:"schemas"`
+}
+
+// StateStoreConfig selects the local backend for stateful processors. Whatever
+// the backend, state is rebuilt from its changelog topic on partition assignment.
+type StateStoreConfig struct {
+	// Backend: "memory" (rebuilt from the changelog on every start) or "bolt"
+	// (embedded on-disk store, survives restarts on the same host).
+	Backend string `yaml:"backend"`
+	// Dir: where on-disk stores keep their files. Mount a volume here.
+	Dir string `yaml:"dir"`
+}
+
+// DedupConfig selects where the deduplication middleware remembers the
+// idempotency keys it has processed.
+type DedupConfig struct {
+	// Backend: "memory" (one process, forgotten on restart), "bolt" (on disk,
+	// one host) or "redis" (shared by every replica). Only redis catches a
+	// duplicate delivered to another replica after a rebalance.
+	Backend string `yaml:"backend"`
+	// Dir: where the bolt backend keeps its files. Defaults to state_store.dir.
+	Dir string `yaml:"dir"`
+	// TTL: how long a processed key is remembered. Cover the longest
+	// redelivery path — retry tiers included.
+	TTL time.Duration `yaml:"ttl"`
+	// Lease: how long a key stays claimed while its handler runs. MUST exceed
+	// the handler timeout.
+	Lease time.Duration `yaml:"lease"`
+	Redis RedisConfig   `yaml:"redis"`
+}
+
+type RedisConfig struct {
+	// Addr: host:port.
+	Addr     string        `yaml:"addr"`
+	Username string        `yaml:"username"`
+	Password string        `yaml:"password"`
+	DB       int           `yaml:"db"`
+	Timeout  time.Duration `yaml:"timeout"`
+	// KeyPrefix is prepended to every key, ahead of the consumer's name.
+	KeyPrefix string `yaml:"key_prefix"`
+}
+
+type FraudDetectorConfig struct {
+	// RulesFile: the YAML rule set the fraud-detector scores with. Its
+	// version is stamped into every FraudResult.ModelVersion.
+	RulesFile string `yaml:"rules_file"`
+	// ReloadInterval: how often the rules files are checked for changes.
+	ReloadInterval time.Duration `yaml:"reload_interval"`
+	// Shadows: candidate rule sets scored on live traffic next to RulesFile.
+	// Their verdicts only go to the shadow results topic; nothing downstream
+	// acts on them. Promote one by making it the rules_file.
+	Shadows []ShadowScorerConfig `yaml:"shadows"`
+	// RateLimit: per-sender quotas, so one hot sender cannot flood the
+	// downstream scoring dependencies.
+	RateLimit RateLimitConfig `yaml:"rate_limit"`
+}
+
+// Actions for a message whose key is over its quota.
+const (
+	// RateLimitDelay waits for the key's next token. The partition worker
+	// waits with it, so other keys behind it on the partition wait too.
+	RateLimitDelay = "delay"
+	// RateLimitRetry republishes the message to the retry topics and moves
+	// on. Needs kafka.consumer.retry_tiers.
+	RateLimitRetry = "retry"
+)
+
+// RateLimitConfig configures middleware.RateLimit: a token bucket per key.
+type RateLimitConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// KeyHeader: the header holding the key to limit by, e.g. "tenant_id".
+	// Empty = the record key.
+	KeyHeader string `yaml:"key_header"`
+	// Default: the quota of every key without an override.
+	Default Quota `yaml:"default"`
+	// Overrides: per-key quotas, e.g. for a known high-volume merchant.
+	Overrides map[string]Quota `yaml:"overrides"`
+	// OnExceeded: RateLimitDelay (default) or RateLimitRetry. Delay keeps
+	// per-key order but stalls the partition; retry keeps the partition moving
+	// but processes the key's limited messages after its later ones.
+	OnExceeded string `yaml:"on_exceeded"`
+	// IdleTTL: a key's bucket is forgotten after this long unused, which
+	// refills it (default: 10m).
+	IdleTTL time.Duration `yaml:"idle_ttl"`
+}
+
+// Quota is a token bucket: Rate messages per second on average, up to Burst
+// at once. Rate 0 exempts the key.
+type Quota struct {
+	Rate  float64 `yaml:"rate"`
+	Burst int     `yaml:"burst"` // default: Rate, at least 1
+}
+
+type ShadowScorerConfig struct {
+	// Name identifies the shadow in metrics and shadow results.
+	Name      string `yaml:"name"`
+	RulesFile string `yaml:"rules_file"`
+}
+
+type EnricherConfig struct {
+	// JoinWindow: how long either side of the transaction/fraud-result join
+	// waits for the other. Size it to the worst-case fraud-detector lag, not
+	// the average — anything later is an unjoined transaction.
+	JoinWindow time.Duration `yaml:"join_window"`
+	// EmitUnjoined: write transactions that expire unmatched to the unjoined
+	// topic. When false they are only counted and logged.
+	EmitUnjoined bool `yaml:"emit_unjoined"`
+	// Lookups configures the reference data enriched events are decorated
+	// with. Receiver risk tiers come from the RiskTiers topic.
+	Lookups EnrichmentConfig `yaml:"lookups"`
+}
+
+type EnrichmentConfig struct {
+	// GeoIPFile: "<cidr>,<location>" lines mapping client IPs to locations.
+	GeoIPFile string `yaml:"geoip_file"`
+	// MerchantCategoriesFile: merchant ID → MCC, see enrichment.MerchantCategories.
+	MerchantCategoriesFile string `yaml:"merchant_categories_file"`
+	// CacheTTL: how long a looked-up value is reused. A risk tier change
+	// reaches enriched events within this long.
+	CacheTTL time.Duration `yaml:"cache_ttl"`
+}
+
+// MockEndpoint as a notifier endpoint logs messages instead of delivering
+// them. Validate accepts it only when service.env is dev.
+const MockEndpoint = "mock://"
+
+// NotifierConfig configures the notifier's delivery channels. A channel whose
+// endpoint is empty is disabled: notifications for it fail as poison and land
+// in the DLQ.
+type NotifierConfig struct {
+	// TemplatesDir holds one <channel>/<template_id>.tmpl file per template.
+	TemplatesDir string             `yaml:"templates_dir"`
+	Email        EmailChannelConfig `yaml:"email"`
+	SMS          HTTPChannelConfig  `yaml:"sms"`
+	Push         HTTPChannelConfig  `yaml:"push"`
+}
+
+// ChannelLimits protect a provider from us. RateLimit is in messages per
+// second (0 = unlimited); deliveries over the limit wait for a token.
+type ChannelLimits struct {
+	RateLimit float64 `yaml:"rate_limit"`
+	Burst     int     `yaml:"burst"`
+}
+
+type EmailChannelConfig struct {
+	ChannelLimits `yaml:",inline"`
+	// SMTPAddr: host:port of the relay. STARTTLS is used when offered.
+	SMTPAddr string `yaml:"smtp_addr"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	From     string `yaml:"from"`
+	// AddressFormat turns a user ID into an address, e.g. "%s@users.example.com".
+	// Recipients overrides it for specific IDs such as internal team aliases.
+	AddressFormat string            `yaml:"address_format"`
+	Recipients    map[string]string `yaml:"recipients"`
+}
+
+// HTTPChannelConfig is an SMS or push gateway that accepts JSON over HTTP and
+// addresses recipients by user ID.
+type HTTPChannelConfig struct {
+	ChannelLimits `yaml:",inline"`
+	URL           string        `yaml:"url"`
+	APIKey        string        `yaml:"api_key"`
+	Timeout       time.Duration `yaml:"timeout"`
+}
+
+// TracingConfig controls span export. Trace context is propagated through
+// record headers either way, so a service with tracing off does not break the
+// traces of its neighbours.
+type TracingConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Endpoint: OTLP/HTTP collector URL, e.g. http://otel-collector:4318.
+	// Empty = the standard OTEL_EXPORTER_OTLP_* env vars, or localhost.
+	Endpoint string `yaml:"endpoint"`
+	// SampleRatio: fraction of new traces to sample (0–1]. Records that arrive
+	// with a sampled parent are always traced, so a trace is never cut in half.
+	// Defaults to 1.
+	SampleRatio float64 `yaml:"sample_ratio"`
+}
+
 type MetricsConfig struct {
 	Port int    `yaml:"port"`
 	Path string `yaml:"path"`
@@ -165,6 +512,15 @@ func applyEnvOverrides(cfg *Config) {
 	if v := os.Getenv("KAFKA_CONSUMER_GROUP_ID"); v != "" {
 		cfg.Kafka.Consumer.GroupID = v
 	}
+	if v := os.Getenv("SCHEMA_REGISTRY_URL"); v != "" {
+		cfg.SchemaRegistry.URL = v
+	}
+	if v := os.Getenv("SCHEMA_REGISTRY_USERNAME"); v != "" {
+		cfg.SchemaRegistry.Username = v
+	}
+	if v := os.Getenv("SCHEMA_REGISTRY_PASSWORD"); v != "" {
+		cfg.SchemaRegistry.Password = v
+	}
 	if v := os.Getenv("SERVICE_ENV"); v != "" {
 		cfg.Service.Env = v
 	}
@@ -197,6 +553,129 @@ func (c *Config) Validate() error {
 	if c.Kafka.Consumer.MaxProcessingWorkers == 0 {
 		c.Kafka.Consumer.MaxProcessingWorkers = 1
 	}
+	if c.Kafka.Consumer.CommitInterval == 0 {
+		c.Kafka.Consumer.CommitInterval = time.Second
+	}
+	if c.Kafka.Consumer.LagCheck.Interval == 0 {
+		c.Kafka.Consumer.LagCheck.Interval = 15 * time.Second
+	}
+	if err := c.Kafka.Consumer.AdaptiveLimit.validate(); err != nil {
+		return fmt.Errorf("kafka.consumer.adaptive_limit: %w", err)
+	}
+	if len(c.Kafka.Consumer.RetryTiers) > 0 && c.Kafka.Consumer.RetryTopic.Partitions == 0 {
+		return fmt.Errorf("kafka.consumer.retry_topic.partitions is required when retry_tiers is set")
+	}
+	if c.Kafka.ExactlyOnce && c.Kafka.Producer.Async {
+		return fmt.Errorf("kafka.producer.async cannot be combined with kafka.exactly_once")
+	}
+	switch c.Kafka.TopicDrift {
+	case "":
+		c.Kafka.TopicDrift = TopicDriftReport
+	case TopicDriftReport, TopicDriftApply:
+	default:
+		return fmt.Errorf("kafka.topic_drift must be %q or %q", TopicDriftReport, TopicDriftApply)
+	}
+	if c.Kafka.Producer.MaxBuffered == 0 {
+		c.Kafka.Producer.MaxBuffered = 10000
+	}
+	if c.Kafka.ExactlyOnce && c.Kafka.Producer.TransactionalID == "" {
+		host, err := os.Hostname()
+		if err != nil {
+			return fmt.Errorf("kafka.producer.transactional_id is required: %w", err)
+		}
+		c.Kafka.Producer.TransactionalID = c.Service.Name + "-" + host
+	}
+	if len(c.SchemaRegistry.Schemas) > 0 && c.SchemaRegistry.URL == "" {
+		return fmt.Errorf("schema_registry.url is required when schemas are configured")
+	}
+	if c.SchemaRegistry.Timeout == 0 {
+		c.SchemaRegistry.Timeout = 10 * time.Second
+	}
+	if c.StateStore.Backend == "" {
+		c.StateStore.Backend = "memory"
+	}
+	if c.StateStore.Backend == "bolt" && c.StateStore.Dir == "" {
+		return fmt.Errorf("state_store.dir is required for the bolt backend")
+	}
+	if c.Dedup.Backend == "" {
+		c.Dedup.Backend = "memory"
+	}
+	if c.Dedup.Dir == "" {
+		c.Dedup.Dir = c.StateStore.Dir
+	}
+	if c.Dedup.Backend == "bolt" && c.Dedup.Dir == "" {
+		return fmt.Errorf("dedup.dir (or state_store.dir) is required for the bolt backend")
+	}
+	if c.Dedup.Backend == "redis" && c.Dedup.Redis.Addr == "" {
+		return fmt.Errorf("dedup.redis.addr is required for the redis backend")
+	}
+	if c.Dedup.TTL == 0 {
+		c.Dedup.TTL = time.Hour
+	}
+	if c.Dedup.Lease == 0 {
+		c.Dedup.Lease = time.Minute
+	}
+	if c.Dedup.Redis.Timeout == 0 {
+		c.Dedup.Redis.Timeout = 2 * time.Second
+	}
+	if c.Dedup.Redis.KeyPrefix == "" {
+		c.Dedup.Redis.KeyPrefix = "dedup:"
+	}
+	if c.FraudDetector.RulesFile == "" {
+		c.FraudDetector.RulesFile = "rules/fraud.yaml"
+	}
+	if c.FraudDetector.ReloadInterval == 0 {
+		c.FraudDetector.ReloadInterval = 10 * time.Second
+	}
+	shadows := make(map[string]bool)
+	for _, sh := range c.FraudDetector.Shadows {
+		if sh.Name == "" || sh.RulesFile == "" {
+			return fmt.Errorf("fraud_detector.shadows: name and rules_file are required")
+		}
+		if sh.Name == "primary" || shadows[sh.Name] {
+			return fmt.Errorf("fraud_detector.shadows: duplicate or reserved name %q", sh.Name)
+		}
+		shadows[sh.Name] = true
+	}
+	if err := c.FraudDetector.RateLimit.validate(c.Kafka.Consumer.RetryTiers); err != nil {
+		return fmt.Errorf("fraud_detector.rate_limit: %w", err)
+	}
+	if c.Enricher.JoinWindow == 0 {
+		c.Enricher.JoinWindow = 5 * time.Minute
+	}
+	if c.Enricher.Lookups.GeoIPFile == "" {
+		c.Enricher.Lookups.GeoIPFile = "enrichment/geoip.csv"
+	}
+	if c.Enricher.Lookups.MerchantCategoriesFile == "" {
+		c.Enricher.Lookups.MerchantCategoriesFile = "enrichment/merchant_categories.yaml"
+	}
+	if c.Enricher.Lookups.CacheTTL == 0 {
+		c.Enricher.Lookups.CacheTTL = 5 * time.Minute
+	}
+	if c.Notifier.TemplatesDir == "" {
+		c.Notifier.TemplatesDir = "templates/notifications"
+	}
+	for _, ep := range []struct{ name, value string }{
+		{"notifier.email.smtp_addr", c.Notifier.Email.SMTPAddr},
+		{"notifier.sms.url", c.Notifier.SMS.URL},
+		{"notifier.push.url", c.Notifier.Push.URL},
+	} {
+		if ep.value == MockEndpoint && !c.IsDev() {
+			return fmt.Errorf("%s: %q is only allowed when service.env is dev", ep.name, MockEndpoint)
+		}
+	}
+	if c.Notifier.SMS.Timeout == 0 {
+		c.Notifier.SMS.Timeout = 5 * time.Second
+	}
+	if c.Notifier.Push.Timeout == 0 {
+		c.Notifier.Push.Timeout = 5 * time.Second
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		return fmt.Errorf("tracing.sample_ratio must be between 0 and 1")
+	}
+	if c.Tracing.SampleRatio == 0 {
+		c.Tracing.SampleRatio = 1
+	}
 	if c.Metrics.Port == 0 {
 		c.Metrics.Port = 9090
 	}
@@ -209,3 +688,82 @@ func (c *Config) Validate() error {
 func (c *Config) IsProd() bool {
 	return c.Service.Env == "prod" || c.Service.Env == "production"
 }
+
+func (c *Config) IsDev() bool {
+	return c.Service.Env == "dev" || c.Service.Env == "development"
+}
+
+func (c *AdaptiveLimitConfig) validate() error {
+	if c.InitialLimit == 0 {
+		c.InitialLimit = 10
+	}
+	if c.MinLimit == 0 {
+		c.MinLimit = 1
+	}
+	if c.MaxLimit == 0 {
+		c.MaxLimit = 200
+	}
+	if c.Tolerance == 0 {
+		c.Tolerance = 2
+	}
+	if c.Smoothing == 0 {
+		c.Smoothing = 0.2
+	}
+	if c.BackoffRatio == 0 {
+		c.BackoffRatio = 0.9
+	}
+	switch {
+	case c.MinLimit < 1 || c.MinLimit > c.MaxLimit:
+		return fmt.Errorf("need 1 <= min_limit <= max_limit")
+	case c.InitialLimit < c.MinLimit || c.InitialLimit > c.MaxLimit:
+		return fmt.Errorf("initial_limit must be between min_limit and max_limit")
+	case c.Tolerance < 1:
+		return fmt.Errorf("tolerance must be at least 1")
+	case c.Smoothing <= 0 || c.Smoothing > 1:
+		return fmt.Errorf("smoothing must be in (0, 1]")
+	case c.BackoffRatio <= 0 || c.BackoffRatio >= 1:
+		return fmt.Errorf("backoff_ratio must be in (0, 1)")
+	}
+	return nil
+}
+
+func (c *RateLimitConfig) validate(retryTiers []time.Duration) error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.OnExceeded == "" {
+		c.OnExceeded = RateLimitDelay
+	}
+	if c.IdleTTL == 0 {
+		c.IdleTTL = 10 * time.Minute
+	}
+	switch c.OnExceeded {
+	case RateLimitDelay:
+	case RateLimitRetry:
+		if len(retryTiers) == 0 {
+			return fmt.Errorf("on_exceeded %q requires kafka.consumer.retry_tiers", RateLimitRetry)
+		}
+	default:
+		return fmt.Errorf("on_exceeded must be %q or %q", RateLimitDelay, RateLimitRetry)
+	}
+	if err := c.Default.validate(); err != nil {
+		return fmt.Errorf("default: %w", err)
+	}
+	for key, q := range c.Overrides {
+		if err := q.validate(); err != nil {
+			return fmt.Errorf("overrides[%s]: %w", key, err)
+		}
+		c.Overrides[key] = q
+	}
+	return nil
+}
+
+func (q *Quota) validate() error {
+	if q.Rate < 0 || q.Burst < 0 {
+		return fmt.Errorf("rate and burst must not be negative")
+	}
+	if q.Burst == 0 {
+		q.Burst = max(int(math.Ceil(q.Rate)), 1)
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLoadShippedConfig(t *testing.T) {
+	if _, err := Load("config.yaml"); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+}
+
+func TestValidateMockEndpoints(t *testing.T) {
+	for _, tt := range []struct {
+		env     string
+		wantErr bool
+	}{
+		{"dev", false},
+		{"development", false},
+		{"staging", true},
+		{"production", true},
+		{"", true},
+	} {
+		cfg := Config{
+			Service: ServiceConfig{Name: "notifier", Env: tt.env},
+			Kafka:   KafkaConfig{Brokers: []string{"localhost:9092"}},
+		}
+		cfg.Notifier.SMS.URL = MockEndpoint
+
+		err := cfg.Validate()
+		if tt.wantErr && (err == nil || !strings.Contains(err.Error(), "notifier.sms.url")) {
+			t.Errorf("env %q: got %v, want a notifier.sms.url error", tt.env, err)
+		}
+		if !tt.wantErr && err != nil {
+			t.Errorf("env %q: %v", tt.env, err)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package dedup
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var boltBucket = []byte("dedup")
+
+// BoltStore is a Store persisted to a bbolt file, so a restarted instance
+// still recognizes what it processed before. Claim runs in one read-write
+// transaction, which bbolt serializes — that is the check-and-set.
+//
+// Values are one status byte followed by the expiry in Unix nanoseconds.
+type BoltStore struct {
+	db    *bolt.DB
+	ttl   time.Duration
+	lease time.Duration
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func OpenBoltStore(path string, ttl, lease time.Duration) (*BoltStore, error) {
+	// Timeout: see statestore.OpenBoltStore.
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("opening dedup store %s: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("creating dedup bucket: %w", err)
+	}
+
+	s := &BoltStore{
+		db:    db,
+		ttl:   ttl,
+		lease: lease,
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	go s.sweepLoop()
+	return s, nil
+}
+
+func (s *BoltStore) Claim(_ context.Context, key string) (Status, error) {
+	status := Claimed
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltBucket)
+		now := time.Now()
+		if e, ok := decodeEntry(b.Get([]byte(key))); ok && e.live(now) {
+			status = e.status
+			return nil
+		}
+		return b.Put([]byte(key), encodeEntry(entry{status: InProgress, expires: now.Add(s.lease)}))
+	})
+	return status, err
+}
+
+func (s *BoltStore) Complete(_ context.Context, key string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Put([]byte(key), encodeEntry(entry{status: Completed, expires: time.Now().Add(s.ttl)}))
+	})
+}
+
+func (s *BoltStore) Release(_ context.Context, key string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltBucket)
+		if e, ok := decodeEntry(b.Get([]byte(key))); ok && e.status == InProgress {
+			return b.Delete([]byte(key))
+		}
+		return nil
+	})
+}
+
+func (s *BoltStore) Close() error {
+	close(s.stop)
+	<-s.done
+	return s.db.Close()
+}
+
+func (s *BoltStore) sweepLoop() {
+	defer close(s.done)
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.sweep() // a failed sweep is retried on the next tick
+		}
+	}
+}
+
+func (s *BoltStore) sweep() error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltBucket)
+		now := time.Now()
+		var expired [][]byte
+		b.ForEach(func(k, v []byte) error {
+			if e, ok := decodeEntry(v); !ok || !e.live(now) {
+				expired = append(expired, append([]byte(nil), k...))
+			}
+			return nil
+		})
+		for _, k := range expired {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func encodeEntry(e entry) []byte {
+	buf := make([]byte, 9)
+	buf[0] = byte(e.status)
+	binary.BigEndian.PutUint64(buf[1:], uint64(e.expires.UnixNano()))
+	return buf
+}
+
+func decodeEntry(raw []byte) (entry, bool) {
+	if len(raw) != 9 {
+		return entry{}, false
+	}
+	return entry{
+		status:  Status(raw[0]),
+		expires: time.Unix(0, int64(binary.BigEndian.Uint64(raw[1:]))),
+	}, true
+}
@@ -0,0 +1,86 @@
+package dedup
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryStore is a Store backed by a map. It only catches duplicates within
+// one process lifetime.
+type MemoryStore struct {
+	ttl   time.Duration
+	lease time.Duration
+
+	mu    sync.Mutex
+	items map[string]entry
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func NewMemoryStore(ttl, lease time.Duration) *MemoryStore {
+	s := &MemoryStore{
+		ttl:   ttl,
+		lease: lease,
+		items: make(map[string]entry),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	go s.sweepLoop()
+	return s
+}
+
+func (s *MemoryStore) Claim(_ context.Context, key string) (Status, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if e, ok := s.items[key]; ok && e.live(now) {
+		return e.status, nil
+	}
+	s.items[key] = entry{status: InProgress, expires: now.Add(s.lease)}
+	return Claimed, nil
+}
+
+func (s *MemoryStore) Complete(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.items[key] = entry{status: Completed, expires: time.Now().Add(s.ttl)}
+	return nil
+}
+
+func (s *MemoryStore) Release(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.items[key]; ok && e.status == InProgress {
+		delete(s.items, key)
+	}
+	return nil
+}
+
+func (s *MemoryStore) Close() error {
+	close(s.stop)
+	<-s.done
+	return nil
+}
+
+func (s *MemoryStore) sweepLoop() {
+	defer close(s.done)
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			now := time.Now()
+			for k, e := range s.items {
+				if !e.live(now) {
+					delete(s.items, k)
+				}
+			}
+			s.mu.Unlock()
+		}
+	}
+}
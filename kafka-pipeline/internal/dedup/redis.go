@@ -0,0 +1,98 @@
+package dedup
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/config"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	valueInProgress = "in_progress"
+	valueCompleted  = "completed"
+)
+
+// claimScript is GET-then-SET-with-lease in one atomic step. It returns the
+// existing value, or nil after taking the key.
+var claimScript = redis.NewScript(`
+local v = redis.call('GET', KEYS[1])
+if v then
+	return v
+end
+redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+return false
+`)
+
+// releaseScript deletes the key only while it is still in progress, so a
+// late Release cannot undo a Complete.
+var releaseScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// RedisStore is a Store in Redis, shared by every replica of a service.
+// Expiry is Redis's own key TTL.
+type RedisStore struct {
+	client *redis.Client
+	prefix string
+	ttl    time.Duration
+	lease  time.Duration
+}
+
+// NewRedisStore connects to cfg.Addr. Every key is stored under prefix.
+func NewRedisStore(cfg config.RedisConfig, prefix string, ttl, lease time.Duration) (*RedisStore, error) {
+	s := &RedisStore{prefix: prefix, ttl: ttl, lease: lease}
+	s.client = redis.NewClient(&redis.Options{
+		Addr:         cfg.Addr,
+		Username:     cfg.Username,
+		Password:     cfg.Password,
+		DB:           cfg.DB,
+		DialTimeout:  cfg.Timeout,
+		ReadTimeout:  cfg.Timeout,
+		WriteTimeout: cfg.Timeout,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeout)
+	defer cancel()
+	if err := s.client.Ping(ctx).Err(); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("connecting to redis at %s: %w", cfg.Addr, err)
+	}
+	return s, nil
+}
+
+func (s *RedisStore) Claim(ctx context.Context, key string) (Status, error) {
+	v, err := claimScript.Run(ctx, s.client, []string{s.prefix + key}, valueInProgress, s.lease.Milliseconds()).Result()
+	switch {
+	case err == redis.Nil:
+		return Claimed, nil
+	case err != nil:
+		return 0, fmt.Errorf("claiming %s: %w", key, err)
+	case v == valueCompleted:
+		return Completed, nil
+	default:
+		return InProgress, nil
+	}
+}
+
+func (s *RedisStore) Complete(ctx context.Context, key string) error {
+	if err := s.client.Set(ctx, s.prefix+key, valueCompleted, s.ttl).Err(); err != nil {
+		return fmt.Errorf("completing %s: %w", key, err)
+	}
+	return nil
+}
+
+func (s *RedisStore) Release(ctx context.Context, key string) error {
+	if err := releaseScript.Run(ctx, s.client, []string{s.prefix + key}, valueInProgress).Err(); err != nil {
+		return fmt.Errorf("releasing %s: %w", key, err)
+	}
+	return nil
+}
+
+func (s *RedisStore) Close() error {
+	return s.client.Close()
+}
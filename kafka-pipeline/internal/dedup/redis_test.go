@@ -0,0 +1,78 @@
+package dedup
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/config"
+)
+
+func newTestRedisStore(t *testing.T) (*RedisStore, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	s, err := NewRedisStore(config.RedisConfig{Addr: mr.Addr(), Timeout: time.Second}, "dedup:test:", time.Hour, time.Minute)
+	if err != nil {
+		t.Fatalf("NewRedisStore: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s, mr
+}
+
+func claim(t *testing.T, s Store, key string, want Status) {
+	t.Helper()
+	got, err := s.Claim(context.Background(), key)
+	if err != nil {
+		t.Fatalf("Claim(%s): %v", key, err)
+	}
+	if got != want {
+		t.Fatalf("Claim(%s) = %s, want %s", key, got, want)
+	}
+}
+
+func TestRedisStoreClaim(t *testing.T) {
+	s, mr := newTestRedisStore(t)
+	ctx := context.Background()
+
+	claim(t, s, "k1", Claimed)
+	claim(t, s, "k1", InProgress)
+
+	// Release frees the key for a retry.
+	if err := s.Release(ctx, "k1"); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+	claim(t, s, "k1", Claimed)
+
+	// Completed keys stay completed; a late Release does not undo them.
+	if err := s.Complete(ctx, "k1"); err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+	if err := s.Release(ctx, "k1"); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+	claim(t, s, "k1", Completed)
+
+	if !mr.Exists("dedup:test:k1") {
+		t.Fatal("key not stored under the prefix")
+	}
+}
+
+func TestRedisStoreExpiry(t *testing.T) {
+	s, mr := newTestRedisStore(t)
+	ctx := context.Background()
+
+	// A claim whose handler died frees up after the lease.
+	claim(t, s, "abandoned", Claimed)
+	mr.FastForward(time.Minute + time.Second)
+	claim(t, s, "abandoned", Claimed)
+
+	// A completed key is remembered for the TTL, then forgotten.
+	if err := s.Complete(ctx, "abandoned"); err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+	mr.FastForward(59 * time.Minute)
+	claim(t, s, "abandoned", Completed)
+	mr.FastForward(2 * time.Minute)
+	claim(t, s, "abandoned", Claimed)
+}
@@ -0,0 +1,160 @@
+package enrichment
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/kafka"
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/metrics"
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/pkg/circuitbreaker"
+	"go.uber.org/zap"
+)
+
+// Unknown is the value of a field whose lookup found nothing or failed.
+const Unknown = "UNKNOWN"
+
+// ErrNotFound is returned by a Provider that has no data for a key.
+var ErrNotFound = errors.New("no enrichment data for key")
+
+// sweepInterval is how often expired cache entries are dropped.
+const sweepInterval = time.Minute
+
+// Provider looks up one enrichment field by key. An error that is not
+// ErrNotFound and not retryable (kafka.ClassOf) is bad data, not a provider
+// outage, and does not count against the provider's circuit breaker.
+type Provider interface {
+	Name() string
+	Lookup(ctx context.Context, key string) (string, error)
+}
+
+// -------------------------------------------------------------------------------
+// Lookup puts a TTL cache and a circuit breaker in front of a Provider.
+//
+// Enrichment is best-effort: Resolve never fails. A key the provider has no
+// data for, or a lookup that fails, resolves to Unknown and is counted in
+// kafka_pipeline_enrichment_lookups_total — an enriched event is never held
+// back, or dead-lettered, because a reference data source is down.
+//
+// Found and not-found results are both cached for ttl, so a key the provider
+// does not know does not hit it on every event. Failures are not cached: the
+// next event for the key tries again, unless the breaker is open.
+// -------------------------------------------------------------------------------
+type Lookup struct {
+	provider Provider
+	breaker  *circuitbreaker.CircuitBreaker
+	ttl      time.Duration
+	logger   *zap.Logger
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+type cacheEntry struct {
+	value   string
+	expires time.Time
+}
+
+func NewLookup(provider Provider, ttl time.Duration, logger *zap.Logger) *Lookup {
+	l := &Lookup{
+		provider: provider,
+		breaker: circuitbreaker.New(circuitbreaker.Config{
+			Name: "enrichment_" + provider.Name(),
+			IsFailure: func(err error) bool {
+				return !errors.Is(err, ErrNotFound) && kafka.ClassOf(err).Retryable()
+			},
+		}),
+		ttl:     ttl,
+		logger:  logger,
+		entries: make(map[string]cacheEntry),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go l.sweepLoop()
+	return l
+}
+
+// Resolve returns the provider's value for key, or Unknown.
+func (l *Lookup) Resolve(ctx context.Context, key string) string {
+	name := l.provider.Name()
+	if key == "" {
+		metrics.EnrichmentLookups.WithLabelValues(name, "no_key").Inc()
+		return Unknown
+	}
+
+	now := time.Now()
+	l.mu.Lock()
+	e, ok := l.entries[key]
+	l.mu.Unlock()
+	if ok && now.Before(e.expires) {
+		metrics.EnrichmentLookups.WithLabelValues(name, "cache_hit").Inc()
+		return e.value
+	}
+
+	var value string
+	err := l.breaker.ExecuteCtx(ctx, func(ctx context.Context) error {
+		var err error
+		value, err = l.provider.Lookup(ctx, key)
+		return err
+	})
+
+	switch {
+	case err == nil:
+		metrics.EnrichmentLookups.WithLabelValues(name, "found").Inc()
+		l.store(key, cacheEntry{value: value, expires: now.Add(l.ttl)})
+		return value
+	case errors.Is(err, ErrNotFound):
+		metrics.EnrichmentLookups.WithLabelValues(name, "not_found").Inc()
+		l.store(key, cacheEntry{value: Unknown, expires: now.Add(l.ttl)})
+		return Unknown
+	case errors.Is(err, circuitbreaker.ErrCircuitOpen):
+		metrics.EnrichmentLookups.WithLabelValues(name, "circuit_open").Inc()
+		return Unknown
+	default:
+		metrics.EnrichmentLookups.WithLabelValues(name, "error").Inc()
+		l.logger.Warn("enrichment lookup failed",
+			zap.String("provider", name),
+			zap.String("key", key),
+			zap.Error(err),
+		)
+		return Unknown
+	}
+}
+
+func (l *Lookup) store(key string, e cacheEntry) {
+	l.mu.Lock()
+	l.entries[key] = e
+	l.mu.Unlock()
+}
+
+// Close stops the cache sweeper.
+func (l *Lookup) Close() error {
+	close(l.stop)
+	<-l.done
+	return nil
+}
+
+func (l *Lookup) sweepLoop() {
+	defer close(l.done)
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			l.mu.Lock()
+			now := time.Now()
+			for k, e := range l.entries {
+				if !now.Before(e.expires) {
+					delete(l.entries, k)
+				}
+			}
+			l.mu.Unlock()
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package enrichment
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+)
+
+// -------------------------------------------------------------------------------
+// GeoDB maps IP addresses to locations with a local GeoIP-style database: a
+// CSV file of "<cidr>,<location>" lines, '#' starting a comment. Ranges may
+// nest; the most specific one wins, so a country-wide range can be refined by
+// city ranges inside it:
+//
+//	10.0.0.0/8,IND
+//	10.0.0.0/10,IND-DEL
+//
+// Lookups try each prefix length present in the file, longest first — one
+// map probe per distinct length, whatever the size of the file.
+// -------------------------------------------------------------------------------
+type GeoDB struct {
+	prefixes map[netip.Prefix]string
+	lengths  []int // distinct prefix lengths, longest first
+}
+
+// LoadGeoDB reads the database at path.
+func LoadGeoDB(path string) (*GeoDB, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening geo database: %w", err)
+	}
+	defer f.Close()
+
+	db := &GeoDB{prefixes: make(map[netip.Prefix]string)}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if i := strings.IndexByte(text, '#'); i >= 0 {
+			text = strings.TrimSpace(text[:i])
+		}
+		if text == "" {
+			continue
+		}
+
+		cidr, location, ok := strings.Cut(text, ",")
+		location = strings.TrimSpace(location)
+		if !ok || location == "" {
+			return nil, fmt.Errorf("%s:%d: want <cidr>,<location>", path, line)
+		}
+		prefix, err := netip.ParsePrefix(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		// 10.1.2.3/8 and 10.0.0.0/8 are the same range; key on the masked form.
+		prefix = prefix.Masked()
+		if _, dup := db.prefixes[prefix]; dup {
+			return nil, fmt.Errorf("%s:%d: duplicate range %s", path, line, prefix)
+		}
+		db.prefixes[prefix] = location
+		if !slices.Contains(db.lengths, prefix.Bits()) {
+			db.lengths = append(db.lengths, prefix.Bits())
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading geo database: %w", err)
+	}
+	slices.Sort(db.lengths)
+	slices.Reverse(db.lengths)
+	return db, nil
+}
+
+func (db *GeoDB) Name() string { return "geo" }
+
+// Lookup returns the location of the most specific range containing ip.
+func (db *GeoDB) Lookup(_ context.Context, ip string) (string, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return "", fmt.Errorf("%w: %q is not an IP address", ErrNotFound, ip)
+	}
+	// An IPv4 client behind a dual-stack listener shows up as ::ffff:a.b.c.d.
+	addr = addr.Unmap()
+	for _, bits := range db.lengths {
+		prefix, err := addr.Prefix(bits)
+		if err != nil {
+			continue // an IPv6 length longer than an IPv4 address
+		}
+		if location, ok := db.prefixes[prefix]; ok {
+			return location, nil
+		}
+	}
+	return "", ErrNotFound
+}
+
+// Len returns the number of ranges in the database.
+func (db *GeoDB) Len() int { return len(db.prefixes) }
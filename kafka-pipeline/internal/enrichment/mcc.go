@@ -0,0 +1,69 @@
+package enrichment
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// MerchantCategories maps merchant IDs to their ISO 18245 merchant category
+// codes, from a YAML file exported from merchant onboarding:
+//
+//	merchants:
+//	  merchant_freshmart: "5411"
+type MerchantCategories struct {
+	codes map[string]string
+}
+
+// LoadMerchantCategories reads the directory at path. Every code must be four
+// digits.
+func LoadMerchantCategories(path string) (*MerchantCategories, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading merchant categories: %w", err)
+	}
+
+	var file struct {
+		Merchants map[string]string `yaml:"merchants"`
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(raw))
+	dec.KnownFields(true)
+	if err := dec.Decode(&file); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	for merchant, code := range file.Merchants {
+		if !validMCC(code) {
+			return nil, fmt.Errorf("%s: merchant %s: %q is not a four-digit MCC", path, merchant, code)
+		}
+	}
+	return &MerchantCategories{codes: file.Merchants}, nil
+}
+
+func validMCC(code string) bool {
+	if len(code) != 4 {
+		return false
+	}
+	for _, c := range code {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *MerchantCategories) Name() string { return "mcc" }
+
+// Lookup returns the MCC of merchantID.
+func (m *MerchantCategories) Lookup(_ context.Context, merchantID string) (string, error) {
+	code, ok := m.codes[merchantID]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return code, nil
+}
+
+// Len returns the number of merchants in the directory.
+func (m *MerchantCategories) Len() int { return len(m.codes) }
@@ -0,0 +1,46 @@
+package enrichment
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/kafka"
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/models"
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/statestore"
+)
+
+// RiskTierTable reads receivers' risk tiers from a GlobalTable over the risk
+// tier topic. decode turns a record value into models.RiskTier
+// (serde.Deserializer.Deserialize, bound to the topic).
+type RiskTierTable struct {
+	table  *statestore.GlobalTable
+	decode func(raw []byte, v any) error
+}
+
+func NewRiskTierTable(table *statestore.GlobalTable, decode func(raw []byte, v any) error) *RiskTierTable {
+	return &RiskTierTable{table: table, decode: decode}
+}
+
+func (t *RiskTierTable) Name() string { return "risk_tier" }
+
+// Lookup returns the tier of userID. A record that cannot be decoded, or
+// holds a tier we do not know, is a kafka.Deserialization error.
+func (t *RiskTierTable) Lookup(_ context.Context, userID string) (string, error) {
+	raw, found, err := t.table.Get(userID)
+	if err != nil {
+		return "", kafka.Transient(fmt.Errorf("reading risk tier of %s: %w", userID, err))
+	}
+	if !found {
+		return "", ErrNotFound
+	}
+	var tier models.RiskTier
+	if err := t.decode(raw, &tier); err != nil {
+		return "", kafka.Deserialization(fmt.Errorf("decoding risk tier of %s: %w", userID, err))
+	}
+	switch tier.Tier {
+	case models.RiskTierLow, models.RiskTierMedium, models.RiskTierHigh:
+		return tier.Tier, nil
+	default:
+		return "", kafka.Deserialization(fmt.Errorf("risk tier of %s: unknown tier %q", userID, tier.Tier))
+	}
+}
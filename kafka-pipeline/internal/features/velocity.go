@@ -0,0 +1,306 @@
+package features
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/kafka"
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/models"
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/statestore"
+	"go.uber.org/zap"
+)
+
+// Velocity is a sender's activity in the windows ending at one of its
+// transactions, that transaction included.
+type Velocity struct {
+	Count1m  int
+	Count1h  int
+	Count24h int
+	Sum1m    float64
+	Sum1h    float64
+	Sum24h   float64
+	// DistinctReceivers24h counts the receivers paid in the last 24h.
+	DistinctReceivers24h int
+	// NewReceiver: the sender had not paid this receiver within
+	// receiverMemory.
+	NewReceiver bool
+}
+
+// window is a sliding window kept as buckets of fixed width, so state stays
+// bounded however busy the sender is. Aggregates are exact to one bucket: an
+// event up to one bucket older than the span may still count.
+type window struct {
+	span   time.Duration
+	bucket time.Duration
+}
+
+var (
+	window1m  = window{span: time.Minute, bucket: 10 * time.Second}
+	window1h  = window{span: time.Hour, bucket: time.Minute}
+	window24h = window{span: 24 * time.Hour, bucket: time.Hour}
+	windows   = [...]window{window1m, window1h, window24h}
+)
+
+const (
+	// receiverMemory is how long a receiver stays "known". A sender idle for
+	// this long is dropped from the store altogether.
+	receiverMemory = 30 * 24 * time.Hour
+	// maxReceivers bounds the receivers kept per sender; the least recently
+	// paid go first.
+	maxReceivers = 200
+	// recentTxns is how many transaction IDs are remembered per sender, so a
+	// redelivered transaction is not counted twice.
+	recentTxns = 32
+)
+
+// -------------------------------------------------------------------------------
+// VelocityStore maintains per-sender sliding-window aggregates in a
+// changelog-backed state store keyed by sender ID. Transactions are keyed by
+// sender, so a sender's state lives on the partition its transactions arrive
+// on, and follows it on rebalance through the changelog.
+//
+// EVENT TIME:
+// Windows are placed by Transaction.CreatedAt, not by when the record is
+// processed, so replaying a partition — or restoring the store and
+// reprocessing — yields the same features as the first time. A transaction
+// that arrives later than a window's span behind the sender's newest one
+// does not count toward that window.
+//
+// REDELIVERY:
+// Observe remembers the last recentTxns transaction IDs per sender. A
+// transaction seen before is not added again, and gets the NewReceiver it got
+// the first time.
+// -------------------------------------------------------------------------------
+type VelocityStore struct {
+	store    *statestore.ChangelogStore
+	producer *kafka.Producer
+	locks    [64]sync.Mutex
+	logger   *zap.Logger
+}
+
+type senderState struct {
+	Partition int32 `json:"partition"`
+	// Latest is the newest CreatedAt seen, in Unix seconds. Buckets and
+	// receivers are pruned relative to it.
+	Latest    int64                  `json:"latest"`
+	Windows   [len(windows)][]bucket `json:"windows"`
+	Receivers map[string]int64       `json:"receivers"` // receiver → last paid, Unix seconds
+	Recent    []recentTxn            `json:"recent"`
+}
+
+type bucket struct {
+	Start int64   `json:"s"` // Unix seconds
+	Count int     `json:"c"`
+	Sum   float64 `json:"a"`
+}
+
+type recentTxn struct {
+	ID          string `json:"id"`
+	NewReceiver bool   `json:"new_receiver"`
+}
+
+func NewVelocityStore(ctx context.Context, store *statestore.ChangelogStore, producer *kafka.Producer, logger *zap.Logger) *VelocityStore {
+	s := &VelocityStore{store: store, producer: producer, logger: logger}
+	go s.evictLoop(ctx)
+	return s
+}
+
+// Observe adds txn to its sender's state and returns the sender's velocity as
+// of txn. partition is the input partition txn was read from; the changelog
+// write is pinned to it.
+func (s *VelocityStore) Observe(ctx context.Context, partition int32, txn models.Transaction) (Velocity, error) {
+	unlock := s.lock(ctx, txn.SenderID)
+	defer unlock()
+
+	state, err := s.load(txn.SenderID)
+	if err != nil {
+		return Velocity{}, err
+	}
+	v, added := state.observe(partition, txn)
+	if !added {
+		return v, nil
+	}
+
+	raw, err := json.Marshal(state)
+	if err != nil {
+		return Velocity{}, err
+	}
+	if err := s.store.Put(ctx, partition, txn.SenderID, raw); err != nil {
+		return Velocity{}, fmt.Errorf("saving velocity state of %s: %w", txn.SenderID, err)
+	}
+	return v, nil
+}
+
+// lock serializes updates of one sender. In exactly_once mode unlocking waits
+// for the end of ctx's transaction: its writes only reach the store on commit.
+func (s *VelocityStore) lock(ctx context.Context, senderID string) func() {
+	h := fnv.New32a()
+	h.Write([]byte(senderID))
+	mu := &s.locks[h.Sum32()%uint32(len(s.locks))]
+	mu.Lock()
+	return func() { kafka.AtTxnEnd(ctx, mu.Unlock) }
+}
+
+func (s *VelocityStore) load(senderID string) (*senderState, error) {
+	state := &senderState{Receivers: make(map[string]int64)}
+	raw, ok, err := s.store.Get(senderID)
+	if err != nil || !ok {
+		return state, err
+	}
+	if err := json.Unmarshal(raw, state); err != nil {
+		return nil, fmt.Errorf("decoding velocity state of %s: %w", senderID, err)
+	}
+	if state.Receivers == nil {
+		state.Receivers = make(map[string]int64)
+	}
+	return state, nil
+}
+
+// observe adds txn unless it is one of the recent transactions, and returns
+// the velocity as of txn. added reports whether the state changed.
+func (st *senderState) observe(partition int32, txn models.Transaction) (v Velocity, added bool) {
+	at := txn.CreatedAt.Unix()
+	if i := slices.IndexFunc(st.Recent, func(r recentTxn) bool { return r.ID == txn.ID }); i >= 0 {
+		v = st.velocity(at, txn.ReceiverID)
+		v.NewReceiver = st.Recent[i].NewReceiver
+		return v, false
+	}
+
+	_, known := st.Receivers[txn.ReceiverID]
+	st.add(partition, at, txn)
+	st.Recent = append(st.Recent, recentTxn{ID: txn.ID, NewReceiver: !known})
+	if len(st.Recent) > recentTxns {
+		st.Recent = st.Recent[len(st.Recent)-recentTxns:]
+	}
+
+	v = st.velocity(at, txn.ReceiverID)
+	v.NewReceiver = !known
+	return v, true
+}
+
+func (st *senderState) add(partition int32, at int64, txn models.Transaction) {
+	st.Partition = partition
+	st.Latest = max(st.Latest, at)
+
+	for i, w := range windows {
+		start := at - at%int64(w.bucket.Seconds())
+		buckets := st.Windows[i]
+		j, found := slices.BinarySearchFunc(buckets, start, func(b bucket, start int64) int {
+			return int(b.Start - start)
+		})
+		if !found {
+			buckets = slices.Insert(buckets, j, bucket{Start: start})
+		}
+		buckets[j].Count++
+		buckets[j].Sum += txn.Amount
+
+		// Drop buckets that no window ending at or after Latest can reach.
+		horizon := st.Latest - int64((w.span + w.bucket).Seconds())
+		st.Windows[i] = slices.DeleteFunc(buckets, func(b bucket) bool { return b.Start <= horizon })
+	}
+
+	st.Receivers[txn.ReceiverID] = max(st.Receivers[txn.ReceiverID], at)
+	forget := st.Latest - int64(receiverMemory.Seconds())
+	for r, seen := range st.Receivers {
+		if seen < forget {
+			delete(st.Receivers, r)
+		}
+	}
+	for len(st.Receivers) > maxReceivers {
+		oldest, oldestSeen := "", int64(0)
+		for r, seen := range st.Receivers {
+			if oldest == "" || seen < oldestSeen {
+				oldest, oldestSeen = r, seen
+			}
+		}
+		delete(st.Receivers, oldest)
+	}
+}
+
+// velocity aggregates the windows ending at at, for a transaction paying
+// receiverID. Receivers only keep when they were last paid, so for a
+// transaction older than Latest one paid both before and after it is missed;
+// its own receiver always counts.
+func (st *senderState) velocity(at int64, receiverID string) Velocity {
+	var v Velocity
+	v.Count1m, v.Sum1m = st.aggregate(0, at)
+	v.Count1h, v.Sum1h = st.aggregate(1, at)
+	v.Count24h, v.Sum24h = st.aggregate(2, at)
+
+	since := at - int64(window24h.span.Seconds())
+	v.DistinctReceivers24h = 1
+	for r, seen := range st.Receivers {
+		if r != receiverID && seen > since && seen <= at {
+			v.DistinctReceivers24h++
+		}
+	}
+	return v
+}
+
+func (st *senderState) aggregate(i int, at int64) (count int, sum float64) {
+	w := windows[i]
+	from := at - int64((w.span + w.bucket).Seconds())
+	for _, b := range st.Windows[i] {
+		if b.Start > from && b.Start <= at {
+			count += b.Count
+			sum += b.Sum
+		}
+	}
+	return count, sum
+}
+
+// evictLoop drops senders idle for longer than receiverMemory.
+func (s *VelocityStore) evictLoop(ctx context.Context) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		// Collect first, delete after: see joinStore.evictLoop.
+		idle := make(map[string]int32)
+		cutoff := time.Now().Add(-receiverMemory).Unix()
+		err := s.store.ForEach(func(senderID string, raw []byte) error {
+			var state senderState
+			if err := json.Unmarshal(raw, &state); err == nil && state.Latest < cutoff {
+				idle[senderID] = state.Partition
+			}
+			return nil
+		})
+		if err != nil {
+			s.logger.Error("scanning velocity store for eviction", zap.Error(err))
+			continue
+		}
+
+		for senderID, partition := range idle {
+			// The partition may have been revoked since the scan.
+			release, owned := s.store.Hold(partition)
+			if !owned {
+				continue
+			}
+			err := s.producer.Transact(ctx, func(ctx context.Context) error {
+				unlock := s.lock(ctx, senderID)
+				defer unlock()
+				// The sender may have been active since the scan.
+				state, err := s.load(senderID)
+				if err != nil || state.Latest >= cutoff {
+					return err
+				}
+				return s.store.Delete(ctx, partition, senderID)
+			})
+			release()
+			if err != nil {
+				s.logger.Error("evicting velocity state", zap.String("sender_id", senderID), zap.Error(err))
+			}
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package features
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/models"
+)
+
+// t0 starts a bucket of every window.
+var t0 = time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+func newSenderState() *senderState {
+	return &senderState{Receivers: make(map[string]int64)}
+}
+
+func testTxn(id, receiver string, amount float64, at time.Duration) models.Transaction {
+	return models.Transaction{
+		ID:         id,
+		SenderID:   "sender-1",
+		ReceiverID: receiver,
+		Amount:     amount,
+		CreatedAt:  t0.Add(at),
+	}
+}
+
+func unix(at time.Duration) int64 { return t0.Add(at).Unix() }
+
+func TestVelocityWindowBoundaries(t *testing.T) {
+	st := newSenderState()
+	st.add(0, unix(0), testTxn("t1", "r1", 10, 0))
+
+	// Windows are exact to one bucket: the 1m window, in 10s buckets, still
+	// reaches t0 from t0+69s, but not from t0+70s.
+	for _, tt := range []struct {
+		at    time.Duration
+		count int
+	}{
+		{0, 1},
+		{69 * time.Second, 1},
+		{70 * time.Second, 0},
+	} {
+		if got := st.velocity(unix(tt.at), "r1").Count1m; got != tt.count {
+			t.Errorf("Count1m at t0+%s = %d, want %d", tt.at, got, tt.count)
+		}
+	}
+	// Likewise the 24h window, in 1h buckets.
+	if got := st.velocity(unix(24*time.Hour+59*time.Minute), "r1").Count24h; got != 1 {
+		t.Errorf("Count24h at t0+24h59m = %d, want 1", got)
+	}
+	if got := st.velocity(unix(25*time.Hour), "r1").Count24h; got != 0 {
+		t.Errorf("Count24h at t0+25h = %d, want 0", got)
+	}
+
+	st.add(0, unix(30*time.Second), testTxn("t2", "r2", 20, 30*time.Second))
+	st.add(0, unix(75*time.Second), testTxn("t3", "r1", 40, 75*time.Second))
+	want := Velocity{Count1m: 2, Sum1m: 60, Count1h: 3, Sum1h: 70, Count24h: 3, Sum24h: 70, DistinctReceivers24h: 2}
+	if got := st.velocity(unix(75*time.Second), "r1"); got != want {
+		t.Errorf("at t0+75s: %+v, want %+v", got, want)
+	}
+
+	st.add(0, unix(2*time.Hour), testTxn("t4", "r3", 80, 2*time.Hour))
+	want = Velocity{Count1m: 1, Sum1m: 80, Count1h: 1, Sum1h: 80, Count24h: 4, Sum24h: 150, DistinctReceivers24h: 3}
+	if got := st.velocity(unix(2*time.Hour), "r3"); got != want {
+		t.Errorf("at t0+2h: %+v, want %+v", got, want)
+	}
+	// Buckets out of reach of every window ending at or after Latest are gone.
+	if n := len(st.Windows[0]); n != 1 {
+		t.Errorf("1m window keeps %d buckets, want 1", n)
+	}
+}
+
+func TestVelocityOutOfOrder(t *testing.T) {
+	st := newSenderState()
+	st.add(0, unix(10*time.Minute), testTxn("t1", "r1", 10, 10*time.Minute))
+
+	// Half a minute late: counted in the windows ending at its own CreatedAt,
+	// which miss the newer transaction — but for the 24h window, whose hourly
+	// bucket holds both.
+	v, added := st.observe(0, testTxn("t2", "r1", 20, 9*time.Minute+30*time.Second))
+	if !added {
+		t.Fatal("late transaction not added")
+	}
+	want := Velocity{Count1m: 1, Sum1m: 20, Count1h: 1, Sum1h: 20, Count24h: 2, Sum24h: 30, DistinctReceivers24h: 1}
+	if v != want {
+		t.Errorf("transaction 30s late: %+v, want %+v", v, want)
+	}
+	if st.Latest != unix(10*time.Minute) {
+		t.Errorf("Latest moved back to %d", st.Latest)
+	}
+	if st.Receivers["r1"] != unix(10*time.Minute) {
+		t.Errorf("receiver last paid moved back to %d", st.Receivers["r1"])
+	}
+
+	// More than the 1m span behind Latest: left out of the 1m window, its
+	// own included, but not of the longer ones.
+	v, _ = st.observe(0, testTxn("t3", "r1", 40, 5*time.Minute))
+	if v.Count1m != 0 || v.Count1h != 1 || v.Sum1h != 40 || v.DistinctReceivers24h != 1 {
+		t.Errorf("transaction 5m late: %+v, want Count1m 0, Count1h 1, Sum1h 40, DistinctReceivers24h 1", v)
+	}
+	if got := st.velocity(unix(10*time.Minute), "r1"); got.Count1m != 2 || got.Count1h != 3 || got.Sum1h != 70 {
+		t.Errorf("at Latest: %+v, want Count1m 2, Count1h 3, Sum1h 70", got)
+	}
+}
+
+func TestVelocityRedelivery(t *testing.T) {
+	st := newSenderState()
+	first, added := st.observe(0, testTxn("t1", "r1", 10, 0))
+	if !added || !first.NewReceiver {
+		t.Fatalf("first transaction: added %v, %+v", added, first)
+	}
+	if v, _ := st.observe(0, testTxn("t2", "r1", 20, time.Hour)); v.NewReceiver {
+		t.Fatal("second payment to r1 counted as a new receiver")
+	}
+
+	// Redelivered after r1 became known: a new receiver, as the first time,
+	// and not counted again.
+	again, added := st.observe(0, testTxn("t1", "r1", 10, 0))
+	if added {
+		t.Fatal("redelivered transaction added again")
+	}
+	if !again.NewReceiver {
+		t.Fatal("redelivered transaction lost its NewReceiver")
+	}
+	if got := st.velocity(unix(time.Hour), "r1"); got.Count24h != 2 || got.Sum24h != 30 {
+		t.Fatalf("after redelivery: %+v, want Count24h 2, Sum24h 30", got)
+	}
+
+	// Only the last recentTxns IDs are remembered.
+	for i := range recentTxns {
+		st.observe(0, testTxn("other-"+strconv.Itoa(i), "r2", 1, time.Hour))
+	}
+	if _, added := st.observe(0, testTxn("t1", "r1", 10, 0)); !added {
+		t.Fatal("transaction past the recent IDs not added")
+	}
+}
@@ -2,17 +2,25 @@ package kafka
 
 import (
 	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/IBM/sarama"
 	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/config"
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/metrics"
 	"go.uber.org/zap"
 )
 
-// TopicAdmin creates topics programmatically on startup.
+// TopicAdmin creates topics programmatically on startup, and keeps existing
+// ones in line with their declarations (see TopicPlan).
 
 type TopicAdmin struct {
-	admin  sarama.ClusterAdmin
-	logger *zap.Logger
+	admin         sarama.ClusterAdmin
+	drift         string // config.TopicDriftReport / TopicDriftApply
+	allowIncrease bool   // see AllowPartitionIncreases
+	logger        *zap.Logger
 }
 
 // WHY NOT AUTO-CREATE:
@@ -38,55 +46,369 @@ func NewTopicAdmin(cfg *config.KafkaConfig, logger *zap.Logger) (*TopicAdmin, er
 	if err != nil {
 		return nil, fmt.Errorf("creating cluster admin: %w", err)
 	}
-	return &TopicAdmin{admin: admin, logger: logger}, nil
+	return &TopicAdmin{admin: admin, drift: cfg.TopicDrift, logger: logger}, nil
 }
 
+// EnsureTopics creates the missing topics and reports how the existing ones
+// drifted from their declaration. With kafka.topic_drift "apply" it also makes
+// the safe changes.
 func (ta *TopicAdmin) EnsureTopics(topics []config.TopicDef) error {
+	plan, err := ta.Plan(topics)
+	if err != nil {
+		return err
+	}
+	for _, c := range plan.Changes {
+		if c.Kind == ChangeCreate {
+			continue
+		}
+		ta.logger.Warn("topic drifted from its declaration",
+			zap.String("topic", c.Topic),
+			zap.String("setting", c.Setting),
+			zap.String("current", c.Current),
+			zap.String("desired", c.Desired),
+			zap.String("unsafe", c.Unsafe),
+		)
+	}
+	if ta.drift != config.TopicDriftApply {
+		plan = plan.creates()
+	}
+	return ta.Apply(plan)
+}
+
+// AllowPartitionIncreases makes Plan count more partitions on a topic that is
+// not compacted as safe, so Apply adds them. Keys move to other partitions when
+// it does: stop the topic's consumers first, and resize the topics
+// co-partitioned with it in the same plan. Only topicctl sets it, on request.
+func (ta *TopicAdmin) AllowPartitionIncreases() {
+	ta.allowIncrease = true
+}
+
+// PartitionCounts returns the partition count of every topic on the cluster.
+func (ta *TopicAdmin) PartitionCounts() (map[string]int32, error) {
 	existing, err := ta.admin.ListTopics()
 	if err != nil {
-		return fmt.Errorf("listing topics: %w", err)
+		return nil, fmt.Errorf("listing topics: %w", err)
 	}
+	counts := make(map[string]int32, len(existing))
+	for name, detail := range existing {
+		counts[name] = detail.NumPartitions
+	}
+	return counts, nil
+}
 
-	for _, topic := range topics {
-		if _, exists := existing[topic.Name]; exists {
-			ta.logger.Info("topic already exists", zap.String("topic", topic.Name))
-			continue
+// ChangeKind is what part of a topic a TopicChange touches.
+type ChangeKind string
+
+const (
+	ChangeCreate            ChangeKind = "create"
+	ChangePartitions        ChangeKind = "partitions"
+	ChangeReplicationFactor ChangeKind = "replication_factor"
+	ChangeConfig            ChangeKind = "config"
+)
+
+// TopicChange is one way a topic on the cluster differs from its TopicDef.
+type TopicChange struct {
+	Topic string
+	Kind  ChangeKind
+	// Setting is the config key of a ChangeConfig, and the Kind otherwise.
+	Setting string
+	Current string // "" for a topic that does not exist
+	Desired string
+	// Unsafe is why Apply refuses the change; "" if it makes it.
+	Unsafe string
+}
+
+func (c TopicChange) Safe() bool { return c.Unsafe == "" }
+
+// -------------------------------------------------------------------------------
+// TopicPlan is what it takes to bring the cluster in line with a set of
+// TopicDefs — Terraform-style: Plan computes it without touching anything,
+// Apply carries it out.
+//
+// SAFE: creating a missing topic, changing a config value — and, once
+// AllowPartitionIncreases opted in, more partitions on a topic that is not
+// compacted.
+//
+// UNSAFE (reported, never applied):
+//   - Fewer partitions: Kafka cannot remove them.
+//   - More partitions, without the opt-in: every topic is keyed, and its keys
+//     move to other partitions. Per-key ordering breaks across the change, and
+//     the state built per input partition (velocity, join windows — see
+//     config.TopicConfig) no longer matches where the keys now land.
+//   - More partitions on a compacted topic, opt-in or not: compaction never
+//     removes the old values from the old partitions, so a state store
+//     restored from it would read both. Repartition changelogs by hand.
+//   - A different replication factor: needs a partition reassignment.
+//   - A different cleanup.policy: compact drops all but each key's latest
+//     value, delete drops state once it is older than retention.
+//   - min.insync.replicas above the replication factor: acks=all writes
+//     would always fail.
+//
+// -------------------------------------------------------------------------------
+type TopicPlan struct {
+	Changes []TopicChange
+	defs    map[string]config.TopicDef
+}
+
+// Unsafe returns the changes Apply refuses.
+func (p *TopicPlan) Unsafe() []TopicChange {
+	var unsafe []TopicChange
+	for _, c := range p.Changes {
+		if !c.Safe() {
+			unsafe = append(unsafe, c)
 		}
+	}
+	return unsafe
+}
 
-		configEntries := map[string]*string{
-			"retention.ms":        strPtr(fmt.Sprintf("%d", topic.RetentionMs)),
-			"cleanup.policy":      strPtr(topic.CleanupPolicy),
-			"min.insync.replicas": strPtr(fmt.Sprintf("%d", topic.MinISR)),
+// creates returns the part of p that creates missing topics.
+func (p *TopicPlan) creates() *TopicPlan {
+	creates := &TopicPlan{defs: p.defs}
+	for _, c := range p.Changes {
+		if c.Kind == ChangeCreate {
+			creates.Changes = append(creates.Changes, c)
 		}
-		for k, v := range topic.ExtraConfig {
-			v := v // capture loop variable
-			configEntries[k] = &v
+	}
+	return creates
+}
+
+// Plan diffs the live partition counts, replication factors and configs of
+// topics against their declarations, and exports the drift as
+// metrics.TopicDrift.
+func (ta *TopicAdmin) Plan(topics []config.TopicDef) (*TopicPlan, error) {
+	existing, err := ta.admin.ListTopics()
+	if err != nil {
+		return nil, fmt.Errorf("listing topics: %w", err)
+	}
+
+	plan := &TopicPlan{defs: make(map[string]config.TopicDef, len(topics))}
+	for _, def := range topics {
+		plan.defs[def.Name] = def
+		metrics.TopicDrift.DeletePartialMatch(map[string]string{"topic": def.Name})
+
+		var changes []TopicChange
+		if live, exists := existing[def.Name]; !exists {
+			changes = []TopicChange{{
+				Topic:   def.Name,
+				Kind:    ChangeCreate,
+				Setting: string(ChangeCreate),
+				Desired: fmt.Sprintf("%d partitions, replication factor %d", def.Partitions, def.ReplicationFactor),
+			}}
+		} else {
+			entries, err := ta.admin.DescribeConfig(sarama.ConfigResource{Type: sarama.TopicResource, Name: def.Name})
+			if err != nil {
+				return nil, fmt.Errorf("describing config of %s: %w", def.Name, err)
+			}
+			current := make(map[string]string, len(entries))
+			for _, e := range entries {
+				current[e.Name] = e.Value
+			}
+			changes = diffTopic(def, live, current, ta.allowIncrease)
 		}
 
-		detail := &sarama.TopicDetail{
-			NumPartitions:     topic.Partitions,
-			ReplicationFactor: topic.ReplicationFactor,
-			ConfigEntries:     configEntries,
+		for _, c := range changes {
+			drift := 1.0
+			if !c.Safe() {
+				drift = 2
+			}
+			metrics.TopicDrift.WithLabelValues(c.Topic, c.Setting).Set(drift)
 		}
+		plan.Changes = append(plan.Changes, changes...)
+	}
+	return plan, nil
+}
 
-		if err := ta.admin.CreateTopic(topic.Name, detail, false); err != nil {
-			if topicErr, ok := err.(*sarama.TopicError); ok && topicErr.Err == sarama.ErrTopicAlreadyExists {
-				ta.logger.Info("topic created concurrently by another instance", zap.String("topic", topic.Name))
-				continue
+// diffTopic compares a topic's declaration with its live detail and config
+// values. Every config key of the declaration is compared, defaults included.
+// allowIncrease: see AllowPartitionIncreases.
+func diffTopic(def config.TopicDef, live sarama.TopicDetail, current map[string]string, allowIncrease bool) []TopicChange {
+	var changes []TopicChange
+	change := func(kind ChangeKind, setting, cur, want, unsafe string) {
+		changes = append(changes, TopicChange{
+			Topic:   def.Name,
+			Kind:    kind,
+			Setting: setting,
+			Current: cur,
+			Desired: want,
+			Unsafe:  unsafe,
+		})
+	}
+
+	curPartitions := strconv.Itoa(int(live.NumPartitions))
+	wantPartitions := strconv.Itoa(int(def.Partitions))
+	switch {
+	case def.Partitions < live.NumPartitions:
+		change(ChangePartitions, string(ChangePartitions), curPartitions, wantPartitions,
+			"Kafka cannot remove partitions")
+	case def.Partitions > live.NumPartitions && strings.Contains(current["cleanup.policy"], "compact"):
+		change(ChangePartitions, string(ChangePartitions), curPartitions, wantPartitions,
+			"compacted topic: keys would move to other partitions, where compaction never removes their old values")
+	case def.Partitions > live.NumPartitions && !allowIncrease:
+		change(ChangePartitions, string(ChangePartitions), curPartitions, wantPartitions,
+			"keys would move to other partitions, out of step with co-partitioned topics and state (topicctl apply -allow-partition-increase)")
+	case def.Partitions > live.NumPartitions:
+		change(ChangePartitions, string(ChangePartitions), curPartitions, wantPartitions, "")
+	}
+
+	if def.ReplicationFactor != live.ReplicationFactor {
+		change(ChangeReplicationFactor, string(ChangeReplicationFactor),
+			strconv.Itoa(int(live.ReplicationFactor)), strconv.Itoa(int(def.ReplicationFactor)),
+			"changing the replication factor needs a partition reassignment")
+	}
+
+	desired := topicConfigEntries(def)
+	keys := make([]string, 0, len(desired))
+	for key := range desired {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		want := desired[key]
+		cur, ok := current[key]
+		if ok && sameConfigValue(key, cur, want) {
+			continue
+		}
+		var unsafe string
+		switch key {
+		case "cleanup.policy":
+			unsafe = "changing the cleanup policy deletes data"
+		case "min.insync.replicas":
+			if n, err := strconv.Atoi(want); err == nil && n > int(live.ReplicationFactor) {
+				unsafe = fmt.Sprintf("above the replication factor (%d): acks=all writes would always fail", live.ReplicationFactor)
 			}
-			return fmt.Errorf("creating topic %s: %w", topic.Name, err)
 		}
+		change(ChangeConfig, key, cur, want, unsafe)
+	}
+	return changes
+}
 
-		ta.logger.Info("topic created",
-			zap.String("topic", topic.Name),
-			zap.Int32("partitions", topic.Partitions),
-			zap.Int16("replication_factor", topic.ReplicationFactor),
-		)
+// sameConfigValue compares config values; list values like cleanup.policy
+// ("compact,delete") compare regardless of order.
+func sameConfigValue(key, a, b string) bool {
+	if key != "cleanup.policy" {
+		return a == b
+	}
+	split := func(v string) []string {
+		parts := strings.Split(v, ",")
+		for i := range parts {
+			parts[i] = strings.TrimSpace(parts[i])
+		}
+		sort.Strings(parts)
+		return parts
+	}
+	return slices.Equal(split(a), split(b))
+}
+
+// Apply makes the safe changes of plan and skips the unsafe ones. It stops at
+// the first change the cluster rejects; running it again picks up from there.
+func (ta *TopicAdmin) Apply(plan *TopicPlan) error {
+	configs := make(map[string][]TopicChange)
+	var configTopics []string
+	for _, c := range plan.Changes {
+		if !c.Safe() {
+			continue
+		}
+		switch c.Kind {
+		case ChangeCreate:
+			if err := ta.createTopic(plan.defs[c.Topic]); err != nil {
+				return err
+			}
+		case ChangePartitions:
+			if err := ta.addPartitions(c.Topic, plan.defs[c.Topic].Partitions); err != nil {
+				return err
+			}
+		case ChangeConfig:
+			if configs[c.Topic] == nil {
+				configTopics = append(configTopics, c.Topic)
+			}
+			configs[c.Topic] = append(configs[c.Topic], c)
+			continue
+		}
+		metrics.TopicDrift.DeleteLabelValues(c.Topic, c.Setting)
 	}
 
+	for _, topic := range configTopics {
+		entries := make(map[string]sarama.IncrementalAlterConfigsEntry, len(configs[topic]))
+		for _, c := range configs[topic] {
+			entries[c.Setting] = sarama.IncrementalAlterConfigsEntry{
+				Operation: sarama.IncrementalAlterConfigsOperationSet,
+				Value:     strPtr(c.Desired),
+			}
+		}
+		if err := ta.admin.IncrementalAlterConfig(sarama.TopicResource, topic, entries, false); err != nil {
+			return fmt.Errorf("altering config of %s: %w", topic, err)
+		}
+		for _, c := range configs[topic] {
+			ta.logger.Info("topic config updated",
+				zap.String("topic", topic),
+				zap.String("key", c.Setting),
+				zap.String("from", c.Current),
+				zap.String("to", c.Desired),
+			)
+			metrics.TopicDrift.DeleteLabelValues(c.Topic, c.Setting)
+		}
+	}
 	return nil
 }
 
+func (ta *TopicAdmin) createTopic(topic config.TopicDef) error {
+	configEntries := make(map[string]*string)
+	for k, v := range topicConfigEntries(topic) {
+		configEntries[k] = strPtr(v)
+	}
+
+	detail := &sarama.TopicDetail{
+		NumPartitions:     topic.Partitions,
+		ReplicationFactor: topic.ReplicationFactor,
+		ConfigEntries:     configEntries,
+	}
+
+	if err := ta.admin.CreateTopic(topic.Name, detail, false); err != nil {
+		if topicErr, ok := err.(*sarama.TopicError); ok && topicErr.Err == sarama.ErrTopicAlreadyExists {
+			ta.logger.Info("topic created concurrently by another instance", zap.String("topic", topic.Name))
+			return nil
+		}
+		return fmt.Errorf("creating topic %s: %w", topic.Name, err)
+	}
+
+	ta.logger.Info("topic created",
+		zap.String("topic", topic.Name),
+		zap.Int32("partitions", topic.Partitions),
+		zap.Int16("replication_factor", topic.ReplicationFactor),
+	)
+	return nil
+}
+
+func (ta *TopicAdmin) addPartitions(topic string, count int32) error {
+	if err := ta.admin.CreatePartitions(topic, count, nil, false); err != nil {
+		// Another instance applying the same plan may have got there first.
+		meta, derr := ta.admin.DescribeTopics([]string{topic})
+		if derr == nil && len(meta) == 1 && int32(len(meta[0].Partitions)) >= count {
+			return nil
+		}
+		return fmt.Errorf("adding partitions to %s: %w", topic, err)
+	}
+	ta.logger.Info("topic partitions added",
+		zap.String("topic", topic),
+		zap.Int32("partitions", count),
+	)
+	return nil
+}
+
+// topicConfigEntries returns the config a topic is created with, and that
+// Plan holds it to.
+func topicConfigEntries(topic config.TopicDef) map[string]string {
+	entries := map[string]string{
+		"retention.ms":        fmt.Sprintf("%d", topic.RetentionMs),
+		"cleanup.policy":      topic.CleanupPolicy,
+		"min.insync.replicas": fmt.Sprintf("%d", topic.MinISR),
+	}
+	for k, v := range topic.ExtraConfig {
+		entries[k] = v
+	}
+	return entries
+}
+
 func (ta *TopicAdmin) Close() error {
 	return ta.admin.Close()
 }
@@ -0,0 +1,129 @@
+package kafka
+
+import (
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/config"
+	"go.uber.org/zap"
+)
+
+func TestDiffTopicPartitions(t *testing.T) {
+	for _, tt := range []struct {
+		name          string
+		live          int32
+		want          int32
+		policy        string
+		allowIncrease bool
+		changes       int
+		safe          bool
+	}{
+		{"unchanged", 12, 12, "delete", false, 0, false},
+		{"fewer", 12, 6, "delete", false, 1, false},
+		{"fewer, increases allowed", 12, 6, "delete", true, 1, false},
+		{"more", 12, 24, "delete", false, 1, false},
+		{"more, increases allowed", 12, 24, "delete", true, 1, true},
+		{"more, compacted", 12, 24, "compact", false, 1, false},
+		{"more, compacted, increases allowed", 12, 24, "compact", true, 1, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			def := config.TopicDef{Name: "txn.raw.v1", Partitions: tt.want, ReplicationFactor: 3, CleanupPolicy: tt.policy}
+			live := sarama.TopicDetail{NumPartitions: tt.live, ReplicationFactor: 3}
+			current := map[string]string{"cleanup.policy": tt.policy}
+
+			var changes []TopicChange
+			for _, c := range diffTopic(def, live, current, tt.allowIncrease) {
+				if c.Kind == ChangePartitions {
+					changes = append(changes, c)
+				}
+			}
+			if len(changes) != tt.changes {
+				t.Fatalf("got %d partition changes, want %d: %+v", len(changes), tt.changes, changes)
+			}
+			for _, c := range changes {
+				if c.Safe() != tt.safe {
+					t.Fatalf("partition change %s -> %s: safe %v, want %v (%s)", c.Current, c.Desired, c.Safe(), tt.safe, c.Unsafe)
+				}
+			}
+		})
+	}
+}
+
+// fakeClusterAdmin serves live topics with the same config values, and records
+// the partitions added.
+type fakeClusterAdmin struct {
+	sarama.ClusterAdmin
+	topics  map[string]sarama.TopicDetail
+	configs map[string]string
+	added   map[string]int32
+}
+
+func (f *fakeClusterAdmin) ListTopics() (map[string]sarama.TopicDetail, error) {
+	return f.topics, nil
+}
+
+func (f *fakeClusterAdmin) DescribeConfig(sarama.ConfigResource) ([]sarama.ConfigEntry, error) {
+	var entries []sarama.ConfigEntry
+	for k, v := range f.configs {
+		entries = append(entries, sarama.ConfigEntry{Name: k, Value: v})
+	}
+	return entries, nil
+}
+
+func (f *fakeClusterAdmin) IncrementalAlterConfig(sarama.ConfigResourceType, string, map[string]sarama.IncrementalAlterConfigsEntry, bool) error {
+	return nil
+}
+
+func (f *fakeClusterAdmin) CreatePartitions(topic string, count int32, _ [][]int32, _ bool) error {
+	f.added[topic] = count
+	detail := f.topics[topic]
+	detail.NumPartitions = count
+	f.topics[topic] = detail
+	return nil
+}
+
+func TestApplyPartitionIncrease(t *testing.T) {
+	for _, tt := range []struct {
+		name          string
+		policy        string
+		allowIncrease bool
+		added         bool
+	}{
+		{"refused", "delete", false, false},
+		{"allowed", "delete", true, true},
+		{"compacted, allowed", "compact", true, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			def := config.TopicDef{Name: "txn.raw.v1", Partitions: 24, ReplicationFactor: 3, CleanupPolicy: tt.policy, MinISR: 2}
+			fake := &fakeClusterAdmin{
+				topics:  map[string]sarama.TopicDetail{def.Name: {NumPartitions: 12, ReplicationFactor: 3}},
+				configs: topicConfigEntries(def),
+				added:   map[string]int32{},
+			}
+			ta := &TopicAdmin{admin: fake, drift: config.TopicDriftApply, logger: zap.NewNop()}
+			if tt.allowIncrease {
+				ta.AllowPartitionIncreases()
+			}
+
+			plan, err := ta.Plan([]config.TopicDef{def})
+			if err != nil {
+				t.Fatalf("Plan: %v", err)
+			}
+			if err := ta.Apply(plan); err != nil {
+				t.Fatalf("Apply: %v", err)
+			}
+
+			count, added := fake.added[def.Name]
+			if added != tt.added {
+				t.Fatalf("partitions added: %v, want %v", added, tt.added)
+			}
+			if added && count != def.Partitions {
+				t.Fatalf("added partitions up to %d, want %d", count, def.Partitions)
+			}
+			// A refused increase stays in the plan, so apply exits 1.
+			if refused := len(plan.Unsafe()) == 1; refused == tt.added {
+				t.Fatalf("unsafe changes %+v with partitions added %v", plan.Unsafe(), added)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/metrics"
+	"go.uber.org/zap"
+)
+
+// -------------------------------------------------------------------------------
+// ASYNC MODE (producer.async: true).
+//
+// A SyncProducer waits for the broker on every record, so one goroutine gets
+// at most 1/RTT records per second — a few hundred at best. The AsyncProducer
+// hands records to sarama's batcher and reports acks on a channel, so a single
+// goroutine can keep thousands of records in flight.
+//
+// BOUNDED BUFFERING:
+// Every record sent but not yet acknowledged holds a token of p.buffered. When
+// max_buffered records are outstanding, ProduceAsync blocks until acks free
+// tokens (or ctx ends). A slow or unreachable cluster slows the caller down
+// instead of growing memory until the pod is OOM-killed.
+//
+// FLUSH ON SHUTDOWN:
+// Close stops accepting records, lets sarama flush its buffers, and returns only
+// after every outstanding record has been acknowledged or failed — every
+// Delivery completes and every callback runs.
+// -------------------------------------------------------------------------------
+
+// ErrProducerClosed is returned for records produced after Close.
+var ErrProducerClosed = errors.New("producer is closed")
+
+// DeliveryFunc is called once per record with the broker's answer. It runs on
+// the producer's result goroutine: keep it short and never block in it.
+type DeliveryFunc func(partition int32, offset int64, err error)
+
+// Delivery is the future result of an asynchronously produced record.
+type Delivery struct {
+	done      chan struct{}
+	callback  DeliveryFunc
+	partition int32
+	offset    int64
+	err       error
+}
+
+// Done is closed once the record is acknowledged or has failed.
+func (d *Delivery) Done() <-chan struct{} {
+	return d.done
+}
+
+// Wait blocks until the record is acknowledged or has failed, or ctx ends.
+// A ctx that ends first does NOT cancel the record — it may still be delivered.
+func (d *Delivery) Wait(ctx context.Context) (partition int32, offset int64, err error) {
+	// An already-completed delivery wins over a cancelled ctx.
+	select {
+	case <-d.done:
+		return d.partition, d.offset, d.err
+	default:
+	}
+	select {
+	case <-ctx.Done():
+		return 0, 0, ctx.Err()
+	case <-d.done:
+		return d.partition, d.offset, d.err
+	}
+}
+
+func (d *Delivery) complete(partition int32, offset int64, err error) {
+	d.partition, d.offset, d.err = partition, offset, err
+	close(d.done)
+	if d.callback != nil {
+		d.callback(partition, offset, err)
+	}
+}
+
+// ProduceAsync serializes value and hands it to the producer without waiting for
+// the broker. The returned error covers only what fails up front — serialization,
+// a closed producer, ctx ending while blocked on a full buffer; the broker's
+// answer arrives through the Delivery and, if not nil, callback.
+//
+// On a producer not in async mode the record is sent synchronously and the
+// Delivery is already complete — callers need not care which mode is configured.
+func (p *Producer) ProduceAsync(ctx context.Context, topic, key string, value any, headers map[string]string, callback DeliveryFunc) (*Delivery, error) {
+	payload, err := p.serializer.Serialize(topic, value)
+	if err != nil {
+		metrics.MessagesProduced.WithLabelValues(topic, "error").Inc()
+		return nil, fmt.Errorf("serializing message: %w", err)
+	}
+	return p.dispatch(ctx, topic, -1, key, payload, headers, callback)
+}
+
+// enqueue waits for buffer space and passes msg to the async producer.
+func (p *Producer) enqueue(ctx context.Context, msg *sarama.ProducerMessage) error {
+	select {
+	case p.buffered <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	p.closeMu.RLock()
+	defer p.closeMu.RUnlock()
+	if p.closed {
+		<-p.buffered
+		return ErrProducerClosed
+	}
+
+	msg.Metadata.(*recordMeta).sentAt = time.Now()
+	metrics.ProducerBuffered.Inc()
+	p.async.Input() <- msg
+	return nil
+}
+
+func (p *Producer) handleSuccesses() {
+	defer p.resultsWg.Done()
+	for msg := range p.async.Successes() {
+		p.release()
+		p.finish(msg, nil)
+	}
+}
+
+func (p *Producer) handleErrors() {
+	defer p.resultsWg.Done()
+	for perr := range p.async.Errors() {
+		p.release()
+		p.finish(perr.Msg, perr.Err)
+	}
+}
+
+// release frees the buffer slot of an acknowledged or failed record.
+func (p *Producer) release() {
+	<-p.buffered
+	metrics.ProducerBuffered.Dec()
+}
+
+func (p *Producer) closeAsync() error {
+	outstanding := len(p.buffered)
+	p.logger.Info("flushing async producer", zap.Int("outstanding", outstanding))
+
+	// AsyncClose flushes and then closes Successes/Errors, which ends the
+	// result goroutines once every outstanding record has been reported.
+	p.async.AsyncClose()
+	p.resultsWg.Wait()
+
+	p.logger.Info("async producer flushed", zap.Int("records", outstanding))
+	return nil
+}
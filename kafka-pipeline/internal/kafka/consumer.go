@@ -2,9 +2,8 @@ package kafka
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"strconv"
 	"sync"
 	"time"
 
@@ -15,31 +14,48 @@ import (
 	"go.uber.org/zap"
 )
 
+// MessageHandler processes one consumed record. The record's topic, partition,
+// offset and timestamp are available via MetadataFromContext, and headers always
+// contain SourceTopicHeader.
 type MessageHandler func(ctx context.Context, key []byte, value []byte, headers map[string]string) error
 
+// AssignHook runs in Setup, after partitions are assigned and before any of
+// them is consumed. Stateful consumers use it to restore local state for the
+// partitions they now own. Returning an error aborts the session.
+type AssignHook func(ctx context.Context, claims map[string][]int32) error
+
+// RevokeHook runs in Cleanup, after every handler of the session has returned
+// and before the partitions can be assigned elsewhere. Stateful consumers use
+// it to stop writing state they no longer own.
+type RevokeHook func()
+
 type ConsumerGroup struct {
-	group   sarama.ConsumerGroup
-	handler MessageHandler
-	dlqProd *Producer
-	topics  []string
-	cfg     *config.KafkaConfig
-	logger  *zap.Logger
-	ready   chan struct{}
-	cancel  context.CancelFunc
-	wg      sync.WaitGroup
+	group    sarama.ConsumerGroup
+	handler  MessageHandler
+	onAssign AssignHook
+	onRevoke RevokeHook
+	dlqProd  *Producer
+	lag      *lagMonitor
+	topics   []string
+	cfg      *config.KafkaConfig
+	service  config.ServiceConfig
+	logger   *zap.Logger
+	ready    chan struct{}
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+
+	fatalOnce sync.Once
+	fatalErr  error
 }
 
-func NewConsumerGroup(cfg *config.KafkaConfig, topics []string, handler MessageHandler, dlqProducer *Producer, logger *zap.Logger) (*ConsumerGroup, error) {
+// NewConsumerGroup creates a consumer group for topics. service identifies the
+// deployed service in DLQ envelopes.
+func NewConsumerGroup(cfg *config.KafkaConfig, service config.ServiceConfig, topics []string, handler MessageHandler, dlqProducer *Producer, logger *zap.Logger) (*ConsumerGroup, error) {
 	saramaCfg := sarama.NewConfig()
 
 	saramaCfg.Consumer.Group.Session.Timeout = cfg.Consumer.SessionTimeout
 	saramaCfg.Consumer.Group.Heartbeat.Interval = cfg.Consumer.HeartbeatInterval
-	saramaCfg.Consumer.Group.Rebalance.GroupStrategies = []sarama.BalanceStrategy{
-		// CooperativeSticky: incremental rebalancing. Only migrates partitions
-		// that need to move, instead of revoking ALL partitions and reassigning.
-		// Reduces rebalance downtime from seconds to milliseconds.
-		sarama.NewBalanceStrategySticky(),
-	}
+	saramaCfg.Consumer.Group.Rebalance.GroupStrategies = balanceStrategies(&cfg.Consumer)
 	saramaCfg.Consumer.MaxProcessingTime = cfg.Consumer.MaxPollInterval
 
 	// Manual offset management
@@ -52,6 +68,26 @@ func NewConsumerGroup(cfg *config.KafkaConfig, topics []string, handler MessageH
 	saramaCfg.Consumer.Fetch.Min = int32(cfg.Consumer.FetchMinBytes)
 	saramaCfg.Consumer.MaxWaitTime = cfg.Consumer.FetchMaxWait
 
+	// -------------------------------------------------------------------------------
+	// EXACTLY-ONCE (consume-transform-produce):
+	// Without transactions, output is produced and the offset is committed in two
+	// separate steps. A crash between them replays the input and duplicates the
+	// output. In exactly_once mode every handler attempt runs in a producer
+	// transaction that also carries the input offset (AddMessageToTxn): output
+	// and offset commit together, or neither does.
+	//
+	// Downstream consumers must read_committed, or they still see the output of
+	// aborted attempts. The DLQ producer doubles as the transactional producer,
+	// so handlers must produce through that same Producer, with the ctx they are
+	// given, for their output to join the transaction.
+	// -------------------------------------------------------------------------------
+	if cfg.ExactlyOnce {
+		saramaCfg.Consumer.IsolationLevel = sarama.ReadCommitted
+		if dlqProducer == nil || !dlqProducer.Transactional() {
+			return nil, fmt.Errorf("exactly_once requires a transactional producer")
+		}
+	}
+
 	// Security
 	if cfg.SecurityProtocol == "SASL_SSL" {
 		saramaCfg.Net.TLS.Enable = true
@@ -66,36 +102,99 @@ func NewConsumerGroup(cfg *config.KafkaConfig, topics []string, handler MessageH
 		}
 	}
 
+	// Retry tiers are per consumer group, so they can only be created here,
+	// once the group ID is known — not with the shared topics at startup.
+	if len(cfg.Consumer.RetryTiers) > 0 {
+		retryTopics := RetryTopicDefs(&cfg.Consumer)
+		if err := ensureRetryTopics(cfg, topics, retryTopics, logger); err != nil {
+			return nil, err
+		}
+		for _, def := range retryTopics {
+			topics = append(topics, def.Name)
+		}
+	}
+
 	group, err := sarama.NewConsumerGroup(cfg.Brokers, cfg.Consumer.GroupID, saramaCfg)
 	if err != nil {
 		return nil, fmt.Errorf("creating consumer group: %w", err)
 	}
 
+	lag, err := newLagMonitor(cfg, logger.Named("lag"))
+	if err != nil {
+		group.Close()
+		return nil, err
+	}
+
 	return &ConsumerGroup{
 		group:   group,
 		handler: handler,
 		dlqProd: dlqProducer,
+		lag:     lag,
 		topics:  topics,
 		cfg:     cfg,
+		service: service,
 		logger:  logger,
 		ready:   make(chan struct{}),
 	}, nil
 }
 
+// balanceStrategies returns the partition assignors of a group.
+func balanceStrategies(cfg *config.ConsumerConfig) []sarama.BalanceStrategy {
+	// Range: partition p of every topic with the same partition count goes to
+	// the same member, so input partitions, their retry tiers and the state
+	// built from them stay together.
+	if cfg.CoPartitioned {
+		return []sarama.BalanceStrategy{sarama.NewBalanceStrategyRange()}
+	}
+	return []sarama.BalanceStrategy{
+		// CooperativeSticky: incremental rebalancing. Only migrates partitions
+		// that need to move, instead of revoking ALL partitions and reassigning.
+		// Reduces rebalance downtime from seconds to milliseconds.
+		sarama.NewBalanceStrategySticky(),
+	}
+}
+
+// OnPartitionsAssigned registers a hook that runs on every rebalance before
+// consumption starts. Must be called before Run.
+func (cg *ConsumerGroup) OnPartitionsAssigned(hook AssignHook) {
+	cg.onAssign = hook
+}
+
+// OnPartitionsRevoked registers a hook that runs at the end of every session.
+// Must be called before Run.
+func (cg *ConsumerGroup) OnPartitionsRevoked(hook RevokeHook) {
+	cg.onRevoke = hook
+}
+
 func (cg *ConsumerGroup) Run(ctx context.Context) error {
 	ctx, cg.cancel = context.WithCancel(ctx)
 
+	cg.wg.Add(1)
+	go func() {
+		defer cg.wg.Done()
+		cg.lag.run(ctx)
+	}()
+
 	cg.wg.Add(1)
 	go func() {
 		defer cg.wg.Done()
 
 		for {
 			handler := &groupHandler{
-				handler: cg.handler,
-				dlqProd: cg.dlqProd,
-				cfg:     cg.cfg,
-				logger:  cg.logger,
-				ready:   cg.ready,
+				handler:    cg.handler,
+				onAssign:   cg.onAssign,
+				onRevoke:   cg.onRevoke,
+				retryTiers: retryTierIndex(&cg.cfg.Consumer),
+				dlqProd:    cg.dlqProd,
+				cfg:        cg.cfg,
+				service:    cg.service,
+				logger:     cg.logger,
+				ready:      cg.ready,
+				fatal:      cg.fail,
+				assigned:   cg.lag.assign,
+			}
+			if cg.cfg.ExactlyOnce {
+				handler.txnProd = cg.dlqProd
 			}
 
 			if err := cg.group.Consume(ctx, cg.topics, handler); err != nil {
@@ -104,22 +203,51 @@ func (cg *ConsumerGroup) Run(ctx context.Context) error {
 			if ctx.Err() != nil {
 				return
 			}
-			// Reset ready channel for next session.
-			cg.ready = make(chan struct{})
+			// Reset ready channel for next session — but only if this one got
+			// as far as Setup. A session that failed before (or inside) Setup
+			// never closed it, and Run may still be waiting on it.
+			select {
+			case <-cg.ready:
+				cg.ready = make(chan struct{})
+			default:
+			}
 		}
 	}()
 
 	// Wait for the first session to be established.
-	<-cg.ready
-	cg.logger.Info("consumer group ready",
-		zap.String("group", cg.cfg.Consumer.GroupID),
-		zap.Strings("topics", cg.topics),
-	)
+	select {
+	case <-cg.ready:
+		cg.logger.Info("consumer group ready",
+			zap.String("group", cg.cfg.Consumer.GroupID),
+			zap.Strings("topics", cg.topics),
+		)
+	case <-ctx.Done():
+	}
 
 	<-ctx.Done()
 	cg.logger.Info("consumer group shutting down")
 	cg.wg.Wait()
-	return cg.group.Close()
+	cg.lag.close()
+	if err := cg.group.Close(); err != nil {
+		return err
+	}
+	return cg.fatalErr
+}
+
+// fail stops the consumer group after an unrecoverable error; Run returns it.
+func (cg *ConsumerGroup) fail(err error) {
+	cg.fatalOnce.Do(func() {
+		cg.logger.Error("consumer group stopping on fatal error", zap.Error(err))
+		cg.fatalErr = err
+		cg.cancel()
+	})
+}
+
+// CheckLag is a health check: it fails when a partition owned by this
+// instance lags more than lag_check.max_lag, or has stopped committing while
+// records keep arriving. See lagMonitor.
+func (cg *ConsumerGroup) CheckLag(ctx context.Context) error {
+	return cg.lag.check(ctx)
 }
 
 // Ready returns a channel that closes when the consumer is ready.
@@ -127,16 +255,35 @@ func (cg *ConsumerGroup) Ready() <-chan struct{} {
 	return cg.ready
 }
 
+// PauseAll stops fetching from every partition this instance owns. Records
+// already fetched are still delivered. Pauses do not survive a rebalance: the
+// partitions of a new session start fetching.
+func (cg *ConsumerGroup) PauseAll() {
+	cg.group.PauseAll()
+}
+
+// ResumeAll undoes PauseAll.
+func (cg *ConsumerGroup) ResumeAll() {
+	cg.group.ResumeAll()
+}
+
 // -------------------------------------------------------------------------------
 // groupHandler implements sarama.ConsumerGroupHandler.
 // A new instance is created for each rebalance session.
 // -------------------------------------------------------------------------------
 type groupHandler struct {
-	handler MessageHandler
-	dlqProd *Producer
-	cfg     *config.KafkaConfig
-	logger  *zap.Logger
-	ready   chan struct{}
+	handler    MessageHandler
+	onAssign   AssignHook
+	onRevoke   RevokeHook
+	retryTiers map[string]int // retry topic → tier index
+	dlqProd    *Producer
+	txnProd    *Producer // set in exactly_once mode
+	cfg        *config.KafkaConfig
+	service    config.ServiceConfig
+	logger     *zap.Logger
+	ready      chan struct{}
+	fatal      func(error)
+	assigned   func(claims map[string][]int32)
 }
 
 // Setup is called when the consumer group is (re)balanced and partitions are assigned.
@@ -146,97 +293,252 @@ func (h *groupHandler) Setup(session sarama.ConsumerGroupSession) error {
 		zap.Int32("generation", session.GenerationID()),
 	)
 	metrics.ConsumerRebalances.WithLabelValues(h.cfg.Consumer.GroupID, "assigned").Inc()
+
+	if h.onAssign != nil {
+		if err := h.onAssign(session.Context(), session.Claims()); err != nil {
+			return fmt.Errorf("partition assignment hook: %w", err)
+		}
+	}
+
+	h.assigned(session.Claims())
+
+	// Marked offsets are only sent to the broker on Commit. In exactly_once
+	// mode every transaction commits its own offsets instead.
+	if h.txnProd == nil {
+		go h.commitLoop(session)
+	}
+
 	close(h.ready)
 	return nil
 }
 
+// commitLoop commits marked offsets every commit_interval until the session
+// ends; Cleanup makes the final commit.
+func (h *groupHandler) commitLoop(session sarama.ConsumerGroupSession) {
+	ticker := time.NewTicker(h.cfg.Consumer.CommitInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-session.Context().Done():
+			return
+		case <-ticker.C:
+			session.Commit()
+		}
+	}
+}
+
 // Cleanup is called when the session ends (before the next rebalance).
 // Commit any pending offsets here.
 func (h *groupHandler) Cleanup(session sarama.ConsumerGroupSession) error {
 	h.logger.Info("consumer group cleanup — committing offsets")
 	metrics.ConsumerRebalances.WithLabelValues(h.cfg.Consumer.GroupID, "revoked").Inc()
+	h.assigned(nil)
+	if h.onRevoke != nil {
+		h.onRevoke()
+	}
 	session.Commit()
 	return nil
 }
 
 // ConsumeClaim processes messages from a single partition.
 // This is called in a separate goroutine per partition — sarama handles the fan-out.
+//
+// Within the partition, messages are spread over MaxProcessingWorkers goroutines
+// keyed by message key, so per-key order is preserved while unrelated keys are
+// processed concurrently. Offsets are only marked up to the highest contiguous
+// completed message — see offsetTracker.
 func (h *groupHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
 	topic := claim.Topic()
 	partition := claim.Partition()
-	groupID := h.cfg.Consumer.GroupID
+	workers := h.cfg.Consumer.MaxProcessingWorkers
+	// Transactions commit each message's offset as they go. Out-of-order
+	// completion would commit past a message still in flight, so exactly-once
+	// processes a partition strictly in order.
+	if h.txnProd != nil {
+		workers = 1
+	}
 
 	h.logger.Info("starting partition consumer",
 		zap.String("topic", topic),
 		zap.Int32("partition", partition),
+		zap.Int("workers", workers),
 	)
 
-	for msg := range claim.Messages() {
+	tracker := newOffsetTracker()
+	pool := newKeyedWorkerPool(workers, func(msg *sarama.ConsumerMessage) {
+		if !h.processMessage(session, msg) {
+			return
+		}
+		// The transaction already committed the offset.
+		if h.txnProd != nil {
+			return
+		}
+
+		// WHY NOT COMMIT PER MESSAGE:
+		// Committing per message is an RPC to the group coordinator per message.
+		// At 10k msg/s, that's 10k RPCs/s just for offset commits. Instead,
+		// we mark offsets and commit in batches.
+		//
+		// Workers race to mark, but sarama only ever moves a marked offset
+		// forward, so a late MarkMessage for a lower offset is a no-op.
+		if committable := tracker.Complete(msg); committable != nil {
+			session.MarkMessage(committable, "")
+		}
+	})
+	// Drain in-flight work before returning so no worker touches the session
+	// after sarama considers this claim released.
+	defer pool.Close()
+
+	for {
 		select {
 		case <-session.Context().Done():
 			return nil
-		default:
+		case msg, ok := <-claim.Messages():
+			if !ok {
+				return nil
+			}
+			tracker.Track(msg)
+			pool.Dispatch(msg)
 		}
+	}
+}
 
-		start := time.Now()
-
-		// Extract headers into a map for the handler.
-		headers := make(map[string]string, len(msg.Headers))
-		for _, hdr := range msg.Headers {
-			headers[string(hdr.Key)] = string(hdr.Value)
-		}
+// processMessage runs a single message through retry/DLQ and records metrics.
+// It returns false if processing was abandoned because the session ended; such
+// a message must not be marked, so the next owner of the partition redelivers it.
+func (h *groupHandler) processMessage(session sarama.ConsumerGroupSession, msg *sarama.ConsumerMessage) bool {
+	topic := msg.Topic
+	partition := msg.Partition
+	groupID := h.cfg.Consumer.GroupID
 
-		// Process with retry → DLQ.
-		err := h.processWithRetry(session.Context(), msg.Key, msg.Value, headers, topic)
-		elapsed := time.Since(start).Seconds()
-		metrics.ConsumeLatency.WithLabelValues(topic, groupID).Observe(elapsed)
+	// Messages still queued behind a worker when the session ends belong to
+	// the next owner of the partition — don't start them.
+	if session.Context().Err() != nil {
+		return false
+	}
 
-		if err != nil {
-			metrics.MessagesConsumed.WithLabelValues(topic, groupID, "dlq").Inc()
-			h.logger.Error("message sent to DLQ after all retries",
+	d := newDelivery(msg, h.retryTiers)
+
+	// The instance owning the original record's partition owns its state; see
+	// retry.go. A record forwarded before tiers were pinned may sit elsewhere.
+	if d.misrouted() && h.dlqProd != nil {
+		err := h.reroute(session.Context(), d)
+		switch {
+		case err == nil:
+			metrics.MessagesConsumed.WithLabelValues(topic, groupID, "rerouted").Inc()
+			return true
+		case errors.Is(err, ErrTransactionFailed):
+			h.fatal(err)
+			return false
+		default:
+			h.logger.Error("failed to move retry record to its original partition — processing it here",
 				zap.String("topic", topic),
 				zap.Int32("partition", partition),
-				zap.Int64("offset", msg.Offset),
+				zap.Int32("original_partition", d.origin.Partition),
 				zap.Error(err),
 			)
-		} else {
-			metrics.MessagesConsumed.WithLabelValues(topic, groupID, "success").Inc()
 		}
+	}
 
-		// WHY NOT COMMIT PER MESSAGE:
-		// Committing per message is an RPC to the group coordinator per message.
-		// At 10k msg/s, that's 10k RPCs/s just for offset commits. Instead,
-		// we mark offsets and commit in batches.
-		session.MarkMessage(msg, "")
+	// Records from a retry tier are held until they are due. Waiting here only
+	// blocks the tier partition, never the source topics.
+	if err := d.waitUntilDue(session.Context()); err != nil {
+		return false
+	}
 
-		lag := max(claim.HighWaterMarkOffset()-msg.Offset-1, 0)
+	start := time.Now()
 
-		metrics.ConsumerLag.WithLabelValues(
-			topic, groupID, strconv.Itoa(int(partition)),
-		).Set(float64(lag))
+	// Records from retry tiers were delayed on purpose; their produced_at says
+	// nothing about how fast the pipeline is.
+	if d.tier < 0 {
+		if producedAt, err := time.Parse(time.RFC3339Nano, d.headers[ProducedAtHeader]); err == nil {
+			metrics.EndToEndLatency.WithLabelValues(topic, groupID).Observe(max(start.Sub(producedAt).Seconds(), 0))
+		}
 	}
 
-	return nil
+	// Handlers see the ORIGINAL record's metadata, even when it is being
+	// retried from a tier topic.
+	ctx := ContextWithMetadata(session.Context(), d.origin)
+
+	// Continue the producer's trace; the handler's own produces become
+	// children of this span.
+	ctx, span := startConsumeSpan(ctx, groupID, d)
+
+	var status string
+	var err error
+	if len(h.cfg.Consumer.RetryTiers) > 0 {
+		status, err = h.processWithRetryTopics(ctx, d)
+	} else {
+		status, err = h.processWithRetry(ctx, d)
+	}
+	endConsumeSpan(span, status, err)
+	if errors.Is(err, ErrTransactionFailed) {
+		// The producer is likely fenced by a newer instance with the same
+		// transactional ID. Nothing this consumer produces can commit anymore.
+		h.fatal(err)
+		return false
+	}
+	if err != nil && session.Context().Err() != nil {
+		h.logger.Warn("processing interrupted by rebalance — message will be redelivered",
+			zap.String("topic", topic),
+			zap.Int32("partition", partition),
+			zap.Int64("offset", msg.Offset),
+		)
+		return false
+	}
+	elapsed := time.Since(start).Seconds()
+	metrics.ConsumeLatency.WithLabelValues(topic, groupID).Observe(elapsed)
+	metrics.MessagesConsumed.WithLabelValues(topic, groupID, status).Inc()
+	// A record with neither an ingested_at header nor a timestamp has no
+	// start to measure from.
+	if status == "success" && !d.origin.IngestedAt.IsZero() {
+		metrics.PipelineLatency.WithLabelValues(d.origin.Topic, groupID).Observe(max(time.Since(d.origin.IngestedAt).Seconds(), 0))
+	}
+
+	switch status {
+	case "dlq":
+		h.logger.Error("message sent to DLQ after all retries",
+			zap.String("topic", topic),
+			zap.Int32("partition", partition),
+			zap.Int64("offset", msg.Offset),
+			zap.Error(err),
+		)
+	case "retry":
+		h.logger.Warn("message forwarded to retry topic",
+			zap.String("topic", topic),
+			zap.Int32("partition", partition),
+			zap.Int64("offset", msg.Offset),
+			zap.Error(err),
+		)
+	}
+	return true
 }
 
 // processWithRetry attempts processing with exponential backoff.
 // If all retries fail, the message is sent to the DLQ.
-func (h *groupHandler) processWithRetry(ctx context.Context, key, value []byte, headers map[string]string, topic string) error {
-	var lastErr error
+// Used when no retry tiers are configured.
+func (h *groupHandler) processWithRetry(ctx context.Context, d *delivery) (string, error) {
+	var (
+		lastErr       error
+		firstFailedAt time.Time
+		lastFailedAt  time.Time
+		failures      int
+	)
+	msg := d.msg
 	maxRetries := h.cfg.Consumer.MaxRetries
 	backoff := h.cfg.Consumer.RetryBackoff
 
 	for attempt := 0; attempt <= maxRetries; attempt++ {
 		if attempt > 0 {
 			h.logger.Warn("retrying message processing",
-				zap.String("topic", topic),
+				zap.String("topic", msg.Topic),
 				zap.Int("attempt", attempt),
 				zap.Int("max_retries", maxRetries),
 			)
 
 			select {
 			case <-ctx.Done():
-				return ctx.Err()
+				return "", ctx.Err()
 			case <-time.After(backoff):
 			}
 
@@ -247,76 +549,203 @@ func (h *groupHandler) processWithRetry(ctx context.Context, key, value []byte,
 			}
 		}
 
-		lastErr = h.handler(ctx, key, value, headers)
+		lastErr = h.transact(ctx, msg, func(ctx context.Context) error {
+			return h.handler(ctx, msg.Key, msg.Value, d.headers)
+		})
 		if lastErr == nil {
-			return nil
+			return "success", nil
+		}
+		if errors.Is(lastErr, ErrTransactionFailed) {
+			return "", lastErr
+		}
+
+		failures++
+		lastFailedAt = time.Now().UTC()
+		if firstFailedAt.IsZero() {
+			firstFailedAt = lastFailedAt
+		}
+
+		// Poison and undecodable messages fail the same way every time.
+		if !ClassOf(lastErr).Retryable() {
+			break
 		}
 	}
 
 	// All retries exhausted → DLQ.
 	if h.dlqProd != nil {
-		h.sendToDLQ(ctx, key, value, headers, topic, lastErr)
+		err := h.transact(ctx, msg, func(ctx context.Context) error {
+			return h.sendToDLQ(ctx, d, failure{
+				err:           lastErr,
+				retries:       failures - 1,
+				maxRetries:    maxRetries,
+				firstFailedAt: firstFailedAt,
+				lastFailedAt:  lastFailedAt,
+			})
+		})
+		if errors.Is(err, ErrTransactionFailed) {
+			return "", err
+		}
 	}
 
-	return lastErr
+	return "dlq", lastErr
 }
 
-func (h *groupHandler) sendToDLQ(ctx context.Context, key, value []byte, headers map[string]string, sourceTopic string, processingErr error) {
+// processWithRetryTopics runs the handler once. On failure the message moves
+// to the next retry tier, or to the DLQ after the last one — see retry.go.
+func (h *groupHandler) processWithRetryTopics(ctx context.Context, d *delivery) (string, error) {
+	msg := d.msg
+	err := h.transact(ctx, msg, func(ctx context.Context) error {
+		return h.handler(ctx, msg.Key, msg.Value, d.headers)
+	})
+	if err == nil {
+		return "success", nil
+	}
+	if ctx.Err() != nil || errors.Is(err, ErrTransactionFailed) {
+		return "", err
+	}
+
+	failedAt := time.Now().UTC()
+	tiers := h.cfg.Consumer.RetryTiers
+	next := d.tier + 1
+
+	if ClassOf(err) == ClassDeferred && h.dlqProd != nil {
+		deferErr := h.deferToTier(ctx, d, err, failedAt)
+		if deferErr == nil {
+			return "deferred", nil
+		}
+		if errors.Is(deferErr, ErrTransactionFailed) {
+			return "", deferErr
+		}
+		h.logger.Error("failed to defer message to retry topic — sending to DLQ", zap.Error(deferErr))
+	} else if next < len(tiers) && h.dlqProd != nil && ClassOf(err).Retryable() {
+		// Poison and undecodable messages skip the tiers — they fail the same
+		// way every time.
+		delay := tiers[next]
+		retryTopic := RetryTopicName(h.cfg.Consumer.GroupID, delay)
+		produceErr := h.transact(ctx, msg, func(ctx context.Context) error {
+			_, _, forwardErr := h.dlqProd.ProduceRaw(ctx, retryTopic, d.origin.Partition, string(msg.Key), msg.Value, d.forwardHeaders(delay, err, failedAt))
+			return forwardErr
+		})
+		if produceErr == nil {
+			return "retry", err
+		}
+		if errors.Is(produceErr, ErrTransactionFailed) {
+			return "", produceErr
+		}
+		// Can't reach the retry tier — fall through to the DLQ rather than
+		// dropping the message or blocking the partition.
+		h.logger.Error("failed to forward message to retry topic — sending to DLQ",
+			zap.String("retry_topic", retryTopic),
+			zap.Error(produceErr),
+		)
+	}
+
+	if h.dlqProd != nil {
+		firstFailedAt := d.firstFailedAt
+		if firstFailedAt.IsZero() {
+			firstFailedAt = failedAt
+		}
+		dlqErr := h.transact(ctx, msg, func(ctx context.Context) error {
+			return h.sendToDLQ(ctx, d, failure{
+				err:           err,
+				retries:       d.attempts,
+				maxRetries:    len(tiers),
+				firstFailedAt: firstFailedAt,
+				lastFailedAt:  failedAt,
+			})
+		})
+		if errors.Is(dlqErr, ErrTransactionFailed) {
+			return "", dlqErr
+		}
+	}
+	return "dlq", err
+}
+
+// deferToTier republishes a deferred delivery: to the first retry tier, or
+// back to the tier it was read from.
+func (h *groupHandler) deferToTier(ctx context.Context, d *delivery, reason error, now time.Time) error {
+	delay := h.cfg.Consumer.RetryTiers[max(d.tier, 0)]
+	retryTopic := RetryTopicName(h.cfg.Consumer.GroupID, delay)
+	msg := d.msg
+	return h.transact(ctx, msg, func(ctx context.Context) error {
+		_, _, err := h.dlqProd.ProduceRaw(ctx, retryTopic, d.origin.Partition, string(msg.Key), msg.Value, d.deferHeaders(delay, reason, now))
+		if err != nil {
+			return fmt.Errorf("deferring message to %s: %w", retryTopic, err)
+		}
+		return nil
+	})
+}
+
+// reroute republishes a misrouted retry record, headers and all, to the
+// partition of its tier topic that matches its original record.
+func (h *groupHandler) reroute(ctx context.Context, d *delivery) error {
+	msg := d.msg
+	return h.transact(ctx, msg, func(ctx context.Context) error {
+		_, _, err := h.dlqProd.ProduceRaw(ctx, msg.Topic, d.origin.Partition, string(msg.Key), msg.Value, d.recordHeaders())
+		return err
+	})
+}
+
+// transact runs one step of processing msg — a handler attempt, a retry
+// forward or a DLQ write. In exactly_once mode the step runs in a transaction
+// that also commits msg's offset; a failed step is aborted, and so is all of
+// its output.
+func (h *groupHandler) transact(ctx context.Context, msg *sarama.ConsumerMessage, fn func(ctx context.Context) error) error {
+	if h.txnProd == nil {
+		return fn(ctx)
+	}
+	return h.txnProd.RunInTxn(ctx, h.cfg.Consumer.GroupID, msg, fn)
+}
+
+// failure summarizes every attempt at processing one message.
+type failure struct {
+	err           error
+	retries       int
+	maxRetries    int
+	firstFailedAt time.Time
+	lastFailedAt  time.Time
+}
+
+// sendToDLQ writes the DLQ envelope for d. A failed write is logged here; the
+// error is returned so an exactly-once transaction can abort.
+func (h *groupHandler) sendToDLQ(ctx context.Context, d *delivery, f failure) error {
+	msg := d.msg
+	origin := d.origin
 	envelope := models.DeadLetterEnvelope{
-		OriginalTopic:   sourceTopic,
-		OriginalKey:     string(key),
-		OriginalValue:   value,
-		OriginalHeaders: headers,
-		ErrorMessage:    processingErr.Error(),
-		ErrorType:       classifyError(processingErr),
-		RetryCount:      h.cfg.Consumer.MaxRetries,
-		MaxRetries:      h.cfg.Consumer.MaxRetries,
-		FirstFailedAt:   time.Now().UTC(),
-		LastFailedAt:    time.Now().UTC(),
-		ServiceName:     "consumer",
+		OriginalTopic:     origin.Topic,
+		OriginalPartition: origin.Partition,
+		OriginalOffset:    origin.Offset,
+		OriginalKey:       string(msg.Key),
+		OriginalValue:     msg.Value,
+		OriginalHeaders:   d.dlqHeaders(),
+		ErrorMessage:      f.err.Error(),
+		ErrorType:         string(ClassOf(f.err)),
+		RetryCount:        f.retries,
+		MaxRetries:        f.maxRetries,
+		FirstFailedAt:     f.firstFailedAt,
+		LastFailedAt:      f.lastFailedAt,
+		ServiceName:       h.service.Name,
+		ServiceVersion:    h.service.Version,
+		ConsumerGroup:     h.cfg.Consumer.GroupID,
 	}
 
 	dlqTopic := h.cfg.Consumer.DLQTopic
-	_, _, err := h.dlqProd.ProduceMessage(ctx, dlqTopic, string(key), envelope, map[string]string{
-		"dlq.source_topic": sourceTopic,
+	_, _, err := h.dlqProd.ProduceMessage(ctx, dlqTopic, string(msg.Key), envelope, map[string]string{
+		"dlq.source_topic": origin.Topic,
 		"dlq.error_type":   envelope.ErrorType,
 	})
+	metrics.DLQMessages.WithLabelValues(origin.Topic, envelope.ErrorType).Inc()
 
 	if err != nil {
 		// If we can't even write to the DLQ, log loudly. This is a P0 alert situation.
 		h.logger.Error("CRITICAL: failed to produce DLQ message — data loss risk",
-			zap.String("source_topic", sourceTopic),
-			zap.String("key", string(key)),
+			zap.String("source_topic", origin.Topic),
+			zap.Int32("partition", origin.Partition),
+			zap.Int64("offset", origin.Offset),
+			zap.String("key", string(msg.Key)),
 			zap.Error(err),
 		)
+		return err
 	}
-	metrics.DLQMessages.WithLabelValues(sourceTopic, envelope.ErrorType).Inc()
-}
-
-func classifyError(err error) string {
-	if err == nil {
-		return "NONE"
-	}
-	// In a real system, use error types or sentinel errors.
-	switch {
-	case isDeserializationError(err):
-		return "DESERIALIZATION"
-	case isTransientError(err):
-		return "TRANSIENT"
-	default:
-		return "PROCESSING"
-	}
-}
-
-func isDeserializationError(err error) bool {
-	_, ok := err.(*json.SyntaxError)
-	return ok
-}
-
-func isTransientError(_ error) bool {
-	// In production, check for specific transient errors:
-	// - context.DeadlineExceeded
-	// - specific HTTP status codes from downstream services
-	// - database connection errors
-	return false
+	return nil
 }
@@ -0,0 +1,117 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/serde"
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/pkg/circuitbreaker"
+)
+
+// -------------------------------------------------------------------------------
+// Error taxonomy for message handlers.
+//
+// The consumer decides what to do with a failed message from its ErrorClass:
+//   - retryable classes are retried (in-partition or via retry tiers),
+//   - non-retryable classes go straight to the DLQ — retrying a message that
+//     can never succeed only burns time and delays the rest of the partition.
+//
+// Handlers classify errors by wrapping them (kafka.Poison(err), ...). Errors
+// nobody classified are recognized where possible (timeouts, open circuits,
+// JSON errors) and otherwise treated as PROCESSING, which is retried.
+// -------------------------------------------------------------------------------
+
+// ErrorClass is the category of a processing failure. Its string value is what
+// ends up in DeadLetterEnvelope.ErrorType and the DLQ metrics.
+type ErrorClass string
+
+const (
+	// ClassProcessing: unclassified failure. Retried, since we can't prove it is permanent.
+	ClassProcessing ErrorClass = "PROCESSING"
+	// ClassTransient: a temporary condition (timeout, throttling) that is
+	// expected to clear on its own.
+	ClassTransient ErrorClass = "TRANSIENT"
+	// ClassDownstreamUnavailable: a dependency is down or its circuit is open.
+	ClassDownstreamUnavailable ErrorClass = "DOWNSTREAM_UNAVAILABLE"
+	// ClassDeserialization: the payload cannot be decoded. Never retried.
+	ClassDeserialization ErrorClass = "DESERIALIZATION"
+	// ClassPoison: the message is valid but can never be processed (business
+	// rule violation, handler panic). Never retried.
+	ClassPoison ErrorClass = "POISON"
+	// ClassDeferred: not a failure — the handler asked for the message to be
+	// processed later (e.g. its key is over quota). With retry tiers it is
+	// republished without using up a retry; without, it is retried in-partition.
+	ClassDeferred ErrorClass = "DEFERRED"
+)
+
+// Retryable reports whether a failure of this class may succeed on retry.
+func (c ErrorClass) Retryable() bool {
+	switch c {
+	case ClassDeserialization, ClassPoison:
+		return false
+	default:
+		return true
+	}
+}
+
+// Classifier is implemented by errors that know their own class. Implement it
+// on domain error types instead of wrapping them at every return site.
+type Classifier interface {
+	ErrorClass() ErrorClass
+}
+
+type classifiedError struct {
+	class ErrorClass
+	err   error
+}
+
+func (e *classifiedError) Error() string          { return e.err.Error() }
+func (e *classifiedError) Unwrap() error          { return e.err }
+func (e *classifiedError) ErrorClass() ErrorClass { return e.class }
+
+func classify(class ErrorClass, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &classifiedError{class: class, err: err}
+}
+
+// Transient marks err as a temporary failure worth retrying.
+func Transient(err error) error { return classify(ClassTransient, err) }
+
+// DownstreamUnavailable marks err as caused by an unavailable dependency.
+func DownstreamUnavailable(err error) error { return classify(ClassDownstreamUnavailable, err) }
+
+// Deserialization marks err as an undecodable payload. The message goes
+// straight to the DLQ.
+func Deserialization(err error) error { return classify(ClassDeserialization, err) }
+
+// Poison marks err as permanent for this message. The message goes straight
+// to the DLQ.
+func Poison(err error) error { return classify(ClassPoison, err) }
+
+// Defer asks for the message to be processed later, for the reason in err.
+func Defer(err error) error { return classify(ClassDeferred, err) }
+
+// ClassOf returns the class of err. An explicit classification anywhere in the
+// chain wins; the outermost one wins if there are several.
+func ClassOf(err error) ErrorClass {
+	var c Classifier
+	if errors.As(err, &c) {
+		return c.ErrorClass()
+	}
+
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return ClassTransient
+	case errors.Is(err, circuitbreaker.ErrCircuitOpen):
+		return ClassDownstreamUnavailable
+	case errors.Is(err, serde.ErrMalformed), errors.As(err, &syntaxErr), errors.As(err, &typeErr):
+		return ClassDeserialization
+	default:
+		return ClassProcessing
+	}
+}
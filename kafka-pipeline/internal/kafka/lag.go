@@ -0,0 +1,272 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/config"
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/metrics"
+	"go.uber.org/zap"
+)
+
+// -------------------------------------------------------------------------------
+// LAG MONITOR:
+// Lag measured while processing a message goes stale exactly when it matters —
+// a stuck partition processes nothing, so it keeps reporting the last value.
+// Instead, every interval the monitor asks the cluster for the group's
+// committed offset and the end offset of each partition this instance owns —
+// the high-water mark, or the last stable offset under exactly-once — and
+// derives lag from those.
+//
+// A partition is UNHEALTHY when:
+//   - its lag exceeds max_lag, or
+//   - records arrived since its committed offset last moved, and that was more
+//     than max_commit_age ago.
+// The second rule ignores idle partitions — nothing to commit is not stuck —
+// and transaction markers, which sit above the last committed offset forever.
+// A retry tier partition holds its head record until the tier delay has
+// passed, so its allowance is max_commit_age plus that delay.
+// -------------------------------------------------------------------------------
+
+type topicPartition struct {
+	topic     string
+	partition int32
+}
+
+type partitionLag struct {
+	committed   int64
+	end         int64 // as endOffset reads it
+	lag         int64
+	committedAt time.Time // when committed last changed (or was first seen)
+	endAtCommit int64     // end as of committedAt
+}
+
+// stalled reports whether records arrived after the last commit and nothing
+// has been committed for longer than maxAge.
+func (pl *partitionLag) stalled(now time.Time, maxAge time.Duration) bool {
+	return maxAge > 0 && pl.end > pl.endAtCommit && pl.lag > 0 && now.Sub(pl.committedAt) > maxAge
+}
+
+type lagMonitor struct {
+	client  sarama.Client
+	admin   sarama.ClusterAdmin
+	groupID string
+	cfg     config.LagCheckConfig
+	logger  *zap.Logger
+
+	// tierDelays maps each retry topic of the group to its delay.
+	tierDelays map[string]time.Duration
+
+	mu          sync.Mutex
+	assigned    map[string][]int32
+	partitions  map[topicPartition]*partitionLag
+	refreshedAt time.Time
+	refreshErr  error
+}
+
+func newLagMonitor(cfg *config.KafkaConfig, logger *zap.Logger) (*lagMonitor, error) {
+	saramaCfg := sarama.NewConfig()
+	saramaCfg.Version = sarama.V3_6_0_0
+	configureSecurity(saramaCfg, cfg)
+	// Read-committed consumers can't get past the last stable offset; endOffset
+	// measures their lag against it rather than against uncommitted writes.
+	if cfg.ExactlyOnce {
+		saramaCfg.Consumer.IsolationLevel = sarama.ReadCommitted
+	}
+
+	client, err := sarama.NewClient(cfg.Brokers, saramaCfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating lag monitor client: %w", err)
+	}
+	admin, err := sarama.NewClusterAdminFromClient(client)
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("creating lag monitor admin: %w", err)
+	}
+
+	tierDelays := make(map[string]time.Duration, len(cfg.Consumer.RetryTiers))
+	for _, delay := range cfg.Consumer.RetryTiers {
+		tierDelays[RetryTopicName(cfg.Consumer.GroupID, delay)] = delay
+	}
+
+	return &lagMonitor{
+		client:     client,
+		admin:      admin,
+		groupID:    cfg.Consumer.GroupID,
+		cfg:        cfg.Consumer.LagCheck,
+		logger:     logger,
+		tierDelays: tierDelays,
+		partitions: make(map[topicPartition]*partitionLag),
+	}, nil
+}
+
+// assign replaces the set of monitored partitions. Called with the claims of
+// every new session, and nil when a session ends.
+func (m *lagMonitor) assign(claims map[string][]int32) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.assigned = claims
+	for tp := range m.partitions {
+		if !containsPartition(claims[tp.topic], tp.partition) {
+			delete(m.partitions, tp)
+			metrics.ConsumerLag.DeleteLabelValues(tp.topic, m.groupID, strconv.Itoa(int(tp.partition)))
+			metrics.ConsumerCommitAge.DeleteLabelValues(tp.topic, m.groupID, strconv.Itoa(int(tp.partition)))
+		}
+	}
+}
+
+// run refreshes lag every interval until ctx ends.
+func (m *lagMonitor) run(ctx context.Context) {
+	ticker := time.NewTicker(m.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		m.refresh()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *lagMonitor) refresh() {
+	m.mu.Lock()
+	claims := m.assigned
+	m.mu.Unlock()
+
+	offsets, err := m.fetch(claims)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	m.refreshErr = err
+	if err != nil {
+		m.logger.Warn("failed to refresh consumer lag", zap.Error(err))
+		return
+	}
+	m.refreshedAt = now
+
+	for tp, fetched := range offsets {
+		// The assignment may have changed while we were fetching.
+		if !containsPartition(m.assigned[tp.topic], tp.partition) {
+			continue
+		}
+		pl, ok := m.partitions[tp]
+		if !ok || pl.committed != fetched.committed {
+			pl = &partitionLag{committed: fetched.committed, committedAt: now, endAtCommit: fetched.end}
+			m.partitions[tp] = pl
+		}
+		pl.end = fetched.end
+		pl.lag = fetched.lag
+
+		partition := strconv.Itoa(int(tp.partition))
+		metrics.ConsumerLag.WithLabelValues(tp.topic, m.groupID, partition).Set(float64(pl.lag))
+		metrics.ConsumerCommitAge.WithLabelValues(tp.topic, m.groupID, partition).Set(now.Sub(pl.committedAt).Seconds())
+	}
+}
+
+// fetch returns committed offset, end offset and lag of every claimed
+// partition.
+func (m *lagMonitor) fetch(claims map[string][]int32) (map[topicPartition]partitionLag, error) {
+	if len(claims) == 0 {
+		return nil, nil
+	}
+
+	resp, err := m.admin.ListConsumerGroupOffsets(m.groupID, claims)
+	if err != nil {
+		return nil, fmt.Errorf("listing offsets of group %s: %w", m.groupID, err)
+	}
+	if resp.Err != sarama.ErrNoError {
+		return nil, fmt.Errorf("listing offsets of group %s: %w", m.groupID, resp.Err)
+	}
+
+	out := make(map[topicPartition]partitionLag)
+	for topic, partitions := range claims {
+		for _, partition := range partitions {
+			end, err := endOffset(m.client, topic, partition)
+			if err != nil {
+				return nil, fmt.Errorf("fetching end offset of %s/%d: %w", topic, partition, err)
+			}
+
+			committed := int64(-1)
+			if block := resp.GetBlock(topic, partition); block != nil {
+				committed = block.Offset
+			}
+			// Nothing committed yet: the group starts from the oldest retained
+			// record (offset_initial -1 would start at the end, but lag is what a
+			// restart from scratch would have to read).
+			start := committed
+			if start < 0 {
+				if start, err = m.client.GetOffset(topic, partition, sarama.OffsetOldest); err != nil {
+					return nil, fmt.Errorf("fetching oldest offset of %s/%d: %w", topic, partition, err)
+				}
+			}
+
+			out[topicPartition{topic, partition}] = partitionLag{
+				committed: committed,
+				end:       end,
+				lag:       max(end-start, 0),
+			}
+		}
+	}
+	return out, nil
+}
+
+// check fails when the last refresh failed or is overdue, or when any owned
+// partition crosses a threshold.
+func (m *lagMonitor) check(context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.refreshErr != nil {
+		return m.refreshErr
+	}
+	now := time.Now()
+	if m.refreshedAt.IsZero() {
+		return errors.New("consumer lag not measured yet")
+	}
+	if age := now.Sub(m.refreshedAt); age > 3*m.cfg.Interval {
+		return fmt.Errorf("consumer lag last measured %s ago", age.Round(time.Second))
+	}
+
+	var errs []error
+	for tp, pl := range m.partitions {
+		switch {
+		case m.cfg.MaxLag > 0 && pl.lag > m.cfg.MaxLag:
+			errs = append(errs, fmt.Errorf("%s/%d: lag %d exceeds %d", tp.topic, tp.partition, pl.lag, m.cfg.MaxLag))
+		case pl.stalled(now, m.maxCommitAge(tp.topic)):
+			errs = append(errs, fmt.Errorf("%s/%d: no commit for %s with lag %d", tp.topic, tp.partition, now.Sub(pl.committedAt).Round(time.Second), pl.lag))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// maxCommitAge returns how long a partition of topic may go without a commit
+// while records wait. Zero disables the check.
+func (m *lagMonitor) maxCommitAge(topic string) time.Duration {
+	if m.cfg.MaxCommitAge <= 0 {
+		return 0
+	}
+	return m.cfg.MaxCommitAge + m.tierDelays[topic]
+}
+
+func (m *lagMonitor) close() error {
+	return m.admin.Close() // closes the client too
+}
+
+func containsPartition(partitions []int32, partition int32) bool {
+	for _, p := range partitions {
+		if p == partition {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+package kafka
+
+import (
+	"context"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// SourceTopicHeader is set on every consumed message to the topic it was read
+// from, overwriting any value the producer may have sent.
+const SourceTopicHeader = "source_topic"
+
+// Timestamp headers for end-to-end latency, both RFC 3339 with nanoseconds.
+// The producer sets ProducedAtHeader on every record it sends. It sets
+// IngestedAtHeader only on records that do not carry one yet: records
+// produced while handling a consumed record inherit that record's ingest
+// time, so it survives every hop from ingester to notifier.
+const (
+	ProducedAtHeader = "produced_at"
+	IngestedAtHeader = "ingested_at"
+)
+
+// MessageMetadata describes where a consumed record came from. The consumer
+// group attaches it to the context passed to every MessageHandler, so
+// multi-topic consumers can route on the real topic instead of sniffing payloads.
+type MessageMetadata struct {
+	Topic     string
+	Partition int32
+	Offset    int64
+	Timestamp time.Time
+	// IngestedAt is when the data first entered the pipeline — the
+	// IngestedAtHeader of the record, or its Timestamp if it has none. Zero
+	// when the record carries neither.
+	IngestedAt time.Time
+}
+
+type metadataKey struct{}
+
+// MetadataFromContext returns the metadata of the message being handled.
+// ok is false outside of a consumer group handler (e.g. in unit tests that
+// call a handler directly).
+func MetadataFromContext(ctx context.Context) (md MessageMetadata, ok bool) {
+	md, ok = ctx.Value(metadataKey{}).(MessageMetadata)
+	return md, ok
+}
+
+// ContextWithMetadata attaches md to ctx. Used by the consumer group; exported
+// so handlers can be driven with realistic metadata outside of Kafka.
+func ContextWithMetadata(ctx context.Context, md MessageMetadata) context.Context {
+	return context.WithValue(ctx, metadataKey{}, md)
+}
+
+func metadataOf(msg *sarama.ConsumerMessage) MessageMetadata {
+	return MessageMetadata{
+		Topic:     msg.Topic,
+		Partition: msg.Partition,
+		Offset:    msg.Offset,
+		Timestamp: msg.Timestamp,
+	}
+}
+
+// ingestTime returns the ingest time to stamp on a record produced with ctx:
+// that of the record being handled, or now for data entering the pipeline.
+func ingestTime(ctx context.Context) time.Time {
+	if md, ok := MetadataFromContext(ctx); ok && !md.IngestedAt.IsZero() {
+		return md.IngestedAt
+	}
+	return time.Now().UTC()
+}
@@ -2,20 +2,37 @@ package kafka
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/IBM/sarama"
 	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/config"
 	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/metrics"
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/serde"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
 type Producer struct {
-	producer sarama.SyncProducer
-	cfg      *config.KafkaConfig
-	logger   *zap.Logger
+	producer   sarama.SyncProducer
+	async      sarama.AsyncProducer // set in async mode instead of producer
+	serializer serde.Serializer
+	cfg        *config.KafkaConfig
+	logger     *zap.Logger
+
+	closeMu sync.RWMutex
+	closed  bool
+
+	// Async mode only — see async.go.
+	buffered  chan struct{} // one token per record sent but not yet acknowledged
+	resultsWg sync.WaitGroup
+
+	// txnMu serializes commits. A transactional producer has exactly one
+	// open transaction at a time, so goroutines take turns to send their
+	// staged records and commit — see runTxn.
+	txnMu sync.Mutex
 }
 
 func NewProducer(cfg *config.KafkaConfig, logger *zap.Logger) (*Producer, error) {
@@ -49,6 +66,21 @@ func NewProducer(cfg *config.KafkaConfig, logger *zap.Logger) (*Producer, error)
 	saramaCfg.Producer.Flush.Frequency = time.Duration(cfg.Producer.LingerMs) * time.Millisecond
 	saramaCfg.Producer.Flush.Bytes = cfg.Producer.BatchSize
 
+	// Key-hash partitioning by default, with an escape hatch for records that
+	// must land on a specific partition (state store changelogs).
+	saramaCfg.Producer.Partitioner = newPinnablePartitioner
+
+	// --- Transactions (exactly-once) ---
+	// Transactions require idempotence, acks=all and a single in-flight
+	// request per connection — sarama rejects the config otherwise.
+	if cfg.ExactlyOnce {
+		saramaCfg.Version = sarama.V3_6_0_0
+		saramaCfg.Producer.Transaction.ID = cfg.Producer.TransactionalID
+		saramaCfg.Producer.Idempotent = true
+		saramaCfg.Producer.RequiredAcks = sarama.WaitForAll
+		saramaCfg.Net.MaxOpenRequests = 1
+	}
+
 	// --- Security ---
 	if cfg.SecurityProtocol == "SASL_SSL" {
 		saramaCfg.Net.TLS.Enable = true
@@ -63,37 +95,134 @@ func NewProducer(cfg *config.KafkaConfig, logger *zap.Logger) (*Producer, error)
 		}
 	}
 
-	producer, err := sarama.NewSyncProducer(cfg.Brokers, saramaCfg)
-	if err != nil {
-		return nil, fmt.Errorf("creating sync producer: %w", err)
+	p := &Producer{
+		serializer: &serde.JSONSerde{},
+		cfg:        cfg,
+		logger:     logger,
+	}
+
+	if cfg.Producer.Async {
+		async, err := sarama.NewAsyncProducer(cfg.Brokers, saramaCfg)
+		if err != nil {
+			return nil, fmt.Errorf("creating async producer: %w", err)
+		}
+		p.async = async
+		p.buffered = make(chan struct{}, cfg.Producer.MaxBuffered)
+		p.resultsWg.Add(2)
+		go p.handleSuccesses()
+		go p.handleErrors()
+	} else {
+		producer, err := sarama.NewSyncProducer(cfg.Brokers, saramaCfg)
+		if err != nil {
+			return nil, fmt.Errorf("creating sync producer: %w", err)
+		}
+		p.producer = producer
 	}
 
 	logger.Info("kafka producer initialized",
 		zap.Strings("brokers", cfg.Brokers),
-		zap.Bool("idempotent", cfg.Producer.IdempotentEnabled),
+		zap.Bool("idempotent", saramaCfg.Producer.Idempotent),
+		zap.Bool("transactional", cfg.ExactlyOnce),
+		zap.Bool("async", cfg.Producer.Async),
 		zap.String("compression", cfg.Producer.Compression),
 	)
 
-	return &Producer{
-		producer: producer,
-		cfg:      cfg,
-		logger:   logger,
-	}, nil
+	return p, nil
 }
 
-func (p *Producer) ProduceMessage(ctx context.Context, topic, key string, value any, headers map[string]string) (partition int32, offset int64, err error) {
-	start := time.Now()
+// UseSerializer replaces the default plain-JSON serializer used by
+// ProduceMessage. Must be called before the producer is shared.
+func (p *Producer) UseSerializer(s serde.Serializer) {
+	p.serializer = s
+}
+
+// Serializer returns the serializer ProduceMessage encodes values with.
+func (p *Producer) Serializer() serde.Serializer {
+	return p.serializer
+}
 
+func (p *Producer) ProduceMessage(ctx context.Context, topic, key string, value any, headers map[string]string) (partition int32, offset int64, err error) {
 	// Serialize
-	payload, err := json.Marshal(value)
+	payload, err := p.serializer.Serialize(topic, value)
 	if err != nil {
 		metrics.MessagesProduced.WithLabelValues(topic, "error").Inc()
-		return 0, 0, fmt.Errorf("marshaling message: %w", err)
+		return 0, 0, fmt.Errorf("serializing message: %w", err)
 	}
 
-	// Build headers. Always include trace context for distributed tracing.
+	return p.send(ctx, topic, -1, key, payload, headers)
+}
+
+// ProduceRaw sends an already-serialized value. A nil value produces a
+// tombstone, which is how deletes are expressed on compacted topics.
+// partition >= 0 pins the record to that partition instead of hashing the key.
+func (p *Producer) ProduceRaw(ctx context.Context, topic string, partition int32, key string, value []byte, headers map[string]string) (int32, int64, error) {
+	return p.send(ctx, topic, partition, key, value, headers)
+}
+
+func (p *Producer) send(ctx context.Context, topic string, pinned int32, key string, payload []byte, headers map[string]string) (int32, int64, error) {
+	// In async mode this keeps ProduceMessage's contract — it returns once the
+	// broker has acknowledged — but many goroutines can have records in flight.
+	d, err := p.dispatch(ctx, topic, pinned, key, payload, headers, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	if p.inTxn(ctx) {
+		return -1, -1, nil // staged; sent when the transaction commits
+	}
+	return d.Wait(ctx)
+}
+
+// dispatch hands one record to the underlying producer. In sync mode the
+// returned Delivery is already complete.
+func (p *Producer) dispatch(ctx context.Context, topic string, pinned int32, key string, payload []byte, headers map[string]string, callback DeliveryFunc) (d *Delivery, err error) {
+	// A transactional producer rejects sends outside a transaction. Records
+	// produced outside RunInTxn (ingestion, evictions, the DLQ replayer) get
+	// a transaction of their own.
+	if p.Transactional() && !p.inTxn(ctx) {
+		err = p.runTxn(ctx, func(ctx context.Context) error {
+			var dispatchErr error
+			d, dispatchErr = p.dispatch(ctx, topic, pinned, key, payload, headers, callback)
+			return dispatchErr
+		}, nil)
+		return d, err
+	}
+
+	ctx, span := startProduceSpan(ctx, topic, key)
+	headers = injectTraceContext(ctx, headers) // a copy from here on
+	if _, ok := headers[IngestedAtHeader]; !ok {
+		headers[IngestedAtHeader] = ingestTime(ctx).Format(time.RFC3339Nano)
+	}
+	msg := buildMessage(topic, pinned, key, payload, headers)
+	meta := msg.Metadata.(*recordMeta)
+	meta.span = span
+	meta.delivery = &Delivery{done: make(chan struct{}), callback: callback}
+
+	if txn := p.ownTxn(ctx); txn != nil {
+		txn.msgs = append(txn.msgs, msg)
+		return meta.delivery, nil
+	}
+
+	if p.async != nil {
+		if err := p.enqueue(ctx, msg); err != nil {
+			endProduceSpan(span, msg, err)
+			return nil, err
+		}
+		return meta.delivery, nil
+	}
+
+	meta.sentAt = time.Now()
+	_, _, err = p.producer.SendMessage(msg)
+	p.finish(msg, err)
+	return meta.delivery, nil
+}
+
+func buildMessage(topic string, pinned int32, key string, payload []byte, headers map[string]string) *sarama.ProducerMessage {
+	// Build headers. headers already carries traceparent/tracestate (see dispatch).
 	var recordHeaders []sarama.RecordHeader
 	for k, v := range headers {
+		if k == ProducedAtHeader {
+			continue // handlers often pass on the headers they consumed
+		}
 		recordHeaders = append(recordHeaders, sarama.RecordHeader{
 			Key:   []byte(k),
 			Value: []byte(v),
@@ -101,45 +230,304 @@ func (p *Producer) ProduceMessage(ctx context.Context, topic, key string, value
 	}
 	// Inject produced-at timestamp for end-to-end latency tracking.
 	recordHeaders = append(recordHeaders, sarama.RecordHeader{
-		Key:   []byte("produced_at"),
+		Key:   []byte(ProducedAtHeader),
 		Value: []byte(time.Now().UTC().Format(time.RFC3339Nano)),
 	})
 
 	msg := &sarama.ProducerMessage{
 		Topic:   topic,
 		Key:     sarama.StringEncoder(key),
-		Value:   sarama.ByteEncoder(payload),
 		Headers: recordHeaders,
 	}
+	// Leave Value nil for tombstones — a zero-length ByteEncoder is NOT a tombstone.
+	if payload != nil {
+		msg.Value = sarama.ByteEncoder(payload)
+	}
+	meta := &recordMeta{}
+	if pinned >= 0 {
+		msg.Partition = pinned
+		meta.pinned = true
+	}
+	msg.Metadata = meta
+	return msg
+}
 
-	partition, offset, err = p.producer.SendMessage(msg)
-	elapsed := time.Since(start).Seconds()
-	metrics.ProduceLatency.WithLabelValues(topic).Observe(elapsed)
+// finish records the broker's answer for msg — metrics, logs, span — and
+// completes its Delivery.
+func (p *Producer) finish(msg *sarama.ProducerMessage, err error) {
+	meta := msg.Metadata.(*recordMeta)
+	elapsed := time.Since(meta.sentAt).Seconds()
+	key, _ := msg.Key.Encode()
+
+	metrics.ProduceLatency.WithLabelValues(msg.Topic).Observe(elapsed)
+	endProduceSpan(meta.span, msg, err)
 
 	if err != nil {
-		metrics.MessagesProduced.WithLabelValues(topic, "error").Inc()
+		metrics.MessagesProduced.WithLabelValues(msg.Topic, "error").Inc()
 		p.logger.Error("failed to produce message",
-			zap.String("topic", topic),
-			zap.String("key", key),
+			zap.String("topic", msg.Topic),
+			zap.String("key", string(key)),
 			zap.Error(err),
 		)
-		return 0, 0, fmt.Errorf("sending message to %s: %w", topic, err)
+		meta.delivery.complete(0, 0, fmt.Errorf("sending message to %s: %w", msg.Topic, err))
+		return
 	}
 
-	metrics.MessagesProduced.WithLabelValues(topic, "success").Inc()
+	metrics.MessagesProduced.WithLabelValues(msg.Topic, "success").Inc()
 	p.logger.Debug("message produced",
-		zap.String("topic", topic),
-		zap.Int32("partition", partition),
-		zap.Int64("offset", offset),
+		zap.String("topic", msg.Topic),
+		zap.Int32("partition", msg.Partition),
+		zap.Int64("offset", msg.Offset),
 		zap.Float64("latency_sec", elapsed),
 	)
+	meta.delivery.complete(msg.Partition, msg.Offset, nil)
+}
+
+// Transactional reports whether the producer runs in exactly-once mode.
+func (p *Producer) Transactional() bool {
+	return p.producer != nil && p.producer.IsTransactional()
+}
+
+// ErrTransactionFailed wraps failures of the transaction protocol itself
+// (begin, offset commit, commit, abort) as opposed to failures of the work
+// inside the transaction. The producer is usually fenced or in a fatal state
+// afterwards; the only safe reaction is to stop and let a fresh instance take over.
+var ErrTransactionFailed = errors.New("kafka transaction failed")
+
+// RunInTxn runs fn inside a transaction that also commits msg's offset for
+// groupID. Every record fn produces through this producer with the ctx it is
+// given becomes visible to read_committed consumers atomically with the offset
+// commit — or not at all. If fn fails the transaction is aborted and fn's
+// error returned.
+//
+// Records are staged, not sent, while fn runs: produce calls with its ctx
+// return at once, with partition and offset -1, and the records go out when fn
+// returns. Only that last step is serialized, so handlers of different
+// partitions still run concurrently. State that must only change if the
+// transaction commits goes through AfterCommit.
+func (p *Producer) RunInTxn(ctx context.Context, groupID string, msg *sarama.ConsumerMessage, fn func(ctx context.Context) error) error {
+	return p.runTxn(ctx, fn, func() error {
+		return p.producer.AddMessageToTxn(msg, groupID, nil)
+	})
+}
+
+// Transact runs fn in a transaction of its own, so everything fn produces with
+// the ctx it is given commits atomically. Work that is not driven by a consumed
+// record — timers, evictions — uses this. On a non-transactional producer, or
+// when ctx is already inside a transaction, fn simply runs.
+func (p *Producer) Transact(ctx context.Context, fn func(ctx context.Context) error) error {
+	if !p.Transactional() || p.inTxn(ctx) {
+		return fn(ctx)
+	}
+	return p.runTxn(ctx, fn, nil)
+}
+
+// runTxn runs fn with a ctx that stages what it produces, then sends the
+// staged records and commits under txnMu.
+func (p *Producer) runTxn(ctx context.Context, fn func(ctx context.Context) error, addOffsets func() error) error {
+	txn := &stagedTxn{producer: p}
+	err := fn(context.WithValue(ctx, txnKey{}, txn))
+	if err == nil {
+		err = p.commit(txn, addOffsets)
+	}
+	if err == nil {
+		err = txn.applyCommitted()
+	}
+	txn.end(err)
+	return err
+}
+
+// commit sends txn's records in one transaction.
+func (p *Producer) commit(txn *stagedTxn, addOffsets func() error) error {
+	p.txnMu.Lock()
+	defer p.txnMu.Unlock()
+
+	if err := p.producer.BeginTxn(); err != nil {
+		return fmt.Errorf("%w: begin: %v", ErrTransactionFailed, err)
+	}
 
-	return partition, offset, err
+	if err := p.sendStaged(txn); err != nil {
+		if abortErr := p.producer.AbortTxn(); abortErr != nil {
+			return fmt.Errorf("%w: abort after %v: %v", ErrTransactionFailed, err, abortErr)
+		}
+		return err
+	}
+
+	if addOffsets != nil {
+		if err := addOffsets(); err != nil {
+			p.producer.AbortTxn()
+			return fmt.Errorf("%w: adding offsets: %v", ErrTransactionFailed, err)
+		}
+	}
+
+	if err := p.producer.CommitTxn(); err != nil {
+		p.producer.AbortTxn()
+		return fmt.Errorf("%w: commit: %v", ErrTransactionFailed, err)
+	}
+	return nil
+}
+
+// sendStaged sends txn's records as one batch and completes their Deliveries.
+func (p *Producer) sendStaged(txn *stagedTxn) error {
+	if len(txn.msgs) == 0 {
+		return nil
+	}
+	now := time.Now()
+	for _, msg := range txn.msgs {
+		msg.Metadata.(*recordMeta).sentAt = now
+	}
+
+	err := p.producer.SendMessages(txn.msgs)
+	failed := make(map[*sarama.ProducerMessage]error)
+	var perrs sarama.ProducerErrors
+	switch {
+	case errors.As(err, &perrs):
+		for _, perr := range perrs {
+			failed[perr.Msg] = perr.Err
+		}
+	case err != nil:
+		for _, msg := range txn.msgs {
+			failed[msg] = err
+		}
+	}
+	for _, msg := range txn.msgs {
+		p.finish(msg, failed[msg])
+	}
+	txn.msgs = nil
+	if err != nil {
+		return fmt.Errorf("producing in transaction: %w", err)
+	}
+	return nil
+}
+
+// stagedTxn collects what a transaction's fn produces and what it wants done
+// once the transaction commits, or ends.
+type stagedTxn struct {
+	producer    *Producer // the one that commits it
+	msgs        []*sarama.ProducerMessage
+	afterCommit []func() error
+	atEnd       []func()
+}
+
+// applyCommitted runs the AfterCommit funcs. The transaction is committed by
+// then; a func that fails leaves local state behind the committed records,
+// which only a restore can repair — hence ErrTransactionFailed.
+func (t *stagedTxn) applyCommitted() error {
+	for _, fn := range t.afterCommit {
+		if err := fn(); err != nil {
+			return fmt.Errorf("%w: applying committed state: %v", ErrTransactionFailed, err)
+		}
+	}
+	return nil
+}
+
+// end completes the Deliveries of records never sent and runs the AtTxnEnd
+// funcs, last registered first.
+func (t *stagedTxn) end(err error) {
+	if err == nil {
+		err = errors.New("transaction ended before the record was sent")
+	}
+	for _, msg := range t.msgs {
+		meta := msg.Metadata.(*recordMeta)
+		endProduceSpan(meta.span, msg, err)
+		meta.delivery.complete(0, 0, fmt.Errorf("transaction aborted: %w", err))
+	}
+	for i := len(t.atEnd) - 1; i >= 0; i-- {
+		t.atEnd[i]()
+	}
+}
+
+type txnKey struct{}
+
+func txnOf(ctx context.Context) *stagedTxn {
+	txn, _ := ctx.Value(txnKey{}).(*stagedTxn)
+	return txn
+}
+
+// ownTxn returns the transaction ctx belongs to if p runs it. Records another
+// producer is handed with that ctx are not part of it: they are sent by that
+// producer, at once, whatever becomes of the transaction.
+func (p *Producer) ownTxn(ctx context.Context) *stagedTxn {
+	if txn := txnOf(ctx); txn != nil && txn.producer == p {
+		return txn
+	}
+	return nil
+}
+
+func (p *Producer) inTxn(ctx context.Context) bool {
+	return p.ownTxn(ctx) != nil
+}
+
+// AfterCommit runs fn once the transaction ctx belongs to has committed, and
+// not at all if it aborts. Outside a transaction fn runs right away. Local
+// state stores use it so that an aborted transaction leaves no trace.
+func AfterCommit(ctx context.Context, fn func() error) error {
+	if txn := txnOf(ctx); txn != nil {
+		txn.afterCommit = append(txn.afterCommit, fn)
+		return nil
+	}
+	return fn()
+}
+
+// AtTxnEnd runs fn when the transaction ctx belongs to ends, committed or not
+// — after the AfterCommit funcs. Outside a transaction fn runs right away.
+// Locks that guard state written in the transaction are released through it:
+// the state changes on commit, not when the handler returns.
+func AtTxnEnd(ctx context.Context, fn func()) {
+	if txn := txnOf(ctx); txn != nil {
+		txn.atEnd = append(txn.atEnd, fn)
+		return
+	}
+	fn()
 }
 
 // Close shuts down the producer, flushing any pending messages.
-// ALWAYS defer this — unflushed messages are lost.
+// ALWAYS defer this — unflushed messages are lost. Calls after the first
+// return nil.
 func (p *Producer) Close() error {
+	p.closeMu.Lock()
+	if p.closed {
+		p.closeMu.Unlock()
+		return nil
+	}
+	p.closed = true
+	p.closeMu.Unlock()
+
 	p.logger.Info("shutting down kafka producer")
+	if p.async != nil {
+		return p.closeAsync()
+	}
 	return p.producer.Close()
 }
+
+// recordMeta travels with a ProducerMessage through sarama.
+type recordMeta struct {
+	// pinned: the Partition field was set by the caller and must be honored.
+	pinned bool
+
+	sentAt   time.Time
+	span     trace.Span
+	delivery *Delivery
+}
+
+type pinnablePartitioner struct {
+	hash sarama.Partitioner
+}
+
+func newPinnablePartitioner(topic string) sarama.Partitioner {
+	return &pinnablePartitioner{hash: sarama.NewHashPartitioner(topic)}
+}
+
+func (p *pinnablePartitioner) Partition(msg *sarama.ProducerMessage, numPartitions int32) (int32, error) {
+	if meta, ok := msg.Metadata.(*recordMeta); ok && meta.pinned {
+		if msg.Partition >= numPartitions {
+			return 0, fmt.Errorf("pinned partition %d out of range for %d partitions", msg.Partition, numPartitions)
+		}
+		return msg.Partition, nil
+	}
+	return p.hash.Partition(msg, numPartitions)
+}
+
+func (p *pinnablePartitioner) RequiresConsistency() bool {
+	return true
+}
@@ -0,0 +1,56 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/config"
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/serde"
+	"go.uber.org/zap"
+)
+
+// newMockProducer returns a Producer sending to a mock on which every topic
+// has 12 partitions. A transactionalID makes it transactional.
+func newMockProducer(t *testing.T, cfg *config.KafkaConfig, transactionalID string) (*Producer, *mocks.SyncProducer) {
+	t.Helper()
+	saramaCfg := mocks.NewTestConfig()
+	saramaCfg.Producer.Partitioner = newPinnablePartitioner
+	if transactionalID != "" {
+		saramaCfg.Version = sarama.V3_6_0_0
+		saramaCfg.Producer.Transaction.ID = transactionalID
+		saramaCfg.Producer.Idempotent = true
+		saramaCfg.Producer.RequiredAcks = sarama.WaitForAll
+		saramaCfg.Net.MaxOpenRequests = 1
+	}
+	mock := mocks.NewSyncProducer(t, saramaCfg)
+	mock.SetDefaultPartitions(12)
+	t.Cleanup(func() { mock.Close() })
+	return &Producer{producer: mock, serializer: &serde.JSONSerde{}, cfg: cfg, logger: zap.NewNop()}, mock
+}
+
+func TestTxnOnlyStagesItsOwnProducer(t *testing.T) {
+	primary, _ := newMockProducer(t, &config.KafkaConfig{ExactlyOnce: true}, "fraud-detector-0")
+	shadow, shadowMock := newMockProducer(t, &config.KafkaConfig{}, "")
+	shadowMock.ExpectSendMessageAndSucceed()
+
+	errAbort := errors.New("scoring failed")
+	err := primary.Transact(context.Background(), func(ctx context.Context) error {
+		if partition, _, err := primary.ProduceRaw(ctx, "txn.fraud-results.v1", -1, "sender-1", []byte(`{}`), nil); err != nil || partition != -1 {
+			t.Errorf("primary record: got partition %d, %v; want it staged", partition, err)
+		}
+		// The shadow producer shares the handler's ctx but not its transaction.
+		if partition, _, err := shadow.ProduceRaw(ctx, "txn.fraud-shadow-results.v1", -1, "txn-1", []byte(`{}`), nil); err != nil || partition < 0 {
+			t.Errorf("shadow record: got partition %d, %v; want it sent", partition, err)
+		}
+		if staged := len(txnOf(ctx).msgs); staged != 1 {
+			t.Errorf("transaction staged %d records, want 1", staged)
+		}
+		return errAbort
+	})
+	if !errors.Is(err, errAbort) {
+		t.Fatalf("Transact: got %v, want %v", err, errAbort)
+	}
+}
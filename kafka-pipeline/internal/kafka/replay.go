@@ -0,0 +1,344 @@
+package kafka
+
+import (
+	"cmp"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/config"
+)
+
+// -------------------------------------------------------------------------------
+// WHERE A REPLAY ENDS:
+// A replay reads up to the end offset observed when it starts — the high-water
+// mark, or with read_committed the last stable offset, as records of a
+// transaction still open are not delivered until it ends.
+//
+// Not every offset below the end is delivered, though. Transaction markers
+// never are, nor (with read_committed) records of aborted transactions, so
+// when the tail of a partition is a commit marker the last record arrives one
+// offset short of the end. When nothing has arrived for replayProbeInterval,
+// the replay fetches the rest of the range itself: if it holds nothing a
+// consumer would be handed, the replay is complete. If it does, the consumer
+// is behind, and after replayStallTimeout without a record the replay fails
+// rather than restoring partial state.
+// -------------------------------------------------------------------------------
+const (
+	replayProbeInterval = time.Second
+	replayStallTimeout  = 30 * time.Second
+
+	replayProbeMaxBytes = 1 << 20
+)
+
+// ReplayPartitions reads each partition in from, starting at the mapped offset
+// (sarama.OffsetOldest for the beginning; offsets below the earliest retained
+// one are clamped to it), up to the high-water mark observed at call time,
+// passing each record to fn in offset order. It is the building block for
+// rebuilding local state from a compacted topic.
+func ReplayPartitions(ctx context.Context, cfg *config.KafkaConfig, topic string, from map[int32]int64, fn func(*sarama.ConsumerMessage) error) error {
+	client, consumer, err := newReplayClient(cfg)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	defer consumer.Close()
+
+	for partition, start := range from {
+		if err := replayPartition(ctx, client, consumer, topic, partition, start, fn); err != nil {
+			return fmt.Errorf("replaying %s/%d: %w", topic, partition, err)
+		}
+	}
+	return nil
+}
+
+// ReplayTopic is ReplayPartitions over every partition of topic, each read
+// from its earliest retained offset. Partitions are read one after another,
+// so records are in offset order per partition but not globally.
+func ReplayTopic(ctx context.Context, cfg *config.KafkaConfig, topic string, fn func(*sarama.ConsumerMessage) error) error {
+	return ReplayTopicFrom(ctx, cfg, topic, fromOldest, fn)
+}
+
+// ReplayTopicFrom is ReplayTopic with the start offset of each partition
+// chosen by from, e.g. one past a checkpoint.
+func ReplayTopicFrom(ctx context.Context, cfg *config.KafkaConfig, topic string, from func(partition int32) (int64, error), fn func(*sarama.ConsumerMessage) error) error {
+	client, consumer, err := newReplayClient(cfg)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	defer consumer.Close()
+
+	partitions, err := client.Partitions(topic)
+	if err != nil {
+		return fmt.Errorf("listing partitions of %s: %w", topic, err)
+	}
+	for _, partition := range partitions {
+		start, err := from(partition)
+		if err != nil {
+			return err
+		}
+		if err := replayPartition(ctx, client, consumer, topic, partition, start, fn); err != nil {
+			return fmt.Errorf("replaying %s/%d: %w", topic, partition, err)
+		}
+	}
+	return nil
+}
+
+// FollowTopic reads every partition of topic from the offset chosen by from
+// and, unlike the replays, does not stop at the high-water mark: it keeps
+// passing new records to fn until ctx is done or fn fails. Partitions are
+// read concurrently, so fn is called from one goroutine per partition.
+// Partitions added to the topic after the call are not followed.
+func FollowTopic(ctx context.Context, cfg *config.KafkaConfig, topic string, from func(partition int32) (int64, error), fn func(*sarama.ConsumerMessage) error) error {
+	client, consumer, err := newReplayClient(cfg)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	defer consumer.Close()
+
+	partitions, err := client.Partitions(topic)
+	if err != nil {
+		return fmt.Errorf("listing partitions of %s: %w", topic, err)
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	var wg sync.WaitGroup
+	for _, partition := range partitions {
+		start, err := from(partition)
+		if err != nil {
+			cancel(err)
+			break
+		}
+		pc, err := consumer.ConsumePartition(topic, partition, start)
+		if errors.Is(err, sarama.ErrOffsetOutOfRange) {
+			// Compacted or deleted past the start: take what is left.
+			pc, err = consumer.ConsumePartition(topic, partition, sarama.OffsetOldest)
+		}
+		if err != nil {
+			cancel(fmt.Errorf("following %s/%d: %w", topic, partition, err))
+			break
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer pc.Close()
+			if err := followPartition(ctx, pc, fn); err != nil {
+				cancel(fmt.Errorf("following %s/%d: %w", topic, partition, err))
+			}
+		}()
+	}
+	wg.Wait()
+	return context.Cause(ctx)
+}
+
+func followPartition(ctx context.Context, pc sarama.PartitionConsumer, fn func(*sarama.ConsumerMessage) error) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-pc.Errors():
+			return err
+		case msg := <-pc.Messages():
+			if err := fn(msg); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func fromOldest(int32) (int64, error) { return sarama.OffsetOldest, nil }
+
+func newReplayClient(cfg *config.KafkaConfig) (sarama.Client, sarama.Consumer, error) {
+	saramaCfg := sarama.NewConfig()
+	saramaCfg.Version = sarama.V3_6_0_0
+	saramaCfg.Consumer.Return.Errors = true
+	configureSecurity(saramaCfg, cfg)
+	// Aborted transactional writes must not be replayed into state stores.
+	if cfg.ExactlyOnce {
+		saramaCfg.Consumer.IsolationLevel = sarama.ReadCommitted
+	}
+
+	client, err := sarama.NewClient(cfg.Brokers, saramaCfg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("creating replay client: %w", err)
+	}
+
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		client.Close()
+		return nil, nil, fmt.Errorf("creating replay consumer: %w", err)
+	}
+	return client, consumer, nil
+}
+
+func replayPartition(ctx context.Context, client sarama.Client, consumer sarama.Consumer, topic string, partition int32, start int64, fn func(*sarama.ConsumerMessage) error) error {
+	oldest, err := client.GetOffset(topic, partition, sarama.OffsetOldest)
+	if err != nil {
+		return fmt.Errorf("fetching oldest offset: %w", err)
+	}
+	end, err := endOffset(client, topic, partition)
+	if err != nil {
+		return fmt.Errorf("fetching end offset: %w", err)
+	}
+	start = max(start, oldest)
+	if end <= start {
+		return nil // nothing to replay
+	}
+
+	pc, err := consumer.ConsumePartition(topic, partition, start)
+	if err != nil {
+		return fmt.Errorf("consuming partition: %w", err)
+	}
+	defer pc.Close()
+
+	next := start
+	lastRecord := time.Now()
+	probe := time.NewTimer(replayProbeInterval)
+	defer probe.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-pc.Errors():
+			return err
+		case msg := <-pc.Messages():
+			if err := fn(msg); err != nil {
+				return err
+			}
+			next = msg.Offset + 1
+			if next >= end {
+				return nil
+			}
+			lastRecord = time.Now()
+			probe.Reset(replayProbeInterval)
+		case <-probe.C:
+			pending, err := hasDeliverable(client, topic, partition, next, end)
+			if err != nil {
+				return fmt.Errorf("probing offsets %d-%d: %w", next, end, err)
+			}
+			if !pending {
+				return nil // only transaction markers or aborted records left
+			}
+			if idle := time.Since(lastRecord); idle > replayStallTimeout {
+				return fmt.Errorf("stalled at offset %d of %d: no record for %s", next, end, idle.Round(time.Second))
+			}
+			probe.Reset(replayProbeInterval)
+		}
+	}
+}
+
+// endOffset returns the end of the partition as a consumer with the client's
+// isolation level sees it: the high-water mark, or the last stable offset for
+// a read_committed client. Replays read up to it; lag is measured against it.
+func endOffset(client sarama.Client, topic string, partition int32) (int64, error) {
+	broker, err := client.Leader(topic, partition)
+	if err != nil {
+		return 0, err
+	}
+	req := sarama.NewOffsetRequest(client.Config().Version)
+	req.IsolationLevel = client.Config().Consumer.IsolationLevel
+	req.AddBlock(topic, partition, sarama.OffsetNewest, 1)
+
+	resp, err := broker.GetAvailableOffsets(req)
+	if err != nil {
+		return 0, err
+	}
+	block := resp.GetBlock(topic, partition)
+	if block == nil {
+		return 0, sarama.ErrIncompleteResponse
+	}
+	if !errors.Is(block.Err, sarama.ErrNoError) {
+		return 0, block.Err
+	}
+	if len(block.Offsets) != 1 {
+		return 0, sarama.ErrOffsetOutOfRange
+	}
+	return block.Offsets[0], nil
+}
+
+// hasDeliverable reports whether offsets [from, end) of the partition hold a
+// record a consumer with the client's isolation level would be handed —
+// anything but transaction markers and, with read_committed, records of
+// aborted transactions. Batches it cannot classify count as deliverable.
+func hasDeliverable(client sarama.Client, topic string, partition int32, from, end int64) (bool, error) {
+	broker, err := client.Leader(topic, partition)
+	if err != nil {
+		return false, err
+	}
+	isolation := client.Config().Consumer.IsolationLevel
+
+	for offset := from; offset < end; {
+		req := &sarama.FetchRequest{Version: 5, MinBytes: 1, MaxBytes: replayProbeMaxBytes, Isolation: isolation}
+		req.AddBlock(topic, partition, offset, replayProbeMaxBytes, -1)
+		resp, err := broker.Fetch(req)
+		if err != nil {
+			return false, err
+		}
+		block := resp.GetBlock(topic, partition)
+		if block == nil {
+			return false, sarama.ErrIncompleteResponse
+		}
+		if !errors.Is(block.Err, sarama.ErrNoError) {
+			return false, block.Err
+		}
+
+		// Producers with an aborted transaction under way at the current batch,
+		// as in sarama's consumer: a transaction starts at its FirstOffset and
+		// ends at the producer's abort marker.
+		aborted := slices.Clone(block.AbortedTransactions)
+		slices.SortFunc(aborted, func(a, b *sarama.AbortedTransaction) int { return cmp.Compare(a.FirstOffset, b.FirstOffset) })
+		abortedProducers := make(map[int64]bool)
+
+		progressed := false
+		for _, records := range block.RecordsSet {
+			batch := records.RecordBatch
+			if batch == nil {
+				return true, nil // legacy message set
+			}
+			if batch.FirstOffset >= end {
+				return false, nil
+			}
+			for len(aborted) > 0 && aborted[0].FirstOffset <= batch.FirstOffset {
+				abortedProducers[aborted[0].ProducerID] = true
+				aborted = aborted[1:]
+			}
+
+			switch {
+			case batch.Control:
+				for _, rec := range batch.Records {
+					if len(rec.Key) >= 4 && binary.BigEndian.Uint16(rec.Key[2:4]) == uint16(sarama.ControlRecordAbort) {
+						delete(abortedProducers, batch.ProducerID)
+					}
+				}
+			case isolation == sarama.ReadCommitted && batch.IsTransactional && abortedProducers[batch.ProducerID]:
+			default:
+				for _, rec := range batch.Records {
+					if o := batch.FirstOffset + rec.OffsetDelta; o >= from && o < end {
+						return true, nil
+					}
+				}
+			}
+
+			if next := batch.FirstOffset + int64(batch.LastOffsetDelta) + 1; next > offset {
+				offset = next
+				progressed = true
+			}
+		}
+		if !progressed {
+			// Nothing (complete) came back below end: we can't tell.
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,104 @@
+package kafka
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+const replayTestTopic = "velocity-changelog"
+
+// newReplayTestClient serves replayTestTopic/0 from a mock broker: every fetch
+// returns resp, and the partition's offsets run from 0 to end.
+func newReplayTestClient(t *testing.T, resp *sarama.FetchResponse, end int64) (sarama.Client, sarama.Consumer) {
+	t.Helper()
+	broker := sarama.NewMockBroker(t, 1)
+	t.Cleanup(broker.Close)
+
+	resp.Version = 5
+	block := resp.Blocks[replayTestTopic][0]
+	block.HighWaterMarkOffset = end
+	block.LastStableOffset = end
+
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"ApiVersionsRequest": sarama.NewMockApiVersionsResponse(t),
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader(replayTestTopic, 0, broker.BrokerID()),
+		"OffsetRequest": sarama.NewMockOffsetResponse(t).
+			SetOffset(replayTestTopic, 0, sarama.OffsetOldest, 0).
+			SetOffset(replayTestTopic, 0, sarama.OffsetNewest, end),
+		"FetchRequest": sarama.NewMockWrapper(resp),
+	})
+
+	// 0.11 consumers fetch with v5, which the mock responses are encoded in.
+	cfg := sarama.NewConfig()
+	cfg.Version = sarama.V0_11_0_0
+	cfg.Consumer.Return.Errors = true
+	cfg.Consumer.IsolationLevel = sarama.ReadCommitted
+
+	client, err := sarama.NewClient([]string{broker.Addr()}, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { consumer.Close() })
+	return client, consumer
+}
+
+func TestReplayPartitionEndsBeforeTrailingMarker(t *testing.T) {
+	resp := &sarama.FetchResponse{}
+	resp.AddRecordBatch(replayTestTopic, 0, nil, sarama.StringEncoder("a"), 0, 7, true)
+	resp.AddRecordBatch(replayTestTopic, 0, nil, sarama.StringEncoder("b"), 1, 7, true)
+	resp.AddControlRecord(replayTestTopic, 0, 2, 7, sarama.ControlRecordCommit)
+	client, consumer := newReplayTestClient(t, resp, 3)
+
+	var got []int64
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err := replayPartition(ctx, client, consumer, replayTestTopic, 0, sarama.OffsetOldest, func(msg *sarama.ConsumerMessage) error {
+		got = append(got, msg.Offset)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("replayPartition: %v", err)
+	}
+	if len(got) != 2 || got[0] != 0 || got[1] != 1 {
+		t.Fatalf("replayed offsets %v, want [0 1]", got)
+	}
+}
+
+func TestHasDeliverable(t *testing.T) {
+	// 0: aborted write, 1: its abort marker, 2: committed write, 3: its
+	// commit marker.
+	resp := &sarama.FetchResponse{}
+	resp.AddRecordBatch(replayTestTopic, 0, nil, sarama.StringEncoder("aborted"), 0, 7, true)
+	resp.AddControlRecord(replayTestTopic, 0, 1, 7, sarama.ControlRecordAbort)
+	resp.AddRecordBatch(replayTestTopic, 0, nil, sarama.StringEncoder("committed"), 2, 7, true)
+	resp.AddControlRecord(replayTestTopic, 0, 3, 7, sarama.ControlRecordCommit)
+	resp.Blocks[replayTestTopic][0].AbortedTransactions = []*sarama.AbortedTransaction{{ProducerID: 7, FirstOffset: 0}}
+	client, _ := newReplayTestClient(t, resp, 4)
+
+	for _, tt := range []struct {
+		from, end int64
+		want      bool
+	}{
+		{0, 2, false}, // only the aborted write and its marker
+		{0, 4, true},  // the committed write
+		{3, 4, false}, // only the commit marker
+	} {
+		got, err := hasDeliverable(client, replayTestTopic, 0, tt.from, tt.end)
+		if err != nil {
+			t.Fatalf("hasDeliverable(%d, %d): %v", tt.from, tt.end, err)
+		}
+		if got != tt.want {
+			t.Errorf("hasDeliverable(%d, %d) = %v, want %v", tt.from, tt.end, got, tt.want)
+		}
+	}
+}
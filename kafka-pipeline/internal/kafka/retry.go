@@ -0,0 +1,293 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/config"
+	"go.uber.org/zap"
+)
+
+// -------------------------------------------------------------------------------
+// NON-BLOCKING RETRY TOPICS (the Uber pattern).
+//
+// THE PROBLEM WITH IN-PARTITION RETRIES:
+// Sleeping between retries inside ConsumeClaim stalls every message behind the
+// failing one. A downstream outage of 30s turns into minutes of lag on every
+// partition, and nothing else makes progress while we wait.
+//
+// THE FIX:
+// A failed message is republished to a delay tier — e.g. <group>.retry-1m —
+// and its offset is marked immediately, so the main partition keeps flowing.
+// The same consumer group also subscribes to its retry topics. A message read
+// from a tier is held until its retry.not_before time, processed once more, and
+// on failure moves to the next tier. After the last tier it goes to the DLQ.
+//
+// Every message in a tier has the same delay, so a tier partition is naturally
+// ordered by not_before: waiting for the head of the partition never delays a
+// message that was due earlier.
+//
+// CO-PARTITIONING:
+// A message goes to the tier partition with the number of the partition it was
+// first read from, and tier topics have as many partitions as the source
+// topics. With config.ConsumerConfig.CoPartitioned the range assignor hands
+// partition p of every topic to the same instance, so a retried message is
+// processed where the state of its source partition lives. One found on any
+// other partition is moved to that one before it is handled.
+//
+// Deferred messages (kafka.Defer) take the same route without counting as a
+// failure: a source message moves to the first tier, a tier message back to
+// its own tier, and neither ever reaches the DLQ for being deferred.
+//
+// TRADE-OFF:
+// A retried message is processed after later messages with the same key. If a
+// handler needs strict per-key ordering even across failures, leave
+// retry_tiers empty and use in-partition retries.
+// -------------------------------------------------------------------------------
+
+// Headers carried by records on retry topics. They describe the ORIGINAL
+// record so handlers, metrics and the DLQ see the real source, not the tier.
+const (
+	retryHeaderPrefix         = "retry."
+	retryHeaderOriginalTopic  = "retry.original_topic"
+	retryHeaderOriginalPart   = "retry.original_partition"
+	retryHeaderOriginalOffset = "retry.original_offset"
+	retryHeaderOriginalTime   = "retry.original_timestamp"
+	retryHeaderAttempts       = "retry.attempts"
+	retryHeaderFirstFailedAt  = "retry.first_failed_at"
+	retryHeaderNotBefore      = "retry.not_before"
+	retryHeaderLastError      = "retry.last_error"
+	maxRetryErrorHeaderLength = 512
+)
+
+// RetryTopicName returns the topic for one delay tier of a consumer group,
+// e.g. "fraud-detector-v1.retry-1m". Retry topics are per group: another group
+// consuming the same source must not reprocess this group's failures.
+func RetryTopicName(groupID string, delay time.Duration) string {
+	// time.Duration prints 1m as "1m0s" and 1h as "1h0m0s"; trim the zero units.
+	d := delay.String()
+	if strings.HasSuffix(d, "m0s") {
+		d = strings.TrimSuffix(d, "0s")
+	}
+	if strings.HasSuffix(d, "h0m") {
+		d = strings.TrimSuffix(d, "0m")
+	}
+	return groupID + ".retry-" + d
+}
+
+// RetryTopicDefs returns the topic definitions for every retry tier of the
+// consumer, based on the retry_topic template in cfg.
+func RetryTopicDefs(cfg *config.ConsumerConfig) []config.TopicDef {
+	defs := make([]config.TopicDef, 0, len(cfg.RetryTiers))
+	for _, delay := range cfg.RetryTiers {
+		def := cfg.RetryTopic
+		def.Name = RetryTopicName(cfg.GroupID, delay)
+		defs = append(defs, def)
+	}
+	return defs
+}
+
+// delivery is one processing attempt of a record, with the provenance of the
+// record that ORIGINALLY failed — which differs from msg when msg was read
+// from a retry tier.
+type delivery struct {
+	msg           *sarama.ConsumerMessage
+	origin        MessageMetadata
+	headers       map[string]string
+	tier          int // index into RetryTiers the msg was read from; -1 for a source topic
+	attempts      int // handler attempts made before this delivery
+	firstFailedAt time.Time
+	notBefore     time.Time
+}
+
+// newDelivery builds the delivery for msg. Records from retry topics have their
+// retry.* headers decoded into provenance and removed from the handler view.
+func newDelivery(msg *sarama.ConsumerMessage, retryTiers map[string]int) *delivery {
+	headers := make(map[string]string, len(msg.Headers)+1)
+	for _, hdr := range msg.Headers {
+		headers[string(hdr.Key)] = string(hdr.Value)
+	}
+
+	d := &delivery{
+		msg:     msg,
+		origin:  metadataOf(msg),
+		headers: headers,
+		tier:    -1,
+	}
+
+	if tier, ok := retryTiers[msg.Topic]; ok {
+		d.tier = tier
+		if topic := headers[retryHeaderOriginalTopic]; topic != "" {
+			d.origin.Topic = topic
+		}
+		if p, err := strconv.ParseInt(headers[retryHeaderOriginalPart], 10, 32); err == nil {
+			d.origin.Partition = int32(p)
+		}
+		if o, err := strconv.ParseInt(headers[retryHeaderOriginalOffset], 10, 64); err == nil {
+			d.origin.Offset = o
+		}
+		if t, err := time.Parse(time.RFC3339Nano, headers[retryHeaderOriginalTime]); err == nil {
+			d.origin.Timestamp = t
+		}
+		d.attempts, _ = strconv.Atoi(headers[retryHeaderAttempts])
+		d.firstFailedAt, _ = time.Parse(time.RFC3339Nano, headers[retryHeaderFirstFailedAt])
+		d.notBefore, _ = time.Parse(time.RFC3339Nano, headers[retryHeaderNotBefore])
+
+		for k := range headers {
+			if strings.HasPrefix(k, retryHeaderPrefix) {
+				delete(headers, k)
+			}
+		}
+	}
+
+	d.origin.IngestedAt = d.origin.Timestamp
+	if t, err := time.Parse(time.RFC3339Nano, headers[IngestedAtHeader]); err == nil {
+		d.origin.IngestedAt = t
+	}
+
+	headers[SourceTopicHeader] = d.origin.Topic
+	return d
+}
+
+// waitUntilDue blocks until the delivery's not-before time, or ctx ends.
+func (d *delivery) waitUntilDue(ctx context.Context) error {
+	wait := time.Until(d.notBefore)
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// forwardHeaders returns the headers to publish on the next tier: the original
+// headers (minus ones the pipeline sets itself) plus fresh retry provenance.
+func (d *delivery) forwardHeaders(delay time.Duration, lastErr error, failedAt time.Time) map[string]string {
+	headers := make(map[string]string, len(d.headers)+9)
+	for k, v := range d.headers {
+		if k == SourceTopicHeader || k == ProducedAtHeader {
+			continue
+		}
+		headers[k] = v
+	}
+
+	firstFailedAt := d.firstFailedAt
+	if firstFailedAt.IsZero() {
+		firstFailedAt = failedAt
+	}
+	errMsg := lastErr.Error()
+	if len(errMsg) > maxRetryErrorHeaderLength {
+		errMsg = errMsg[:maxRetryErrorHeaderLength]
+	}
+
+	headers[retryHeaderOriginalTopic] = d.origin.Topic
+	headers[retryHeaderOriginalPart] = strconv.Itoa(int(d.origin.Partition))
+	headers[retryHeaderOriginalOffset] = strconv.FormatInt(d.origin.Offset, 10)
+	headers[retryHeaderOriginalTime] = d.origin.Timestamp.UTC().Format(time.RFC3339Nano)
+	headers[retryHeaderAttempts] = strconv.Itoa(d.attempts + 1)
+	headers[retryHeaderFirstFailedAt] = firstFailedAt.Format(time.RFC3339Nano)
+	headers[retryHeaderNotBefore] = failedAt.Add(delay).Format(time.RFC3339Nano)
+	headers[retryHeaderLastError] = errMsg
+	return headers
+}
+
+// deferHeaders returns the headers to republish a deferred delivery with.
+// Unlike forwardHeaders it records no failure: attempts and first_failed_at
+// stay as they were.
+func (d *delivery) deferHeaders(delay time.Duration, reason error, now time.Time) map[string]string {
+	headers := d.forwardHeaders(delay, reason, now)
+	headers[retryHeaderAttempts] = strconv.Itoa(d.attempts)
+	if d.firstFailedAt.IsZero() {
+		delete(headers, retryHeaderFirstFailedAt)
+	} else {
+		headers[retryHeaderFirstFailedAt] = d.firstFailedAt.Format(time.RFC3339Nano)
+	}
+	return headers
+}
+
+// dlqHeaders returns the headers to preserve in a DLQ envelope: what the
+// original producer sent, without pipeline bookkeeping.
+func (d *delivery) dlqHeaders() map[string]string {
+	headers := make(map[string]string, len(d.headers))
+	for k, v := range d.headers {
+		if k == SourceTopicHeader {
+			continue
+		}
+		headers[k] = v
+	}
+	return headers
+}
+
+// misrouted reports whether d was read from a tier partition other than the
+// partition number of its original record.
+func (d *delivery) misrouted() bool {
+	return d.tier >= 0 && d.msg.Partition != d.origin.Partition
+}
+
+// recordHeaders returns msg's headers as read, retry provenance included.
+func (d *delivery) recordHeaders() map[string]string {
+	headers := make(map[string]string, len(d.msg.Headers))
+	for _, hdr := range d.msg.Headers {
+		headers[string(hdr.Key)] = string(hdr.Value)
+	}
+	return headers
+}
+
+// ensureRetryTopics creates the missing retry topics and checks that they have
+// the partition count of the source topics, which the records they hold are
+// pinned to.
+func ensureRetryTopics(cfg *config.KafkaConfig, sources []string, defs []config.TopicDef, logger *zap.Logger) error {
+	admin, err := NewTopicAdmin(cfg, logger.Named("admin"))
+	if err != nil {
+		return err
+	}
+	defer admin.Close()
+
+	if err := admin.EnsureTopics(defs); err != nil {
+		return fmt.Errorf("ensuring retry topics: %w", err)
+	}
+
+	counts, err := admin.PartitionCounts()
+	if err != nil {
+		return err
+	}
+	topics := slices.Clone(sources)
+	for _, def := range defs {
+		topics = append(topics, def.Name)
+	}
+	return checkCoPartitioned(topics, counts)
+}
+
+// checkCoPartitioned returns an error unless every one of topics exists with
+// the same partition count.
+func checkCoPartitioned(topics []string, counts map[string]int32) error {
+	for _, topic := range topics {
+		n, ok := counts[topic]
+		if !ok {
+			return fmt.Errorf("topic %s does not exist", topic)
+		}
+		if first := counts[topics[0]]; n != first {
+			return fmt.Errorf("%s has %d partitions and %s %d: retried records go back to the partition they were read from, so retry topics need the partition count of the topics consumed",
+				topic, n, topics[0], first)
+		}
+	}
+	return nil
+}
+
+func retryTierIndex(cfg *config.ConsumerConfig) map[string]int {
+	tiers := make(map[string]int, len(cfg.RetryTiers))
+	for i, delay := range cfg.RetryTiers {
+		tiers[RetryTopicName(cfg.GroupID, delay)] = i
+	}
+	return tiers
+}
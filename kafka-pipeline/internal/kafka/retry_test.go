@@ -0,0 +1,177 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/config"
+	"go.uber.org/zap"
+)
+
+const (
+	retryTestGroup  = "fraud-detector-v1"
+	retryTestSource = "txn.raw.v1"
+)
+
+// testSession is the part of a consumer group session processMessage uses.
+type testSession struct{ ctx context.Context }
+
+func (s testSession) Claims() map[string][]int32                  { return nil }
+func (s testSession) MemberID() string                            { return "member-1" }
+func (s testSession) GenerationID() int32                         { return 1 }
+func (s testSession) MarkOffset(string, int32, int64, string)     {}
+func (s testSession) Commit()                                     {}
+func (s testSession) ResetOffset(string, int32, int64, string)    {}
+func (s testSession) MarkMessage(*sarama.ConsumerMessage, string) {}
+func (s testSession) Context() context.Context                    { return s.ctx }
+
+// newRetryTestHandler returns a groupHandler with retry tiers [1m, 10m] whose
+// records go to mock, where every topic has 12 partitions.
+func newRetryTestHandler(t *testing.T, handler MessageHandler) (*groupHandler, *mocks.SyncProducer) {
+	t.Helper()
+	cfg := &config.KafkaConfig{Consumer: config.ConsumerConfig{
+		GroupID:    retryTestGroup,
+		DLQTopic:   "txn.dlq.v1",
+		RetryTiers: []time.Duration{time.Minute, 10 * time.Minute},
+	}}
+	producer, mock := newMockProducer(t, cfg, "")
+	return &groupHandler{
+		handler:    handler,
+		retryTiers: retryTierIndex(&cfg.Consumer),
+		dlqProd:    producer,
+		cfg:        cfg,
+		logger:     zap.NewNop(),
+		fatal:      func(err error) { t.Fatalf("fatal: %v", err) },
+	}, mock
+}
+
+// expectRecord makes mock expect one record on topic/partition whose headers
+// include want.
+func expectRecord(mock *mocks.SyncProducer, topic string, partition int32, want map[string]string) {
+	mock.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+		if msg.Topic != topic || msg.Partition != partition {
+			return fmt.Errorf("produced to %s/%d, want %s/%d", msg.Topic, msg.Partition, topic, partition)
+		}
+		got := make(map[string]string, len(msg.Headers))
+		for _, h := range msg.Headers {
+			got[string(h.Key)] = string(h.Value)
+		}
+		for k, v := range want {
+			if got[k] != v {
+				return fmt.Errorf("header %s: got %q, want %q", k, got[k], v)
+			}
+		}
+		return nil
+	})
+}
+
+func TestRetryForwardKeepsSourcePartition(t *testing.T) {
+	h, mock := newRetryTestHandler(t, func(context.Context, []byte, []byte, map[string]string) error {
+		return errors.New("velocity store busy")
+	})
+	// Hashing "sender-1" over 12 partitions gives 2.
+	expectRecord(mock, RetryTopicName(retryTestGroup, time.Minute), 7, map[string]string{
+		retryHeaderOriginalPart: "7",
+		retryHeaderAttempts:     "1",
+	})
+
+	msg := &sarama.ConsumerMessage{Topic: retryTestSource, Partition: 7, Offset: 42, Key: []byte("sender-1"), Value: []byte(`{}`)}
+	if !h.processMessage(testSession{context.Background()}, msg) {
+		t.Fatal("processMessage abandoned the message")
+	}
+}
+
+func TestMisroutedRetryRecordMovesToItsPartition(t *testing.T) {
+	h, mock := newRetryTestHandler(t, func(context.Context, []byte, []byte, map[string]string) error {
+		t.Error("handler ran on an instance that does not own the record's state")
+		return nil
+	})
+	retryTopic := RetryTopicName(retryTestGroup, time.Minute)
+	notBefore := time.Now().Add(time.Hour).UTC().Format(time.RFC3339Nano)
+	// Moved as is: not held until due, and not counted as an attempt.
+	expectRecord(mock, retryTopic, 7, map[string]string{
+		retryHeaderOriginalTopic: retryTestSource,
+		retryHeaderOriginalPart:  "7",
+		retryHeaderAttempts:      "1",
+		retryHeaderNotBefore:     notBefore,
+	})
+
+	// Forwarded by key hash before tiers were pinned: partition 2, but its
+	// source record — and the state it updates — is on partition 7.
+	msg := &sarama.ConsumerMessage{
+		Topic:     retryTopic,
+		Partition: 2,
+		Offset:    5,
+		Key:       []byte("sender-1"),
+		Value:     []byte(`{}`),
+		Headers: []*sarama.RecordHeader{
+			{Key: []byte(retryHeaderOriginalTopic), Value: []byte(retryTestSource)},
+			{Key: []byte(retryHeaderOriginalPart), Value: []byte("7")},
+			{Key: []byte(retryHeaderAttempts), Value: []byte("1")},
+			{Key: []byte(retryHeaderNotBefore), Value: []byte(notBefore)},
+		},
+	}
+	if !h.processMessage(testSession{context.Background()}, msg) {
+		t.Fatal("processMessage abandoned the message")
+	}
+}
+
+func TestCoPartitionedAssignment(t *testing.T) {
+	consumerCfg := &config.ConsumerConfig{
+		GroupID:       retryTestGroup,
+		RetryTiers:    []time.Duration{time.Minute, 10 * time.Minute},
+		CoPartitioned: true,
+	}
+	subscribed := []string{retryTestSource}
+	for _, def := range RetryTopicDefs(consumerCfg) {
+		subscribed = append(subscribed, def.Name)
+	}
+	partitions := make([]int32, 12)
+	for i := range partitions {
+		partitions[i] = int32(i)
+	}
+	topics := make(map[string][]int32, len(subscribed))
+	for _, topic := range subscribed {
+		topics[topic] = partitions
+	}
+	members := make(map[string]sarama.ConsumerGroupMemberMetadata)
+	for _, id := range []string{"member-c", "member-a", "member-b"} {
+		members[id] = sarama.ConsumerGroupMemberMetadata{Topics: subscribed}
+	}
+
+	plan, err := balanceStrategies(consumerCfg)[0].Plan(members, topics)
+	if err != nil {
+		t.Fatalf("Plan: %v", err)
+	}
+	for member, assigned := range plan {
+		source := slices.Sorted(slices.Values(assigned[retryTestSource]))
+		if len(source) == 0 {
+			t.Errorf("%s: no source partitions", member)
+		}
+		for _, topic := range subscribed[1:] {
+			if got := slices.Sorted(slices.Values(assigned[topic])); !slices.Equal(got, source) {
+				t.Errorf("%s: %s partitions %v, source partitions %v", member, topic, got, source)
+			}
+		}
+	}
+}
+
+func TestCheckCoPartitioned(t *testing.T) {
+	retry := RetryTopicName(retryTestGroup, time.Minute)
+	topics := []string{retryTestSource, retry}
+	if err := checkCoPartitioned(topics, map[string]int32{retryTestSource: 12, retry: 12}); err != nil {
+		t.Fatalf("equal partition counts: %v", err)
+	}
+	if err := checkCoPartitioned(topics, map[string]int32{retryTestSource: 12, retry: 6}); err == nil {
+		t.Fatal("retry topic with fewer partitions passed")
+	}
+	if err := checkCoPartitioned(topics, map[string]int32{retryTestSource: 12}); err == nil {
+		t.Fatal("missing retry topic passed")
+	}
+}
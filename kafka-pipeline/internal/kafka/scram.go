@@ -5,6 +5,8 @@ import (
 	"crypto/sha512"
 	"hash"
 
+	"github.com/IBM/sarama"
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/config"
 	"github.com/xdg-go/scram"
 )
 
@@ -40,3 +42,20 @@ func (x *XDGSCRAMClient) Step(challenge string) (string, error) {
 func (x *XDGSCRAMClient) Done() bool {
 	return x.ClientConversation.Done()
 }
+
+// configureSecurity applies the SASL_SSL settings shared by every client we build.
+func configureSecurity(saramaCfg *sarama.Config, cfg *config.KafkaConfig) {
+	if cfg.SecurityProtocol != "SASL_SSL" {
+		return
+	}
+	saramaCfg.Net.TLS.Enable = true
+	saramaCfg.Net.SASL.Enable = true
+	saramaCfg.Net.SASL.Mechanism = sarama.SASLMechanism(cfg.SASLMechanism)
+	saramaCfg.Net.SASL.User = cfg.SASLUsername
+	saramaCfg.Net.SASL.Password = cfg.SASLPassword
+	if cfg.SASLMechanism == "SCRAM-SHA-512" {
+		saramaCfg.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+			return &XDGSCRAMClient{HashGeneratorFcn: SHA512}
+		}
+	}
+}
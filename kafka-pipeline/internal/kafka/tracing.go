@@ -0,0 +1,125 @@
+package kafka
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/IBM/sarama"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// -------------------------------------------------------------------------------
+// DISTRIBUTED TRACING (W3C trace context).
+//
+// Every produce starts a PRODUCER span and writes its context into the record
+// headers as traceparent/tracestate. Every consumed record's headers are
+// extracted and its processing runs in a CONSUMER span that is a child of the
+// producing span — and the handler's ctx carries that span, so whatever the
+// handler produces continues the same trace. One transaction is thus a single
+// trace: ingester → fraud-detector → enricher → notifier.
+//
+// The tracer and propagator are the global ones installed by the runner. With
+// tracing disabled the spans are no-ops, but the propagator still copies the
+// incoming trace context onward, so a traced upstream is not cut off.
+// -------------------------------------------------------------------------------
+
+const tracerName = "github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/kafka"
+
+// headerCarrier adapts record headers to the OpenTelemetry propagation API.
+type headerCarrier map[string]string
+
+func (c headerCarrier) Get(key string) string { return c[key] }
+func (c headerCarrier) Set(key, value string) { c[key] = value }
+func (c headerCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// injectTraceContext returns a copy of headers with the trace context of ctx
+// added. The caller's map is never modified — handlers often pass the headers
+// they were given.
+func injectTraceContext(ctx context.Context, headers map[string]string) map[string]string {
+	out := make(headerCarrier, len(headers)+2)
+	for k, v := range headers {
+		out[k] = v
+	}
+	otel.GetTextMapPropagator().Inject(ctx, out)
+	return out
+}
+
+// extractTraceContext returns ctx carrying the remote span context in headers.
+func extractTraceContext(ctx context.Context, headers map[string]string) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, headerCarrier(headers))
+}
+
+var _ propagation.TextMapCarrier = headerCarrier(nil)
+
+func startProduceSpan(ctx context.Context, topic, key string) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, "publish "+topic,
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKafka,
+			semconv.MessagingOperationTypeSend,
+			semconv.MessagingDestinationName(topic),
+			semconv.MessagingKafkaMessageKey(key),
+		),
+	)
+}
+
+func endProduceSpan(span trace.Span, msg *sarama.ProducerMessage, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	} else {
+		span.SetAttributes(
+			semconv.MessagingDestinationPartitionID(strconv.Itoa(int(msg.Partition))),
+			semconv.MessagingKafkaOffset(int(msg.Offset)),
+		)
+	}
+	span.End()
+}
+
+// startConsumeSpan starts the span for processing d, parented to the span that
+// produced the record. Retried records carry the headers of the original, so
+// every attempt shows up in the original trace.
+func startConsumeSpan(ctx context.Context, groupID string, d *delivery) (context.Context, trace.Span) {
+	ctx = extractTraceContext(ctx, d.headers)
+	return otel.Tracer(tracerName).Start(ctx, "process "+d.origin.Topic,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKafka,
+			semconv.MessagingOperationTypeProcess,
+			semconv.MessagingDestinationName(d.origin.Topic),
+			semconv.MessagingDestinationPartitionID(strconv.Itoa(int(d.origin.Partition))),
+			semconv.MessagingConsumerGroupName(groupID),
+			semconv.MessagingKafkaMessageKey(string(d.msg.Key)),
+			semconv.MessagingKafkaOffset(int(d.origin.Offset)),
+			attribute.Int("messaging.kafka.retry.attempts", d.attempts),
+		),
+	)
+}
+
+// endConsumeSpan records how processing ended. status is the consumed-messages
+// metric label; it is empty when processing did not finish (a rebalance, or
+// a failed retry/DLQ write) and the record will be redelivered.
+func endConsumeSpan(span trace.Span, status string, err error) {
+	if status == "" {
+		status = "interrupted"
+	}
+	span.SetAttributes(attribute.String("messaging.kafka.outcome", status))
+	if err != nil {
+		span.RecordError(err)
+		if status != "success" {
+			span.SetStatus(codes.Error, err.Error())
+		}
+	}
+	span.End()
+}
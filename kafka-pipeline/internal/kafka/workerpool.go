@@ -0,0 +1,148 @@
+package kafka
+
+import (
+	"hash/fnv"
+	"sync"
+
+	"github.com/IBM/sarama"
+)
+
+// -------------------------------------------------------------------------------
+// keyedWorkerPool fans the messages of ONE partition out to N goroutines.
+//
+// ORDERING GUARANTEE:
+// Every message is routed to a worker by hash(key) % N, and each worker drains
+// its queue in FIFO order. Two messages with the same key therefore always land
+// on the same worker and are processed in offset order. Messages with different
+// keys may complete out of order — that is the whole point.
+//
+// WHY NOT A PLAIN WORKER POOL:
+// A shared queue with N readers gives the same throughput but breaks per-key
+// ordering: a REFUND could be processed before the PAYMENT it refunds.
+// Since every topic in this pipeline is keyed by sender_id, per-key ordering
+// is exactly the ordering we care about.
+// -------------------------------------------------------------------------------
+type keyedWorkerPool struct {
+	queues []chan *sarama.ConsumerMessage
+	wg     sync.WaitGroup
+}
+
+// workerQueueSize bounds how many messages can be buffered per worker before
+// dispatch blocks. Blocking dispatch is our backpressure: sarama stops pulling
+// from the claim channel and, eventually, stops fetching from the broker.
+const workerQueueSize = 64
+
+func newKeyedWorkerPool(workers int, process func(*sarama.ConsumerMessage)) *keyedWorkerPool {
+	if workers < 1 {
+		workers = 1
+	}
+
+	p := &keyedWorkerPool{
+		queues: make([]chan *sarama.ConsumerMessage, workers),
+	}
+	for i := range p.queues {
+		queue := make(chan *sarama.ConsumerMessage, workerQueueSize)
+		p.queues[i] = queue
+
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for msg := range queue {
+				process(msg)
+			}
+		}()
+	}
+	return p
+}
+
+// Dispatch hands msg to the worker that owns its key. Blocks if that worker's
+// queue is full.
+func (p *keyedWorkerPool) Dispatch(msg *sarama.ConsumerMessage) {
+	p.queues[p.slot(msg)] <- msg
+}
+
+// Close stops accepting messages and waits for every queued message to finish.
+func (p *keyedWorkerPool) Close() {
+	for _, queue := range p.queues {
+		close(queue)
+	}
+	p.wg.Wait()
+}
+
+func (p *keyedWorkerPool) slot(msg *sarama.ConsumerMessage) int {
+	n := len(p.queues)
+	if n == 1 {
+		return 0
+	}
+	// Unkeyed messages carry no ordering contract, so spread them by offset
+	// instead of piling every one of them onto the same worker.
+	if len(msg.Key) == 0 {
+		return int(msg.Offset % int64(n))
+	}
+	h := fnv.New32a()
+	h.Write(msg.Key)
+	return int(h.Sum32() % uint32(n))
+}
+
+// -------------------------------------------------------------------------------
+// offsetTracker decides which offset is safe to mark once processing is
+// concurrent.
+//
+// THE PROBLEM:
+// With offsets 10, 11, 12 in flight, offset 12 may finish first. Marking 12
+// would commit 13 — and if we crash before 10 and 11 finish, they are never
+// redelivered. That is silent data loss.
+//
+// THE SOLUTION:
+// Track offsets in dispatch order and only advance the watermark across a
+// contiguous prefix of completed messages. Completing 12 marks nothing;
+// completing 10 marks 10; completing 11 then marks 12 in one step.
+// Offsets are tracked as dispatched (not as offset+1) because compaction and
+// transaction markers leave gaps in partition offsets.
+// -------------------------------------------------------------------------------
+type offsetTracker struct {
+	mu      sync.Mutex
+	pending []*trackedMessage
+	byOff   map[int64]*trackedMessage
+}
+
+type trackedMessage struct {
+	msg  *sarama.ConsumerMessage
+	done bool
+}
+
+func newOffsetTracker() *offsetTracker {
+	return &offsetTracker{byOff: make(map[int64]*trackedMessage)}
+}
+
+// Track registers msg as in flight. Must be called in partition order,
+// before the message is dispatched.
+func (t *offsetTracker) Track(msg *sarama.ConsumerMessage) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tm := &trackedMessage{msg: msg}
+	t.pending = append(t.pending, tm)
+	t.byOff[msg.Offset] = tm
+}
+
+// Complete records msg as finished and returns the highest message that is now
+// safe to mark, or nil if an earlier offset is still in flight.
+func (t *offsetTracker) Complete(msg *sarama.ConsumerMessage) *sarama.ConsumerMessage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	tm, ok := t.byOff[msg.Offset]
+	if !ok {
+		return nil
+	}
+	tm.done = true
+
+	var committable *sarama.ConsumerMessage
+	for len(t.pending) > 0 && t.pending[0].done {
+		committable = t.pending[0].msg
+		delete(t.byOff, committable.Offset)
+		t.pending[0] = nil
+		t.pending = t.pending[1:]
+	}
+	return committable
+}
@@ -21,6 +21,13 @@ var (
 		Buckets:   []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1.0},
 	}, []string{"topic"})
 
+	ProducerBuffered = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "kafka_pipeline",
+		Subsystem: "producer",
+		Name:      "buffered_messages",
+		Help:      "Messages handed to the async producer and not yet acknowledged.",
+	})
+
 	MessagesConsumed = promauto.NewCounterVec(prometheus.CounterOpts{
 		Namespace: "kafka_pipeline",
 		Subsystem: "consumer",
@@ -36,6 +43,24 @@ var (
 		Buckets:   []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.25, 0.5, 1.0, 2.5, 5.0, 10.0},
 	}, []string{"topic", "group"})
 
+	// Both latencies are wall-clock differences between hosts: they are only
+	// as accurate as NTP, and clamped at zero.
+	EndToEndLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "kafka_pipeline",
+		Subsystem: "consumer",
+		Name:      "end_to_end_latency_seconds",
+		Help:      "Time from a record being produced (produced_at header) to its consumer picking it up.",
+		Buckets:   []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1.0, 2.5, 5.0, 10.0, 30.0, 60.0},
+	}, []string{"topic", "group"})
+
+	PipelineLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "kafka_pipeline",
+		Subsystem: "pipeline",
+		Name:      "latency_seconds",
+		Help:      "Time from ingestion (ingested_at header) to a record being processed successfully. On the notifier's group: ingested to notification sent.",
+		Buckets:   []float64{0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1.0, 2.5, 5.0, 10.0, 30.0, 60.0, 300.0, 900.0},
+	}, []string{"topic", "group"})
+
 	ConsumerLag = promauto.NewGaugeVec(prometheus.GaugeOpts{
 		Namespace: "kafka_pipeline",
 		Subsystem: "consumer",
@@ -43,6 +68,13 @@ var (
 		Help:      "Consumer lag (high-water mark - committed offset) per topic-partition.",
 	}, []string{"topic", "group", "partition"})
 
+	ConsumerCommitAge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "kafka_pipeline",
+		Subsystem: "consumer",
+		Name:      "commit_age_seconds",
+		Help:      "Time since the committed offset of a topic-partition last moved.",
+	}, []string{"topic", "group", "partition"})
+
 	ConsumerRebalances = promauto.NewCounterVec(prometheus.CounterOpts{
 		Namespace: "kafka_pipeline",
 		Subsystem: "consumer",
@@ -57,6 +89,126 @@ var (
 		Help:      "Messages sent to dead letter queue.",
 	}, []string{"source_topic", "error_type"})
 
+	JoinEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kafka_pipeline",
+		Subsystem: "join",
+		Name:      "events_total",
+		Help:      "Stream join events by arriving side and outcome (matched, buffered, expired).",
+	}, []string{"side", "outcome"})
+
+	NotificationsSent = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kafka_pipeline",
+		Subsystem: "notifier",
+		Name:      "notifications_total",
+		Help:      "Notification delivery attempts by channel and outcome (success or error class).",
+	}, []string{"channel", "status"})
+
+	NotificationsRerouted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kafka_pipeline",
+		Subsystem: "notifier",
+		Name:      "notifications_rerouted_total",
+		Help:      "Notifications moved to another channel by user preferences, by requested and chosen channel.",
+	}, []string{"from", "to"})
+
+	NotificationsSuppressed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kafka_pipeline",
+		Subsystem: "notifier",
+		Name:      "notifications_suppressed_total",
+		Help:      "Notifications not sent because user preferences ruled out every channel, by reason (opted_out, quiet_hours).",
+	}, []string{"reason"})
+
+	RulesReloads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kafka_pipeline",
+		Subsystem: "rules",
+		Name:      "reloads_total",
+		Help:      "Rules file reloads by rule set and outcome (success, error). On error the previous rules stay in force.",
+	}, []string{"ruleset", "status"})
+
+	RulesVersion = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "kafka_pipeline",
+		Subsystem: "rules",
+		Name:      "version_info",
+		Help:      "The version in force of each rule set, as a label.",
+	}, []string{"ruleset", "version"})
+
+	ShadowDecisions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kafka_pipeline",
+		Subsystem: "fraud_shadow",
+		Name:      "decisions_total",
+		Help:      "Shadow scorer verdicts by the primary's decision and the shadow's. Off-diagonal pairs are disagreements.",
+	}, []string{"shadow", "primary_decision", "shadow_decision"})
+
+	ShadowScoreDelta = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "kafka_pipeline",
+		Subsystem: "fraud_shadow",
+		Name:      "score_delta",
+		Help:      "Shadow risk score minus primary risk score.",
+		Buckets:   prometheus.LinearBuckets(-0.9, 0.1, 19),
+	}, []string{"shadow"})
+
+	ShadowErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kafka_pipeline",
+		Subsystem: "fraud_shadow",
+		Name:      "errors_total",
+		Help:      "Shadow scorings that failed or panicked. They never affect the primary result.",
+	}, []string{"shadow"})
+
+	EnrichmentLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kafka_pipeline",
+		Subsystem: "enrichment",
+		Name:      "lookups_total",
+		Help:      "Enrichment lookups by provider and result (cache_hit, found, not_found, no_key, error, circuit_open). All but cache_hit and found leave the field UNKNOWN.",
+	}, []string{"provider", "result"})
+
+	DuplicatesSuppressed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kafka_pipeline",
+		Subsystem: "dedup",
+		Name:      "duplicates_total",
+		Help:      "Deliveries not handled because their idempotency key was already completed or in progress, by state (completed, in_progress).",
+	}, []string{"state"})
+
+	ConcurrencyLimit = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "kafka_pipeline",
+		Subsystem: "adaptive_limit",
+		Name:      "limit",
+		Help:      "Current adaptive concurrency limit of a handler.",
+	}, []string{"name"})
+
+	ConcurrencyInFlight = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "kafka_pipeline",
+		Subsystem: "adaptive_limit",
+		Name:      "in_flight",
+		Help:      "Messages being processed under the adaptive concurrency limit.",
+	}, []string{"name"})
+
+	ConcurrencyThrottled = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kafka_pipeline",
+		Subsystem: "adaptive_limit",
+		Name:      "throttled_total",
+		Help:      "Messages that had to wait for a slot under the adaptive concurrency limit.",
+	}, []string{"name"})
+
+	FetchPaused = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "kafka_pipeline",
+		Subsystem: "adaptive_limit",
+		Name:      "fetch_paused",
+		Help:      "1 while the adaptive concurrency limit has paused fetching, else 0.",
+	}, []string{"name"})
+
+	RateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kafka_pipeline",
+		Subsystem: "rate_limit",
+		Name:      "exceeded_total",
+		Help:      "Messages whose key was over its quota, by action taken (delay, retry).",
+	}, []string{"name", "action"})
+
+	RateLimitKeys = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "kafka_pipeline",
+		Subsystem: "rate_limit",
+		Name:      "keys",
+		Help:      "Keys with a token bucket in memory.",
+	}, []string{"name"})
+
 	CircuitBreakerState = promauto.NewGaugeVec(prometheus.GaugeOpts{
 		Namespace: "kafka_pipeline",
 		Subsystem: "circuit_breaker",
@@ -64,6 +216,34 @@ var (
 		Help:      "Circuit breaker state: 0=closed, 1=half-open, 2=open.",
 	}, []string{"name"})
 
+	CircuitBreakerTransitions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kafka_pipeline",
+		Subsystem: "circuit_breaker",
+		Name:      "transitions_total",
+		Help:      "Circuit breaker state changes by previous and new state.",
+	}, []string{"name", "from", "to"})
+
+	CircuitBreakerCalls = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kafka_pipeline",
+		Subsystem: "circuit_breaker",
+		Name:      "calls_total",
+		Help:      "Calls through a circuit breaker by outcome (success, failure, rejected). Errors the breaker's predicate ignores are successes.",
+	}, []string{"name", "outcome"})
+
+	CircuitBreakerSlowCalls = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kafka_pipeline",
+		Subsystem: "circuit_breaker",
+		Name:      "slow_calls_total",
+		Help:      "Calls that took at least the breaker's slow-call duration, successful or not.",
+	}, []string{"name"})
+
+	TopicDrift = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "kafka_pipeline",
+		Subsystem: "topic",
+		Name:      "drift",
+		Help:      "Topic settings that differ from their declaration: 1=safe to apply, 2=needs an operator. Absent when in sync.",
+	}, []string{"topic", "setting"})
+
 	BuildInfo = promauto.NewGaugeVec(prometheus.GaugeOpts{
 		Namespace: "kafka_pipeline",
 		Name:      "build_info",
@@ -0,0 +1,281 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/config"
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/kafka"
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/metrics"
+	"go.uber.org/zap"
+)
+
+// Pauser stops and restarts fetching. *kafka.ConsumerGroup implements it.
+type Pauser interface {
+	PauseAll()
+	ResumeAll()
+}
+
+const (
+	// sampleWindow / minWindowSamples: the limit is updated once per window
+	// of at least this long and this many successful calls, from their
+	// average latency. Updating on every call would overreact to calls that
+	// were admitted before the previous update took effect.
+	sampleWindow     = 500 * time.Millisecond
+	minWindowSamples = 10
+	// probeInterval: every this many windows (5 minutes or more) the no-load
+	// latency is measured again — see AdaptiveLimiter.
+	probeInterval = 600
+)
+
+// -------------------------------------------------------------------------------
+// AdaptiveLimiter caps how many messages a handler processes at once, and
+// finds the cap itself.
+//
+// THE PROBLEM:
+// A fixed worker count is either too low for a healthy downstream or too high
+// for a struggling one. When the downstream slows, every worker piles onto it,
+// calls start timing out, everything retries, and the extra load keeps it
+// slow.
+//
+// THE ALGORITHM (gradient, with AIMD backoff):
+//   - Once per sample window the limit moves toward
+//     limit × gradient + √limit, where gradient = tolerance × noLoadRTT /
+//     the window's average latency, clamped to [0.5, 1]. While latency is
+//     near what it is without load the gradient is 1 and the √limit headroom
+//     grows the limit; once it exceeds tolerance × that, the gradient drops
+//     below 1 and the limit shrinks. The limit settles where the downstream
+//     queues just enough.
+//   - noLoadRTT is the fastest window seen. Every probeInterval windows it is
+//     forgotten and the limit drops to √limit for a moment, so it is measured
+//     again without load — a downstream that got slower for good does not
+//     pin the limit at the minimum forever.
+//   - A retryable failure (a timeout, an unavailable downstream) multiplies
+//     the limit by backoff_ratio — the multiplicative decrease of AIMD.
+//   - The limit only grows while it is actually used (at least half of it in
+//     flight at some point in the window), so a quiet period does not leave
+//     it far above what was tested.
+//
+// Poison messages and other failures that say nothing about the downstream's
+// health are not samples.
+//
+// SATURATION:
+// A message arriving at the limit waits for a slot, and while anything waits
+// the consumer's fetching is paused: records stop piling up in memory and the
+// consumer stays healthy in its group. Fetching resumes once nothing waits and
+// a slot is free.
+// -------------------------------------------------------------------------------
+type AdaptiveLimiter struct {
+	name   string
+	cfg    config.AdaptiveLimitConfig
+	logger *zap.Logger
+
+	mu       sync.Mutex
+	limit    float64
+	inFlight int
+	waiting  int
+	freed    chan struct{} // closed, and replaced, whenever a slot frees up
+	window   sampleWindowStats
+	noLoad   float64 // seconds; 0 until measured
+	windows  int     // since the last probe
+	pauser   Pauser
+	paused   bool
+}
+
+func NewAdaptiveLimiter(name string, cfg config.AdaptiveLimitConfig, logger *zap.Logger) *AdaptiveLimiter {
+	l := &AdaptiveLimiter{
+		name:   name,
+		cfg:    cfg,
+		logger: logger,
+		limit:  float64(cfg.InitialLimit),
+		freed:  make(chan struct{}),
+	}
+	metrics.ConcurrencyLimit.WithLabelValues(name).Set(l.limit)
+	return l
+}
+
+// PauseFetches makes the limiter pause p while messages wait for a slot. The
+// consumer group is created after its handler, so this is set afterwards.
+func (l *AdaptiveLimiter) PauseFetches(p Pauser) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.pauser = p
+}
+
+// Limit returns the current concurrency limit.
+func (l *AdaptiveLimiter) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.limit)
+}
+
+// acquire waits for a slot.
+func (l *AdaptiveLimiter) acquire(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.inFlight >= int(l.limit) {
+		metrics.ConcurrencyThrottled.WithLabelValues(l.name).Inc()
+		l.waiting++
+		l.setPaused(true)
+		for l.inFlight >= int(l.limit) {
+			freed := l.freed
+			l.mu.Unlock()
+			select {
+			case <-freed:
+				l.mu.Lock()
+			case <-ctx.Done():
+				l.mu.Lock()
+				l.waiting--
+				l.maybeResume()
+				return ctx.Err()
+			}
+		}
+		l.waiting--
+	}
+
+	l.inFlight++
+	metrics.ConcurrencyInFlight.WithLabelValues(l.name).Set(float64(l.inFlight))
+	l.maybeResume()
+	return nil
+}
+
+// release frees a slot and feeds the call's outcome into the limit.
+func (l *AdaptiveLimiter) release(rtt time.Duration, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	used := l.inFlight
+	l.inFlight--
+	metrics.ConcurrencyInFlight.WithLabelValues(l.name).Set(float64(l.inFlight))
+
+	switch {
+	case err == nil:
+		l.sample(rtt.Seconds(), used)
+	case errors.Is(err, context.Canceled):
+		// Rebalance or shutdown, not the downstream.
+	default:
+		switch kafka.ClassOf(err) {
+		case kafka.ClassTransient, kafka.ClassDownstreamUnavailable:
+			l.setLimit(l.limit * l.cfg.BackoffRatio)
+		}
+	}
+
+	close(l.freed)
+	l.freed = make(chan struct{})
+	l.maybeResume()
+}
+
+// sampleWindowStats accumulates the successful calls of the current window.
+type sampleWindowStats struct {
+	start       time.Time
+	rttSum      float64
+	count       int
+	maxInFlight int
+}
+
+// sample records one successful call's latency. used is how many calls were
+// in flight when it finished. The caller must hold l.mu.
+func (l *AdaptiveLimiter) sample(rtt float64, used int) {
+	w := &l.window
+	now := time.Now()
+	if w.count == 0 {
+		w.start = now
+	}
+	w.rttSum += rtt
+	w.count++
+	w.maxInFlight = max(w.maxInFlight, used)
+	if now.Sub(w.start) < sampleWindow || w.count < minWindowSamples {
+		return
+	}
+	avg, peak := w.rttSum/float64(w.count), w.maxInFlight
+	*w = sampleWindowStats{}
+
+	if l.windows++; l.windows >= probeInterval {
+		l.windows = 0
+		l.noLoad = 0
+		l.setLimit(math.Sqrt(l.limit))
+		return
+	}
+	if l.noLoad == 0 || avg < l.noLoad {
+		l.noLoad = avg
+	}
+
+	gradient := max(0.5, min(1, l.cfg.Tolerance*l.noLoad/avg))
+	target := l.limit*gradient + math.Sqrt(l.limit)
+	if float64(peak) < l.limit/2 {
+		// Application-limited: nothing says the downstream could take more.
+		target = min(target, l.limit)
+	}
+	l.setLimit(l.limit*(1-l.cfg.Smoothing) + target*l.cfg.Smoothing)
+}
+
+// setLimit clamps and stores a new limit. The caller must hold l.mu.
+func (l *AdaptiveLimiter) setLimit(limit float64) {
+	limit = max(float64(l.cfg.MinLimit), min(float64(l.cfg.MaxLimit), limit))
+	if int(limit) != int(l.limit) {
+		l.logger.Debug("concurrency limit changed",
+			zap.String("limiter", l.name),
+			zap.Int("from", int(l.limit)),
+			zap.Int("to", int(limit)),
+		)
+	}
+	l.limit = limit
+	metrics.ConcurrencyLimit.WithLabelValues(l.name).Set(limit)
+}
+
+// maybeResume resumes fetching once nothing waits and a slot is free. The
+// caller must hold l.mu.
+func (l *AdaptiveLimiter) maybeResume() {
+	if l.waiting == 0 && l.inFlight < int(l.limit) {
+		l.setPaused(false)
+	}
+}
+
+// setPaused pauses or resumes fetching. Pausing repeats even when already
+// paused: partitions assigned since the last pause start out fetching. The
+// caller must hold l.mu.
+func (l *AdaptiveLimiter) setPaused(paused bool) {
+	if l.pauser == nil || (!paused && !l.paused) {
+		return
+	}
+	l.paused = paused
+	if paused {
+		l.pauser.PauseAll()
+		metrics.FetchPaused.WithLabelValues(l.name).Set(1)
+	} else {
+		l.pauser.ResumeAll()
+		metrics.FetchPaused.WithLabelValues(l.name).Set(0)
+	}
+}
+
+// AdaptiveLimit runs the handler under limiter's concurrency limit. It is a
+// no-op unless limiter's config is enabled. Put it before Timeout in the
+// chain, so waiting for a slot does not eat into the handler's deadline.
+func AdaptiveLimit(limiter *AdaptiveLimiter) Middleware {
+	return func(next kafka.MessageHandler) kafka.MessageHandler {
+		if !limiter.cfg.Enabled {
+			return next
+		}
+		return func(ctx context.Context, key, value []byte, headers map[string]string) error {
+			if err := limiter.acquire(ctx); err != nil {
+				return err
+			}
+			start := time.Now()
+			released := false
+			defer func() {
+				// A panic unwinding to Recovery must not leak the slot.
+				if !released {
+					limiter.release(time.Since(start), &PanicError{})
+				}
+			}()
+			err := next(ctx, key, value, headers)
+			released = true
+			limiter.release(time.Since(start), err)
+			return err
+		}
+	}
+}
@@ -2,10 +2,13 @@ package middleware
 
 import (
 	"context"
-	"sync"
+	"errors"
+	"fmt"
 	"time"
 
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/dedup"
 	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/kafka"
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/metrics"
 	"go.uber.org/zap"
 )
 
@@ -82,24 +85,73 @@ func Timeout(d time.Duration) Middleware {
 	}
 }
 
-func Dedupilcation(logger *zap.Logger, ttl time.Duration) Middleware {
-	seen := newTTLCache(ttl)
+// ErrInProgress is returned for a delivery whose idempotency key another
+// delivery is still processing. It is transient: by the retry, the other one
+// has completed (the retry is then skipped as a duplicate) or failed.
+var ErrInProgress = errors.New("message with the same idempotency key is being processed")
+
+// Dedupilcation runs the handler at most once per idempotency key — the
+// "idempotency_key" header, or the record key without one — as long as store
+// remembers it. A failed handler releases the key, so retries still run. In a
+// transaction the key is completed when it commits, and released if it aborts.
+//
+// Without the store we can't tell a duplicate from a first delivery, so a
+// store error fails the message (retryably) rather than risking a double
+// charge.
+func Dedupilcation(logger *zap.Logger, store dedup.Store) Middleware {
 	return func(next kafka.MessageHandler) kafka.MessageHandler {
 		return func(ctx context.Context, key, value []byte, headers map[string]string) error {
 			idempotencyKey := headers["idempotency_key"]
 			if idempotencyKey == "" {
 				idempotencyKey = string(key)
 			}
-			if seen.Has(idempotencyKey) {
-				logger.Debug("depulicate message skipped", zap.String("key", idempotencyKey))
+
+			status, err := store.Claim(ctx, idempotencyKey)
+			if err != nil {
+				return kafka.DownstreamUnavailable(fmt.Errorf("checking idempotency key: %w", err))
+			}
+			switch status {
+			case dedup.Completed:
+				metrics.DuplicatesSuppressed.WithLabelValues(status.String()).Inc()
+				logger.Debug("duplicate message skipped", zap.String("key", idempotencyKey))
 				return nil
+			case dedup.InProgress:
+				metrics.DuplicatesSuppressed.WithLabelValues(status.String()).Inc()
+				return kafka.Transient(fmt.Errorf("%w: %s", ErrInProgress, idempotencyKey))
 			}
 
-			err := next(ctx, key, value, headers)
-			if err == nil {
-				seen.Add(idempotencyKey)
+			// The handler's ctx may be about to expire; recording its outcome
+			// must not be cut short by that.
+			storeCtx := context.WithoutCancel(ctx)
+			if err := next(ctx, key, value, headers); err != nil {
+				if relErr := store.Release(storeCtx, idempotencyKey); relErr != nil {
+					// The claim lapses after its lease; retries wait until then.
+					logger.Warn("failed to release idempotency key", zap.String("key", idempotencyKey), zap.Error(relErr))
+				}
+				return err
 			}
-			return err
+			// Under exactly-once the work is done only once its transaction
+			// commits. Completing the key earlier would have the redelivery
+			// of an aborted transaction skipped as a duplicate.
+			completed := false
+			_ = kafka.AfterCommit(ctx, func() error {
+				completed = true
+				if err := store.Complete(storeCtx, idempotencyKey); err != nil {
+					// Failing now would redo work that is done. Once the claim
+					// lapses, a redelivery would run again.
+					logger.Warn("failed to mark idempotency key completed", zap.String("key", idempotencyKey), zap.Error(err))
+				}
+				return nil
+			})
+			kafka.AtTxnEnd(ctx, func() {
+				if completed {
+					return
+				}
+				if relErr := store.Release(storeCtx, idempotencyKey); relErr != nil {
+					logger.Warn("failed to release idempotency key", zap.String("key", idempotencyKey), zap.Error(relErr))
+				}
+			})
+			return nil
 		}
 	}
 }
@@ -113,47 +165,8 @@ func (e *PanicError) Error() string {
 	return "panic in message handler"
 }
 
-// -----------------------------------------------------------------------
-// Simple TTL cache for deduplication. In production, use Redis.
-// -----------------------------------------------------------------------
-type ttlCache struct {
-	mu    sync.RWMutex
-	items map[string]time.Time
-	ttl   time.Duration
-}
-
-func newTTLCache(ttl time.Duration) *ttlCache {
-	c := &ttlCache{
-		items: make(map[string]time.Time),
-		ttl:   ttl,
-	}
-	go c.cleanup()
-	return c
-}
-
-func (c *ttlCache) Has(key string) bool {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-	exp, ok := c.items[key]
-	return ok && time.Now().Before(exp)
-}
-
-func (c *ttlCache) Add(key string) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	c.items[key] = time.Now().Add(c.ttl)
-}
-
-func (c *ttlCache) cleanup() {
-	ticker := time.NewTicker(1 * time.Minute)
-	for range ticker.C {
-		c.mu.Lock()
-		now := time.Now()
-		for k, exp := range c.items {
-			if now.After(exp) {
-				delete(c.items, k)
-			}
-		}
-		c.mu.Unlock()
-	}
+// ErrorClass marks panics as poison: a handler that panics on a message will
+// panic on it again, so retrying only delays the rest of the partition.
+func (e *PanicError) ErrorClass() kafka.ErrorClass {
+	return kafka.ClassPoison
 }
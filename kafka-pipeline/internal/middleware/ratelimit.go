@@ -0,0 +1,124 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/config"
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/kafka"
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/metrics"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+// ErrRateLimited is the reason recorded on a message deferred because its key
+// was over quota.
+var ErrRateLimited = errors.New("rate limit exceeded")
+
+// -------------------------------------------------------------------------------
+// RateLimit gives every key — the record key or cfg.KeyHeader — its own token
+// bucket, so one hot sender (or tenant) cannot take the whole consumer's
+// throughput and flood the downstream APIs behind the handler. A message over
+// its key's quota is either delayed until the next token, or deferred to the
+// retry topics (kafka.Defer) so the partition keeps moving for everyone else.
+//
+// Buckets live in this process only. Keys map to partitions, and a partition
+// to one consumer, so a key is only limited in one place — until a rebalance
+// hands its partition, with a full bucket, to another instance.
+//
+// Messages without a key are not limited. Put RateLimit before AdaptiveLimit
+// and Timeout, so delayed messages hold neither a slot nor a deadline.
+// -------------------------------------------------------------------------------
+func RateLimit(name string, cfg config.RateLimitConfig, logger *zap.Logger) Middleware {
+	return func(next kafka.MessageHandler) kafka.MessageHandler {
+		if !cfg.Enabled {
+			return next
+		}
+		buckets := newKeyBuckets(name, cfg)
+		return func(ctx context.Context, key, value []byte, headers map[string]string) error {
+			k := string(key)
+			if cfg.KeyHeader != "" {
+				k = headers[cfg.KeyHeader]
+			}
+			limiter := buckets.get(k)
+			if limiter == nil || limiter.Allow() {
+				return next(ctx, key, value, headers)
+			}
+
+			if cfg.OnExceeded == config.RateLimitRetry {
+				metrics.RateLimited.WithLabelValues(name, config.RateLimitRetry).Inc()
+				logger.Debug("message deferred: key over quota", zap.String("key", k))
+				return kafka.Defer(fmt.Errorf("%w for key %s", ErrRateLimited, k))
+			}
+
+			metrics.RateLimited.WithLabelValues(name, config.RateLimitDelay).Inc()
+			if err := limiter.Wait(ctx); err != nil {
+				return kafka.Transient(fmt.Errorf("waiting for rate limit of key %s: %w", k, err))
+			}
+			return next(ctx, key, value, headers)
+		}
+	}
+}
+
+// keyBuckets holds a token bucket per key, dropping buckets unused for
+// cfg.IdleTTL.
+type keyBuckets struct {
+	name string
+	cfg  config.RateLimitConfig
+
+	mu        sync.Mutex
+	buckets   map[string]*keyBucket
+	lastSweep time.Time
+}
+
+type keyBucket struct {
+	limiter  *rate.Limiter
+	lastUsed time.Time
+}
+
+func newKeyBuckets(name string, cfg config.RateLimitConfig) *keyBuckets {
+	return &keyBuckets{
+		name:      name,
+		cfg:       cfg,
+		buckets:   make(map[string]*keyBucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// get returns key's bucket, or nil if key is not limited.
+func (b *keyBuckets) get(key string) *rate.Limiter {
+	if key == "" {
+		return nil
+	}
+	quota, ok := b.cfg.Overrides[key]
+	if !ok {
+		quota = b.cfg.Default
+	}
+	if quota.Rate == 0 {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	if now.Sub(b.lastSweep) >= b.cfg.IdleTTL {
+		for k, e := range b.buckets {
+			if now.Sub(e.lastUsed) >= b.cfg.IdleTTL {
+				delete(b.buckets, k)
+			}
+		}
+		b.lastSweep = now
+	}
+
+	e, ok := b.buckets[key]
+	if !ok {
+		e = &keyBucket{limiter: rate.NewLimiter(rate.Limit(quota.Rate), quota.Burst)}
+		b.buckets[key] = e
+	}
+	e.lastUsed = now
+	metrics.RateLimitKeys.WithLabelValues(b.name).Set(float64(len(b.buckets)))
+	return e.limiter
+}
@@ -14,7 +14,7 @@ type Transaction struct {
 	Metadata       map[string]string `json:"metadata,omitempty"`
 	CreatedAt      time.Time         `json:"created_at"`
 	ProcessedAt    *time.Time        `json:"processed_at,omitempty"`
-	SchemaVersion  int               `json:"schema_version"`
+	SchemaVersion  int               `json:"schema_version"` // Informational. With a schema registry, the schema ID in the record is authoritative.
 }
 
 type TransactionType string
@@ -47,6 +47,17 @@ type FraudResult struct {
 	ModelVersion  string    `json:"model_version"`
 }
 
+// ShadowResult is a shadow fraud scorer's verdict on one transaction next to
+// the primary's. It only feeds model evaluation; nothing acts on it.
+type ShadowResult struct {
+	TransactionID string      `json:"transaction_id"`
+	Shadow        string      `json:"shadow"` // name of the shadow scorer
+	Primary       FraudResult `json:"primary"`
+	Result        FraudResult `json:"result"`
+	DecisionMatch bool        `json:"decision_match"`
+	ScoreDelta    float64     `json:"score_delta"` // Result.RiskScore - Primary.RiskScore
+}
+
 type EnrichedTransaction struct {
 	Transaction
 	SenderRiskTier   string    `json:"sender_risk_tier"`
@@ -56,6 +67,22 @@ type EnrichedTransaction struct {
 	EnrichedAt       time.Time `json:"enriched_at"`
 }
 
+// Risk tiers, from least to most risky.
+const (
+	RiskTierLow    = "LOW"
+	RiskTierMedium = "MEDIUM"
+	RiskTierHigh   = "HIGH"
+)
+
+// RiskTier is a user's risk tier as a receiver of funds, keyed by user ID on a
+// compacted topic owned by the risk team. A user without one is UNKNOWN to the
+// enricher.
+type RiskTier struct {
+	UserID    string    `json:"user_id"`
+	Tier      string    `json:"tier"` // LOW | MEDIUM | HIGH
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
 // Notification is the terminal event — what actually reaches the end user.
 type Notification struct {
 	TransactionID string            `json:"transaction_id"`
@@ -63,9 +90,29 @@ type Notification struct {
 	Channel       string            `json:"channel"` // email / sms / push
 	TemplateID    string            `json:"template_id"`
 	Params        map[string]string `json:"params"`
+	Locale        string            `json:"locale,omitempty"` // the user's locale the template was rendered in; empty = default
 	SentAt        time.Time         `json:"sent_at"`
 }
 
+// NotificationPreferences is a user's notification settings, keyed by user ID
+// on a compacted topic owned by the profile service. A tombstone, like a user
+// who never set any, means the defaults: every channel, no quiet hours.
+type NotificationPreferences struct {
+	UserID     string          `json:"user_id"`
+	Channels   map[string]bool `json:"channels,omitempty"` // channel → opted in. Channels not listed are opted in.
+	QuietHours *QuietHours     `json:"quiet_hours,omitempty"`
+	Timezone   string          `json:"timezone,omitempty"` // IANA name, e.g. "Europe/Berlin". Empty = UTC.
+	Locale     string          `json:"locale,omitempty"`   // BCP 47, e.g. "de-DE". Empty = default templates.
+	UpdatedAt  time.Time       `json:"updated_at"`
+}
+
+// QuietHours is a daily window, in the user's timezone, without SMS or push.
+// End before Start spans midnight.
+type QuietHours struct {
+	Start string `json:"start"` // "22:00"
+	End   string `json:"end"`   // "07:00"
+}
+
 // DeadLetterEnvelope wraps any failed message with enough context to replay it.
 type DeadLetterEnvelope struct {
 	OriginalTopic     string            `json:"original_topic"`
@@ -75,11 +122,12 @@ type DeadLetterEnvelope struct {
 	OriginalValue     []byte            `json:"original_value"`
 	OriginalHeaders   map[string]string `json:"original_headers"`
 	ErrorMessage      string            `json:"error_message"`
-	ErrorType         string            `json:"error_type"` // DESERIALIZATION / PROCESSING / TRANSIENT / POISON
+	ErrorType         string            `json:"error_type"` // DESERIALIZATION / PROCESSING / TRANSIENT / DOWNSTREAM_UNAVAILABLE / POISON
 	RetryCount        int               `json:"retry_count"`
 	MaxRetries        int               `json:"max_retries"`
 	FirstFailedAt     time.Time         `json:"first_failed_at"`
 	LastFailedAt      time.Time         `json:"last_failed_at"`
 	ServiceName       string            `json:"service_name"`
 	ServiceVersion    string            `json:"service_version"`
+	ConsumerGroup     string            `json:"consumer_group"`
 }
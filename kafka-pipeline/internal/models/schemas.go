@@ -0,0 +1,13 @@
+package models
+
+import "embed"
+
+// Schemas holds the registry schemas of the event types above, one file per
+// type. They are compiled in so a binary always ships the schemas it was built
+// against; the schema_registry.schemas config maps topics to these files.
+//
+// Changing a struct means changing its schema here too — the startup
+// compatibility check then decides whether the change may be deployed.
+//
+//go:embed schemas
+var Schemas embed.FS
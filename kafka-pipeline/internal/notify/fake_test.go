@@ -0,0 +1,202 @@
+package notify
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"strings"
+	"sync"
+)
+
+// -------------------------------------------------------------------------------
+// Fake providers for tests.
+//
+// FakeSMTPServer speaks just enough SMTP for SMTPSender; FakeGateway accepts
+// the SMS and push gateway requests. Both listen on a real socket, so the real
+// senders are exercised end to end, and both keep the most recent
+// fakeRetention deliveries for inspection.
+// -------------------------------------------------------------------------------
+
+const fakeRetention = 1000
+
+// FakeEmail is one message received by FakeSMTPServer.
+type FakeEmail struct {
+	From string
+	To   []string
+	Data string // headers and body as sent
+}
+
+// FakeSMTPServer is an in-process SMTP sink.
+type FakeSMTPServer struct {
+	ln   net.Listener
+	done chan struct{}
+
+	mu       sync.Mutex
+	messages []FakeEmail
+}
+
+// NewFakeSMTPServer starts a fake SMTP server on addr ("127.0.0.1:0" picks a
+// free port; see Addr).
+func NewFakeSMTPServer(addr string) (*FakeSMTPServer, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("starting fake SMTP server: %w", err)
+	}
+	s := &FakeSMTPServer{ln: ln, done: make(chan struct{})}
+	go s.serve()
+	return s, nil
+}
+
+// Addr is the host:port the server listens on.
+func (s *FakeSMTPServer) Addr() string { return s.ln.Addr().String() }
+
+// Messages returns the messages received so far, oldest first.
+func (s *FakeSMTPServer) Messages() []FakeEmail {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]FakeEmail(nil), s.messages...)
+}
+
+func (s *FakeSMTPServer) Close() error {
+	err := s.ln.Close()
+	<-s.done
+	return err
+}
+
+func (s *FakeSMTPServer) serve() {
+	defer close(s.done)
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			s.handle(textproto.NewConn(conn))
+		}()
+	}
+}
+
+func (s *FakeSMTPServer) handle(c *textproto.Conn) {
+	c.PrintfLine("220 localhost fake SMTP ready")
+	var msg FakeEmail
+	for {
+		line, err := c.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			c.PrintfLine("250 localhost")
+		case "MAIL":
+			msg = FakeEmail{From: smtpPath(arg)}
+			c.PrintfLine("250 OK")
+		case "RCPT":
+			msg.To = append(msg.To, smtpPath(arg))
+			c.PrintfLine("250 OK")
+		case "DATA":
+			c.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+			data, err := io.ReadAll(c.DotReader())
+			if err != nil {
+				return
+			}
+			msg.Data = string(data)
+			s.record(msg)
+			c.PrintfLine("250 OK: queued")
+		case "RSET", "NOOP":
+			c.PrintfLine("250 OK")
+		case "QUIT":
+			c.PrintfLine("221 Bye")
+			return
+		default:
+			c.PrintfLine("502 Command not implemented")
+		}
+	}
+}
+
+func (s *FakeSMTPServer) record(msg FakeEmail) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = appendBounded(s.messages, msg)
+}
+
+// smtpPath extracts the address from "FROM:<a@b>" / "TO:<a@b>".
+func smtpPath(arg string) string {
+	_, path, _ := strings.Cut(arg, ":")
+	return strings.Trim(strings.TrimSpace(path), "<>")
+}
+
+// FakeGatewayRequest is one request received by FakeGateway.
+type FakeGatewayRequest struct {
+	Authorization string
+	Body          map[string]any
+}
+
+// FakeGateway is an in-process SMS/push gateway. It accepts any JSON object
+// with 202 Accepted.
+type FakeGateway struct {
+	srv *http.Server
+	ln  net.Listener
+
+	mu       sync.Mutex
+	requests []FakeGatewayRequest
+}
+
+// NewFakeGateway starts a fake gateway on addr ("127.0.0.1:0" picks a free
+// port; see URL).
+func NewFakeGateway(addr string) (*FakeGateway, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("starting fake gateway: %w", err)
+	}
+	g := &FakeGateway{ln: ln}
+	g.srv = &http.Server{Handler: g}
+	go g.srv.Serve(ln)
+	return g, nil
+}
+
+// URL is the gateway's endpoint.
+func (g *FakeGateway) URL() string { return "http://" + g.ln.Addr().String() + "/v1/messages" }
+
+// Requests returns the requests received so far, oldest first.
+func (g *FakeGateway) Requests() []FakeGatewayRequest {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]FakeGatewayRequest(nil), g.requests...)
+}
+
+func (g *FakeGateway) Close() error {
+	if err := g.srv.Close(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func (g *FakeGateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var body map[string]any
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	g.mu.Lock()
+	g.requests = appendBounded(g.requests, FakeGatewayRequest{Authorization: r.Header.Get("Authorization"), Body: body})
+	g.mu.Unlock()
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func appendBounded[T any](s []T, v T) []T {
+	if len(s) >= fakeRetention {
+		s = append(s[:0], s[1:]...)
+	}
+	return append(s, v)
+}
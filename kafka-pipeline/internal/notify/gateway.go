@@ -0,0 +1,100 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/kafka"
+)
+
+// GatewaySender delivers SMS or push notifications through an HTTP gateway
+// that takes one JSON message per POST and owns the mapping from user ID to
+// phone number or device tokens.
+type GatewaySender struct {
+	url     string
+	apiKey  string
+	http    *http.Client
+	payload func(Message) any
+}
+
+type smsRequest struct {
+	UserID        string `json:"user_id"`
+	Text          string `json:"text"`
+	TransactionID string `json:"transaction_id"`
+}
+
+type pushRequest struct {
+	UserID        string `json:"user_id"`
+	Title         string `json:"title"`
+	Body          string `json:"body"`
+	TransactionID string `json:"transaction_id"`
+}
+
+// NewSMSSender creates a sender for an SMS gateway at url.
+func NewSMSSender(url, apiKey string, timeout time.Duration) *GatewaySender {
+	return newGatewaySender(url, apiKey, timeout, func(msg Message) any {
+		return smsRequest{UserID: msg.To, Text: msg.Body, TransactionID: msg.Notification.TransactionID}
+	})
+}
+
+// NewPushSender creates a sender for a push gateway at url.
+func NewPushSender(url, apiKey string, timeout time.Duration) *GatewaySender {
+	return newGatewaySender(url, apiKey, timeout, func(msg Message) any {
+		return pushRequest{UserID: msg.To, Title: msg.Subject, Body: msg.Body, TransactionID: msg.Notification.TransactionID}
+	})
+}
+
+func newGatewaySender(url, apiKey string, timeout time.Duration, payload func(Message) any) *GatewaySender {
+	return &GatewaySender{
+		url:     url,
+		apiKey:  apiKey,
+		http:    &http.Client{Timeout: timeout},
+		payload: payload,
+	}
+}
+
+func (g *GatewaySender) Send(ctx context.Context, msg Message) error {
+	body, err := json.Marshal(g.payload(msg))
+	if err != nil {
+		return kafka.Poison(fmt.Errorf("encoding gateway request: %w", err))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, g.url, bytes.NewReader(body))
+	if err != nil {
+		return kafka.Poison(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if g.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+g.apiKey)
+	}
+
+	resp, err := g.http.Do(req)
+	if err != nil {
+		return kafka.DownstreamUnavailable(fmt.Errorf("calling %s: %w", g.url, err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 300 {
+		io.Copy(io.Discard, resp.Body) // let the connection be reused
+		return nil
+	}
+
+	detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	err = fmt.Errorf("gateway %s returned %s: %s", g.url, resp.Status, bytes.TrimSpace(detail))
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return kafka.Transient(err)
+	case resp.StatusCode == http.StatusUnauthorized, resp.StatusCode == http.StatusForbidden:
+		// Bad credentials fail every message alike; don't dead-letter them all.
+		return kafka.DownstreamUnavailable(err)
+	case resp.StatusCode >= 500:
+		return kafka.DownstreamUnavailable(err)
+	default:
+		return kafka.Poison(err) // the gateway rejected this message
+	}
+}
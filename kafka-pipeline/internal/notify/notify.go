@@ -0,0 +1,198 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/config"
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/kafka"
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/metrics"
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/models"
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/pkg/circuitbreaker"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+// Channels, as used in Notification.Channel.
+const (
+	ChannelEmail = "email"
+	ChannelSMS   = "sms"
+	ChannelPush  = "push"
+)
+
+// Message is a rendered notification, ready for a provider.
+type Message struct {
+	// To is the channel-specific address: an email address for email, the
+	// user ID for the SMS and push gateways.
+	To      string
+	Subject string // email subject / push title; empty for SMS
+	Body    string
+
+	Notification models.Notification
+}
+
+// Sender delivers messages over one channel. Errors a retry cannot fix (a
+// rejected address, a malformed request) should be marked with kafka.Poison.
+type Sender interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// ErrChannelDisabled is returned for notifications on a channel that has no
+// provider configured.
+var ErrChannelDisabled = errors.New("notification channel not configured")
+
+// -------------------------------------------------------------------------------
+// Dispatcher routes a notification to the Sender of its channel.
+//
+// Every channel has its own rate limiter and circuit breaker, so a throttled
+// SMS gateway slows SMS only, and a dead SMTP relay fails email fast —
+// DOWNSTREAM_UNAVAILABLE, so the consumer retries it later — without holding
+// up push.
+//
+// Delivery is at-least-once: when one of several notifications for a
+// transaction fails, the retry sends the ones that already went out again.
+// -------------------------------------------------------------------------------
+type Dispatcher struct {
+	templates *Templates
+	channels  map[string]*channel
+}
+
+type channel struct {
+	sender  Sender
+	address func(userID string) string
+	limiter *rate.Limiter
+	breaker *circuitbreaker.CircuitBreaker
+}
+
+// NewDispatcher loads the templates and builds a sender for every configured
+// channel.
+func NewDispatcher(cfg config.NotifierConfig, logger *zap.Logger) (*Dispatcher, error) {
+	templates, err := LoadTemplates(cfg.TemplatesDir)
+	if err != nil {
+		return nil, err
+	}
+	d := &Dispatcher{
+		templates: templates,
+		channels:  make(map[string]*channel),
+	}
+
+	if addr := cfg.Email.SMTPAddr; addr != "" {
+		var sender Sender
+		if addr == config.MockEndpoint {
+			logger.Warn("email notifications are logged, not delivered")
+			sender = logSender{logger.With(zap.String("channel", ChannelEmail))}
+		} else {
+			sender = NewSMTPSender(addr, cfg.Email.Username, cfg.Email.Password, cfg.Email.From)
+		}
+		d.add(ChannelEmail, sender, emailAddress(cfg.Email), cfg.Email.ChannelLimits)
+	}
+
+	for name, ch := range map[string]config.HTTPChannelConfig{ChannelSMS: cfg.SMS, ChannelPush: cfg.Push} {
+		if ch.URL == "" {
+			continue
+		}
+		var sender Sender
+		switch {
+		case ch.URL == config.MockEndpoint:
+			logger.Warn("notifications are logged, not delivered", zap.String("channel", name))
+			sender = logSender{logger.With(zap.String("channel", name))}
+		case name == ChannelSMS:
+			sender = NewSMSSender(ch.URL, ch.APIKey, ch.Timeout)
+		default:
+			sender = NewPushSender(ch.URL, ch.APIKey, ch.Timeout)
+		}
+		d.add(name, sender, func(userID string) string { return userID }, ch.ChannelLimits)
+	}
+
+	return d, nil
+}
+
+func (d *Dispatcher) add(name string, sender Sender, address func(string) string, limits config.ChannelLimits) {
+	limit := rate.Inf
+	if limits.RateLimit > 0 {
+		limit = rate.Limit(limits.RateLimit)
+	}
+	d.channels[name] = &channel{
+		sender:  sender,
+		address: address,
+		limiter: rate.NewLimiter(limit, max(limits.Burst, 1)),
+		breaker: circuitbreaker.New(circuitbreaker.Config{
+			Name: "notify_" + name,
+			// A rejected message says nothing about the provider's health;
+			// only retryable failures count against the breaker.
+			IsFailure: func(err error) bool { return kafka.ClassOf(err).Retryable() },
+		}),
+	}
+}
+
+// Deliverable reports whether n can go out over channel: the channel has a
+// provider and a template for templateID.
+func (d *Dispatcher) Deliverable(channel, templateID string) bool {
+	_, ok := d.channels[channel]
+	return ok && d.templates.Has(channel, templateID)
+}
+
+// Send renders n and delivers it over its channel, waiting for the channel's
+// rate limit if necessary.
+func (d *Dispatcher) Send(ctx context.Context, n models.Notification) error {
+	err := d.send(ctx, n)
+	status := "success"
+	if err != nil {
+		status = string(kafka.ClassOf(err))
+	}
+	metrics.NotificationsSent.WithLabelValues(n.Channel, status).Inc()
+	return err
+}
+
+func (d *Dispatcher) send(ctx context.Context, n models.Notification) error {
+	ch, ok := d.channels[n.Channel]
+	if !ok {
+		return kafka.Poison(fmt.Errorf("%w: %q", ErrChannelDisabled, n.Channel))
+	}
+
+	msg, err := d.templates.Render(n)
+	if err != nil {
+		return kafka.Poison(err)
+	}
+	msg.To = ch.address(n.UserID)
+
+	if err := ch.limiter.Wait(ctx); err != nil {
+		return kafka.Transient(fmt.Errorf("waiting for %s rate limit: %w", n.Channel, err))
+	}
+
+	err = ch.breaker.ExecuteCtx(ctx, func(ctx context.Context) error {
+		return ch.sender.Send(ctx, msg)
+	})
+	if err != nil {
+		return fmt.Errorf("sending %s notification: %w", n.Channel, err)
+	}
+	return nil
+}
+
+func emailAddress(cfg config.EmailChannelConfig) func(string) string {
+	return func(userID string) string {
+		if addr, ok := cfg.Recipients[userID]; ok {
+			return addr
+		}
+		if cfg.AddressFormat == "" {
+			return userID
+		}
+		return fmt.Sprintf(cfg.AddressFormat, userID)
+	}
+}
+
+// logSender stands in for a provider in local runs (a config.MockEndpoint
+// endpoint): it logs each message and reports it delivered.
+type logSender struct {
+	logger *zap.Logger
+}
+
+func (s logSender) Send(_ context.Context, msg Message) error {
+	s.logger.Info("notification",
+		zap.String("to", msg.To),
+		zap.String("subject", msg.Subject),
+		zap.String("body", msg.Body),
+	)
+	return nil
+}
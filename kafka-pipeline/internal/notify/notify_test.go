@@ -0,0 +1,108 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/config"
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/models"
+	"go.uber.org/zap"
+)
+
+func writeTemplates(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, body := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestDispatcherDelivers(t *testing.T) {
+	smtpServer, err := NewFakeSMTPServer("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer smtpServer.Close()
+	gateway, err := NewFakeGateway("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer gateway.Close()
+
+	d, err := NewDispatcher(config.NotifierConfig{
+		TemplatesDir: writeTemplates(t, map[string]string{
+			"email/sent.tmpl": `{{define "subject"}}You sent {{.amount}}{{end}}Your payment of {{.amount}} went through.`,
+			"sms/sent.tmpl":   `Paid {{.amount}}.`,
+			"push/sent.tmpl":  `{{define "subject"}}Paid{{end}}Paid {{.amount}}.`,
+		}),
+		Email: config.EmailChannelConfig{
+			SMTPAddr:      smtpServer.Addr(),
+			From:          "Payments <no-reply@payments.example.com>",
+			AddressFormat: "%s@users.example.com",
+		},
+		SMS:  config.HTTPChannelConfig{URL: gateway.URL(), APIKey: "secret", Timeout: time.Second},
+		Push: config.HTTPChannelConfig{URL: config.MockEndpoint, Timeout: time.Second},
+	}, zap.NewNop())
+	if err != nil {
+		t.Fatalf("NewDispatcher: %v", err)
+	}
+
+	for _, channel := range []string{ChannelEmail, ChannelSMS, ChannelPush} {
+		err := d.Send(context.Background(), models.Notification{
+			TransactionID: "txn-1",
+			UserID:        "user-1",
+			Channel:       channel,
+			TemplateID:    "sent",
+			Params:        map[string]string{"amount": "INR 250"},
+		})
+		if err != nil {
+			t.Fatalf("Send %s: %v", channel, err)
+		}
+	}
+
+	emails := smtpServer.Messages()
+	if len(emails) != 1 {
+		t.Fatalf("got %d emails, want 1", len(emails))
+	}
+	if got := emails[0]; len(got.To) != 1 || got.To[0] != "user-1@users.example.com" ||
+		!strings.Contains(got.Data, "Subject: You sent INR 250") ||
+		!strings.Contains(got.Data, "Your payment of INR 250 went through.") {
+		t.Fatalf("unexpected email %+v", got)
+	}
+
+	requests := gateway.Requests()
+	if len(requests) != 1 {
+		t.Fatalf("got %d gateway requests, want 1", len(requests))
+	}
+	if got := requests[0]; got.Authorization != "Bearer secret" || got.Body["user_id"] != "user-1" || got.Body["text"] != "Paid INR 250." {
+		t.Fatalf("unexpected gateway request %+v", got)
+	}
+}
+
+func TestDispatcherDisabledChannel(t *testing.T) {
+	d, err := NewDispatcher(config.NotifierConfig{
+		TemplatesDir: writeTemplates(t, map[string]string{"sms/sent.tmpl": `Paid.`}),
+	}, zap.NewNop())
+	if err != nil {
+		t.Fatalf("NewDispatcher: %v", err)
+	}
+	if d.Deliverable(ChannelSMS, "sent") {
+		t.Fatal("Deliverable on a channel without a provider")
+	}
+	err = d.Send(context.Background(), models.Notification{Channel: ChannelSMS, TemplateID: "sent"})
+	if !errors.Is(err, ErrChannelDisabled) {
+		t.Fatalf("Send: got %v, want ErrChannelDisabled", err)
+	}
+}
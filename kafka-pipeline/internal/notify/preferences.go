@@ -0,0 +1,164 @@
+package notify
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/kafka"
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/metrics"
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/models"
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/statestore"
+	"go.uber.org/zap"
+)
+
+// fallbackOrder is the order in which other channels are tried when the
+// user's preferences rule out the requested one.
+var fallbackOrder = []string{ChannelEmail, ChannelPush, ChannelSMS}
+
+// PreferenceTable reads users' preferences from a GlobalTable over the
+// preferences topic. decode turns a record value into
+// models.NotificationPreferences (serde.Deserializer.Deserialize, bound to
+// the topic).
+type PreferenceTable struct {
+	table  *statestore.GlobalTable
+	decode func(raw []byte, v any) error
+}
+
+func NewPreferenceTable(table *statestore.GlobalTable, decode func(raw []byte, v any) error) *PreferenceTable {
+	return &PreferenceTable{table: table, decode: decode}
+}
+
+// Get returns userID's preferences; found is false for users who never set
+// any. A record that cannot be decoded is a kafka.Deserialization error.
+func (p *PreferenceTable) Get(userID string) (models.NotificationPreferences, bool, error) {
+	var prefs models.NotificationPreferences
+	raw, found, err := p.table.Get(userID)
+	if err != nil {
+		return prefs, false, kafka.Transient(fmt.Errorf("reading preferences of %s: %w", userID, err))
+	}
+	if !found {
+		return prefs, false, nil
+	}
+	if err := p.decode(raw, &prefs); err != nil {
+		return prefs, false, kafka.Deserialization(fmt.Errorf("decoding preferences of %s: %w", userID, err))
+	}
+	return prefs, true, nil
+}
+
+// -------------------------------------------------------------------------------
+// Router applies the recipient's preferences to a notification before it is
+// sent:
+//
+//   - Locale: the notification is rendered in the user's locale.
+//   - Channel opt-in: a channel the user opted out of is never used. The
+//     notification moves to the first opted-in channel in fallbackOrder that
+//     can deliver its template.
+//   - Quiet hours: SMS and push are ruled out inside the window; email, which
+//     waits in an inbox, is not. There is no "send later": what cannot move to
+//     email is suppressed.
+//
+// Preferences that cannot be read (a malformed record, an unknown timezone)
+// are logged and ignored for the part that is broken; a notification is never
+// dead-lettered for the profile service's data.
+// -------------------------------------------------------------------------------
+type Router struct {
+	prefs      *PreferenceTable
+	dispatcher *Dispatcher
+	logger     *zap.Logger
+}
+
+func NewRouter(prefs *PreferenceTable, dispatcher *Dispatcher, logger *zap.Logger) *Router {
+	return &Router{prefs: prefs, dispatcher: dispatcher, logger: logger}
+}
+
+// Route returns n as it should be sent at now, or ok=false if the user's
+// preferences rule out every channel.
+func (r *Router) Route(n models.Notification, now time.Time) (routed models.Notification, ok bool, err error) {
+	prefs, found, err := r.prefs.Get(n.UserID)
+	if err != nil {
+		if kafka.ClassOf(err) != kafka.ClassDeserialization {
+			return n, false, err
+		}
+		r.logger.Warn("ignoring unreadable notification preferences", zap.String("user_id", n.UserID), zap.Error(err))
+		return n, true, nil
+	}
+	if !found {
+		return n, true, nil
+	}
+
+	n.Locale = prefs.Locale
+	quiet := r.inQuietHours(prefs, now)
+
+	reason := "opted_out"
+	if optedIn(prefs, n.Channel) {
+		reason = "quiet_hours"
+	}
+	for _, channel := range append([]string{n.Channel}, fallbackOrder...) {
+		if !optedIn(prefs, channel) || (quiet && channel != ChannelEmail) {
+			continue
+		}
+		if !r.dispatcher.Deliverable(channel, n.TemplateID) {
+			continue
+		}
+		if channel != n.Channel {
+			metrics.NotificationsRerouted.WithLabelValues(n.Channel, channel).Inc()
+			n.Channel = channel
+		}
+		return n, true, nil
+	}
+
+	metrics.NotificationsSuppressed.WithLabelValues(reason).Inc()
+	return n, false, nil
+}
+
+func (r *Router) inQuietHours(prefs models.NotificationPreferences, now time.Time) bool {
+	if prefs.QuietHours == nil {
+		return false
+	}
+	quiet, err := inQuietHours(*prefs.QuietHours, prefs.Timezone, now)
+	if err != nil {
+		r.logger.Warn("ignoring invalid quiet hours", zap.String("user_id", prefs.UserID), zap.Error(err))
+		return false
+	}
+	return quiet
+}
+
+// optedIn reports whether the user allows channel. Channels the preferences
+// don't mention are allowed.
+func optedIn(prefs models.NotificationPreferences, channel string) bool {
+	allowed, listed := prefs.Channels[channel]
+	return !listed || allowed
+}
+
+// inQuietHours reports whether now falls in q in timezone tz. A window whose
+// end is before its start spans midnight; one whose start equals its end is
+// empty.
+func inQuietHours(q models.QuietHours, tz string, now time.Time) (bool, error) {
+	loc, err := time.LoadLocation(tz) // "" is UTC
+	if err != nil {
+		return false, fmt.Errorf("timezone %q: %w", tz, err)
+	}
+	start, err := minuteOfDay(q.Start)
+	if err != nil {
+		return false, err
+	}
+	end, err := minuteOfDay(q.End)
+	if err != nil {
+		return false, err
+	}
+
+	local := now.In(loc)
+	minute := local.Hour()*60 + local.Minute()
+	if start <= end {
+		return minute >= start && minute < end, nil
+	}
+	return minute >= start || minute < end, nil
+}
+
+func minuteOfDay(hhmm string) (int, error) {
+	t, err := time.Parse("15:04", hhmm)
+	if err != nil {
+		return 0, fmt.Errorf("quiet hours time %q: want HH:MM", hhmm)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
@@ -0,0 +1,118 @@
+package notify
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/kafka"
+)
+
+// SMTPSender delivers email through an SMTP relay.
+//
+// net/smtp.SendMail has no timeouts, so a hung relay would hang the partition;
+// the connection here is bounded by the ctx deadline instead.
+type SMTPSender struct {
+	addr     string
+	host     string
+	username string
+	password string
+	from     string
+}
+
+// NewSMTPSender creates a sender for the relay at addr (host:port). username
+// may be empty for relays that don't authenticate.
+func NewSMTPSender(addr, username, password, from string) *SMTPSender {
+	host, _, _ := net.SplitHostPort(addr)
+	return &SMTPSender{addr: addr, host: host, username: username, password: password, from: from}
+}
+
+func (s *SMTPSender) Send(ctx context.Context, msg Message) error {
+	from, err := mail.ParseAddress(s.from)
+	if err != nil {
+		return kafka.Poison(fmt.Errorf("invalid sender address %q: %w", s.from, err))
+	}
+	to, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return kafka.Poison(fmt.Errorf("invalid recipient address %q: %w", msg.To, err))
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", s.addr)
+	if err != nil {
+		return kafka.DownstreamUnavailable(fmt.Errorf("connecting to %s: %w", s.addr, err))
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	client, err := smtp.NewClient(conn, s.host)
+	if err != nil {
+		conn.Close()
+		return kafka.DownstreamUnavailable(fmt.Errorf("greeting %s: %w", s.addr, err))
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: s.host}); err != nil {
+			return kafka.DownstreamUnavailable(fmt.Errorf("smtp starting TLS: %w", err))
+		}
+	}
+	// Failing to authenticate is our problem, not the message's: retry it
+	// rather than dead-lettering every email until the credentials are fixed.
+	if s.username != "" {
+		if err := client.Auth(smtp.PlainAuth("", s.username, s.password, s.host)); err != nil {
+			return kafka.DownstreamUnavailable(fmt.Errorf("smtp authenticating: %w", err))
+		}
+	}
+	if err := client.Mail(from.Address); err != nil {
+		return smtpError("MAIL FROM", err)
+	}
+	if err := client.Rcpt(to.Address); err != nil {
+		return smtpError("RCPT TO", err)
+	}
+	w, err := client.Data()
+	if err != nil {
+		return smtpError("DATA", err)
+	}
+	if _, err := w.Write(buildEmail(from, to, msg)); err != nil {
+		return smtpError("writing message", err)
+	}
+	if err := w.Close(); err != nil {
+		return smtpError("finishing message", err)
+	}
+	return client.Quit()
+}
+
+func buildEmail(from, to *mail.Address, msg Message) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", to)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	b.WriteString("\r\n")
+	return []byte(b.String())
+}
+
+// smtpError classifies a relay reply: 5xx is a permanent rejection of this
+// message, anything else (4xx, broken connection) is worth retrying.
+func smtpError(step string, err error) error {
+	err = fmt.Errorf("smtp %s: %w", step, err)
+	var reply *textproto.Error
+	if errors.As(err, &reply) && reply.Code >= 500 {
+		return kafka.Poison(err)
+	}
+	return kafka.Transient(err)
+}
@@ -0,0 +1,131 @@
+package notify
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/models"
+)
+
+// ErrTemplateNotFound is returned for a TemplateID with no file for the
+// notification's channel.
+var ErrTemplateNotFound = errors.New("notification template not found")
+
+const subjectTemplate = "subject"
+
+// -------------------------------------------------------------------------------
+// Templates are text/template files laid out as <dir>/<channel>/<id>.tmpl, so
+// the same TemplateID can read differently by email and by SMS.
+//
+// Translations sit next to the default as <id>.<locale>.tmpl ("de",
+// "pt-BR"). A notification with a Locale gets the closest match — "pt-BR",
+// then "pt", then the default — so a template never needs every translation.
+//
+// The file renders the body. Email and push templates also define the subject
+// (push: title) with {{define "subject"}}...{{end}}. Templates see the
+// notification's Params as top-level keys, plus user_id and transaction_id:
+//
+//	{{define "subject"}}You sent {{.currency}} {{.amount}}{{end}}
+//	Your payment of {{.currency}} {{.amount}} to {{.receiver}} went through.
+//
+// A key the template uses but Params lacks is a render error, not "<no value>"
+// in front of a customer.
+// -------------------------------------------------------------------------------
+type Templates struct {
+	byChannel map[string]map[string]*template.Template // channel → id or id.locale → template
+}
+
+// LoadTemplates parses every template under dir. All of them are parsed up
+// front, so a broken template stops the rollout instead of failing messages.
+func LoadTemplates(dir string) (*Templates, error) {
+	t := &Templates{byChannel: make(map[string]map[string]*template.Template)}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*", "*.tmpl"))
+	if err != nil {
+		return nil, fmt.Errorf("listing templates in %s: %w", dir, err)
+	}
+	for _, path := range paths {
+		channel := filepath.Base(filepath.Dir(path))
+		id := strings.TrimSuffix(filepath.Base(path), ".tmpl")
+		if base, locale, ok := strings.Cut(id, "."); ok {
+			id = base + "." + normalizeLocale(locale)
+		}
+
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading template %s: %w", path, err)
+		}
+		tmpl, err := template.New(id).Option("missingkey=error").Parse(string(raw))
+		if err != nil {
+			return nil, fmt.Errorf("parsing template %s: %w", path, err)
+		}
+
+		if t.byChannel[channel] == nil {
+			t.byChannel[channel] = make(map[string]*template.Template)
+		}
+		t.byChannel[channel][id] = tmpl
+	}
+	return t, nil
+}
+
+// Has reports whether channel has a template for id, in any locale.
+func (t *Templates) Has(channel, id string) bool {
+	_, ok := t.byChannel[channel][id]
+	return ok
+}
+
+// Render renders the template of n for n's channel, in n's locale if there
+// is a translation. The returned Message has no recipient address yet.
+func (t *Templates) Render(n models.Notification) (Message, error) {
+	tmpl, ok := t.lookup(n.Channel, n.TemplateID, n.Locale)
+	if !ok {
+		return Message{}, fmt.Errorf("%w: %s/%s", ErrTemplateNotFound, n.Channel, n.TemplateID)
+	}
+
+	data := make(map[string]string, len(n.Params)+2)
+	data["user_id"] = n.UserID
+	data["transaction_id"] = n.TransactionID
+	for k, v := range n.Params {
+		data[k] = v
+	}
+
+	var body strings.Builder
+	if err := tmpl.Execute(&body, data); err != nil {
+		return Message{}, fmt.Errorf("rendering template %s/%s: %w", n.Channel, n.TemplateID, err)
+	}
+	msg := Message{Body: strings.TrimSpace(body.String()), Notification: n}
+
+	if subject := tmpl.Lookup(subjectTemplate); subject != nil {
+		var sb strings.Builder
+		if err := subject.Execute(&sb, data); err != nil {
+			return Message{}, fmt.Errorf("rendering subject of %s/%s: %w", n.Channel, n.TemplateID, err)
+		}
+		msg.Subject = strings.TrimSpace(sb.String())
+	}
+	return msg, nil
+}
+
+func (t *Templates) lookup(channel, id, locale string) (*template.Template, bool) {
+	templates := t.byChannel[channel]
+	if locale = normalizeLocale(locale); locale != "" {
+		if tmpl, ok := templates[id+"."+locale]; ok {
+			return tmpl, true
+		}
+		if lang, _, ok := strings.Cut(locale, "-"); ok {
+			if tmpl, ok := templates[id+"."+lang]; ok {
+				return tmpl, true
+			}
+		}
+	}
+	tmpl, ok := templates[id]
+	return tmpl, ok
+}
+
+// normalizeLocale makes "pt_BR", "PT-br" and "pt-BR" the same key.
+func normalizeLocale(locale string) string {
+	return strings.ToLower(strings.ReplaceAll(locale, "_", "-"))
+}
@@ -0,0 +1,323 @@
+package rules
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Decisions, as used in models.FraudResult.Decision.
+const (
+	DecisionApprove = "APPROVE"
+	DecisionReview  = "REVIEW"
+	DecisionReject  = "REJECT"
+)
+
+// Kind is the type of a fact. Conditions are type-checked against it when a
+// rule set is loaded, so a typo in a rules file fails the reload instead of
+// silently never matching.
+type Kind int
+
+const (
+	KindNumber Kind = iota
+	KindString
+	KindBool
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindNumber:
+		return "number"
+	case KindString:
+		return "string"
+	case KindBool:
+		return "bool"
+	default:
+		return fmt.Sprintf("Kind(%d)", int(k))
+	}
+}
+
+// Schema lists the facts rules may refer to. A name ending in ".*" covers
+// every fact with that prefix, e.g. "metadata.*".
+type Schema map[string]Kind
+
+func (s Schema) kindOf(field string) (Kind, bool) {
+	if k, ok := s[field]; ok {
+		return k, true
+	}
+	if i := strings.LastIndexByte(field, '.'); i > 0 {
+		k, ok := s[field[:i]+".*"]
+		return k, ok
+	}
+	return 0, false
+}
+
+// Facts are what a rule set is evaluated against: float64 for KindNumber,
+// string for KindString, bool for KindBool. A condition on a missing fact
+// does not match.
+type Facts map[string]any
+
+// -------------------------------------------------------------------------------
+// RuleSet is a compiled rules file: weighted factors and the score thresholds
+// that turn their sum into a decision.
+//
+//	version: "2026-10-16.1"
+//	thresholds:
+//	  review: 0.4
+//	  reject: 0.7
+//	rules:
+//	  - name: high_amount
+//	    weight: 0.3
+//	    all:
+//	      - {field: amount, op: gt, value: 10000}
+//
+// A rule matches when all of its "all" conditions and at least one of its
+// "any" conditions hold (an empty list is no constraint; a rule needs at least
+// one condition). The score is the sum of the weights of matching rules,
+// clamped to [0, 1]. Each matching rule adds its factor — its name unless set
+// — to the risk factors, once.
+//
+// Operators: eq, ne (every kind); gt, gte, lt, lte (numbers); in, not_in
+// (numbers and strings, value is a list).
+// -------------------------------------------------------------------------------
+type RuleSet struct {
+	Version string
+	Review  float64
+	Reject  float64
+	rules   []rule
+}
+
+type rule struct {
+	factor string
+	weight float64
+	all    []condition
+	any    []condition
+}
+
+type condition struct {
+	field string
+	match func(any) bool
+}
+
+// Result is the outcome of evaluating a RuleSet.
+type Result struct {
+	Score    float64
+	Factors  []string
+	Decision string
+}
+
+// Evaluate scores facts.
+func (rs *RuleSet) Evaluate(facts Facts) Result {
+	var res Result
+	for _, r := range rs.rules {
+		if !r.matches(facts) {
+			continue
+		}
+		res.Score += r.weight
+		if !slices.Contains(res.Factors, r.factor) {
+			res.Factors = append(res.Factors, r.factor)
+		}
+	}
+	res.Score = min(max(res.Score, 0), 1)
+
+	switch {
+	case res.Score >= rs.Reject:
+		res.Decision = DecisionReject
+	case res.Score >= rs.Review:
+		res.Decision = DecisionReview
+	default:
+		res.Decision = DecisionApprove
+	}
+	return res
+}
+
+func (r rule) matches(facts Facts) bool {
+	for _, c := range r.all {
+		if !c.holds(facts) {
+			return false
+		}
+	}
+	if len(r.any) == 0 {
+		return true
+	}
+	for _, c := range r.any {
+		if c.holds(facts) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c condition) holds(facts Facts) bool {
+	v, ok := facts[c.field]
+	return ok && c.match(v)
+}
+
+// ruleFile is the YAML layout of a rules file.
+type ruleFile struct {
+	Version    string `yaml:"version"`
+	Thresholds struct {
+		Review *float64 `yaml:"review"`
+		Reject *float64 `yaml:"reject"`
+	} `yaml:"thresholds"`
+	Rules []struct {
+		Name   string          `yaml:"name"`
+		Factor string          `yaml:"factor"`
+		Weight float64         `yaml:"weight"`
+		All    []conditionSpec `yaml:"all"`
+		Any    []conditionSpec `yaml:"any"`
+	} `yaml:"rules"`
+}
+
+type conditionSpec struct {
+	Field string    `yaml:"field"`
+	Op    string    `yaml:"op"`
+	Value yaml.Node `yaml:"value"`
+}
+
+// Parse compiles a rules file. Every condition is checked against schema.
+func Parse(raw []byte, schema Schema) (*RuleSet, error) {
+	var f ruleFile
+	dec := yaml.NewDecoder(strings.NewReader(string(raw)))
+	dec.KnownFields(true)
+	if err := dec.Decode(&f); err != nil {
+		return nil, fmt.Errorf("parsing: %w", err)
+	}
+
+	if f.Version == "" {
+		return nil, fmt.Errorf("version is required")
+	}
+	if f.Thresholds.Review == nil || f.Thresholds.Reject == nil {
+		return nil, fmt.Errorf("thresholds.review and thresholds.reject are required")
+	}
+	rs := &RuleSet{Version: f.Version, Review: *f.Thresholds.Review, Reject: *f.Thresholds.Reject}
+	if rs.Review < 0 || rs.Review > rs.Reject || rs.Reject > 1 {
+		return nil, fmt.Errorf("thresholds must satisfy 0 <= review <= reject <= 1")
+	}
+
+	names := make(map[string]bool, len(f.Rules))
+	for i, spec := range f.Rules {
+		if spec.Name == "" {
+			return nil, fmt.Errorf("rule %d: name is required", i)
+		}
+		if names[spec.Name] {
+			return nil, fmt.Errorf("rule %s: duplicate name", spec.Name)
+		}
+		names[spec.Name] = true
+		if len(spec.All)+len(spec.Any) == 0 {
+			return nil, fmt.Errorf("rule %s: needs at least one condition", spec.Name)
+		}
+
+		r := rule{factor: spec.Factor, weight: spec.Weight}
+		if r.factor == "" {
+			r.factor = spec.Name
+		}
+		for _, cs := range spec.All {
+			c, err := compile(cs, schema)
+			if err != nil {
+				return nil, fmt.Errorf("rule %s: %w", spec.Name, err)
+			}
+			r.all = append(r.all, c)
+		}
+		for _, cs := range spec.Any {
+			c, err := compile(cs, schema)
+			if err != nil {
+				return nil, fmt.Errorf("rule %s: %w", spec.Name, err)
+			}
+			r.any = append(r.any, c)
+		}
+		rs.rules = append(rs.rules, r)
+	}
+	return rs, nil
+}
+
+func compile(spec conditionSpec, schema Schema) (condition, error) {
+	kind, ok := schema.kindOf(spec.Field)
+	if !ok {
+		return condition{}, fmt.Errorf("unknown field %q", spec.Field)
+	}
+	c := condition{field: spec.Field}
+	fail := func(err error) (condition, error) {
+		return condition{}, fmt.Errorf("%s %s: %w", spec.Field, spec.Op, err)
+	}
+
+	switch spec.Op {
+	case "eq", "ne":
+		var want any
+		var err error
+		switch kind {
+		case KindNumber:
+			want, err = decodeAs[float64](spec.Value)
+		case KindString:
+			want, err = decodeAs[string](spec.Value)
+		case KindBool:
+			want, err = decodeAs[bool](spec.Value)
+		}
+		if err != nil {
+			return fail(err)
+		}
+		eq := spec.Op == "eq"
+		c.match = func(v any) bool { return (v == want) == eq }
+
+	case "gt", "gte", "lt", "lte":
+		if kind != KindNumber {
+			return fail(fmt.Errorf("needs a number field, %s is a %s", spec.Field, kind))
+		}
+		want, err := decodeAs[float64](spec.Value)
+		if err != nil {
+			return fail(err)
+		}
+		cmp := map[string]func(a, b float64) bool{
+			"gt":  func(a, b float64) bool { return a > b },
+			"gte": func(a, b float64) bool { return a >= b },
+			"lt":  func(a, b float64) bool { return a < b },
+			"lte": func(a, b float64) bool { return a <= b },
+		}[spec.Op]
+		c.match = func(v any) bool {
+			f, ok := v.(float64)
+			return ok && cmp(f, want)
+		}
+
+	case "in", "not_in":
+		var set []any
+		switch kind {
+		case KindNumber:
+			values, err := decodeAs[[]float64](spec.Value)
+			if err != nil {
+				return fail(err)
+			}
+			for _, v := range values {
+				set = append(set, v)
+			}
+		case KindString:
+			values, err := decodeAs[[]string](spec.Value)
+			if err != nil {
+				return fail(err)
+			}
+			for _, v := range values {
+				set = append(set, v)
+			}
+		default:
+			return fail(fmt.Errorf("not supported on %s fields", kind))
+		}
+		in := spec.Op == "in"
+		c.match = func(v any) bool { return slices.Contains(set, v) == in }
+
+	default:
+		return fail(fmt.Errorf("unknown operator"))
+	}
+	return c, nil
+}
+
+func decodeAs[T any](node yaml.Node) (T, error) {
+	var v T
+	if node.Kind == 0 {
+		return v, fmt.Errorf("value is required")
+	}
+	if err := node.Decode(&v); err != nil {
+		return v, fmt.Errorf("value: %w", err)
+	}
+	return v, nil
+}
@@ -0,0 +1,162 @@
+package rules
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+var testSchema = Schema{
+	"amount":     KindNumber,
+	"currency":   KindString,
+	"new_device": KindBool,
+	"metadata.*": KindString,
+}
+
+const testRules = `
+version: "v1"
+thresholds:
+  review: 0.4
+  reject: 0.7
+rules:
+  - name: high_amount
+    weight: 0.3
+    all:
+      - {field: amount, op: gt, value: 10000}
+  - name: very_high_amount
+    factor: high_amount
+    weight: 0.3
+    all:
+      - {field: amount, op: gte, value: 50000}
+  - name: risky_currency
+    weight: 0.5
+    any:
+      - {field: currency, op: in, value: [XMR, ZEC]}
+      - {field: metadata.channel, op: eq, value: darkweb}
+  - name: new_device
+    weight: 0.2
+    all:
+      - {field: new_device, op: eq, value: true}
+  - name: trusted_device
+    weight: -0.4
+    all:
+      - {field: new_device, op: eq, value: false}
+`
+
+func TestParseErrors(t *testing.T) {
+	const header = "version: v1\nthresholds: {review: 0.4, reject: 0.7}\n"
+	for _, tt := range []struct {
+		name string
+		file string
+		want string
+	}{
+		{"unknown key", header + "rulez: []\n", "field rulez not found"},
+		{"missing version", "thresholds: {review: 0.4, reject: 0.7}\n", "version is required"},
+		{"missing threshold", "version: v1\nthresholds: {review: 0.4}\n", "thresholds.review and thresholds.reject are required"},
+		{"review above reject", "version: v1\nthresholds: {review: 0.8, reject: 0.7}\n", "0 <= review <= reject <= 1"},
+		{"reject above 1", "version: v1\nthresholds: {review: 0.4, reject: 1.5}\n", "0 <= review <= reject <= 1"},
+		{"negative review", "version: v1\nthresholds: {review: -0.1, reject: 0.7}\n", "0 <= review <= reject <= 1"},
+		{"unnamed rule", header + "rules: [{weight: 1, all: [{field: amount, op: gt, value: 1}]}]\n", "rule 0: name is required"},
+		{"duplicate name", header + "rules:\n" +
+			"  - {name: a, all: [{field: amount, op: gt, value: 1}]}\n" +
+			"  - {name: a, all: [{field: amount, op: lt, value: 1}]}\n", "rule a: duplicate name"},
+		{"no conditions", header + "rules: [{name: a, weight: 1}]\n", "needs at least one condition"},
+		{"unknown field", header + "rules: [{name: a, all: [{field: amout, op: gt, value: 1}]}]\n", `unknown field "amout"`},
+		{"unknown operator", header + "rules: [{name: a, all: [{field: amount, op: between, value: 1}]}]\n", "unknown operator"},
+		{"order on a string", header + "rules: [{name: a, all: [{field: currency, op: gt, value: 1}]}]\n", "needs a number field"},
+		{"string for a number", header + "rules: [{name: a, all: [{field: amount, op: eq, value: lots}]}]\n", "amount eq: value"},
+		{"number for a bool", header + "rules: [{name: a, any: [{field: new_device, op: eq, value: 3}]}]\n", "new_device eq: value"},
+		{"in on a bool", header + "rules: [{name: a, all: [{field: new_device, op: in, value: [true]}]}]\n", "not supported on bool fields"},
+		{"in without a list", header + "rules: [{name: a, all: [{field: currency, op: in, value: USD}]}]\n", "currency in: value"},
+		{"missing value", header + "rules: [{name: a, all: [{field: amount, op: gt}]}]\n", "value is required"},
+		{"missing value in a prefix field", header + "rules: [{name: a, all: [{field: metadata.channel, op: ne}]}]\n", "value is required"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.file), testSchema)
+			if err == nil {
+				t.Fatal("Parse succeeded")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("error %q, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	rs, err := Parse([]byte(testRules), testSchema)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	for _, tt := range []struct {
+		name     string
+		facts    Facts
+		score    float64
+		factors  []string
+		decision string
+	}{
+		{"nothing matches", Facts{"amount": 100.0}, 0, nil, DecisionApprove},
+		{"missing facts never match", Facts{}, 0, nil, DecisionApprove},
+		{"below review", Facts{"amount": 20000.0}, 0.3, []string{"high_amount"}, DecisionApprove},
+		{"review", Facts{"amount": 20000.0, "new_device": true, "currency": "EUR"}, 0.5, []string{"high_amount", "new_device"}, DecisionReview},
+		{"reject at the threshold", Facts{"currency": "ZEC", "new_device": true}, 0.7, []string{"risky_currency", "new_device"}, DecisionReject},
+		{"shared factor listed once", Facts{"amount": 50000.0}, 0.6, []string{"high_amount"}, DecisionReview},
+		{"any of several", Facts{"currency": "USD", "metadata.channel": "darkweb"}, 0.5, []string{"risky_currency"}, DecisionReview},
+		{"fact of the wrong type", Facts{"amount": "20000"}, 0, nil, DecisionApprove},
+		{"clamped to 1", Facts{"amount": 60000.0, "currency": "XMR", "new_device": true}, 1, []string{"high_amount", "risky_currency", "new_device"}, DecisionReject},
+		{"clamped to 0", Facts{"amount": 100.0, "new_device": false}, 0, []string{"trusted_device"}, DecisionApprove},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			res := rs.Evaluate(tt.facts)
+			if diff := res.Score - tt.score; diff > 1e-9 || diff < -1e-9 {
+				t.Errorf("score %v, want %v", res.Score, tt.score)
+			}
+			if !slices.Equal(res.Factors, tt.factors) {
+				t.Errorf("factors %v, want %v", res.Factors, tt.factors)
+			}
+			if res.Decision != tt.decision {
+				t.Errorf("decision %s, want %s", res.Decision, tt.decision)
+			}
+		})
+	}
+}
+
+func TestEngineReloadKeepsRulesOnBadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	write := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(testRules)
+	e, err := NewEngine("test", path, testSchema, zap.NewNop())
+	if err != nil {
+		t.Fatalf("NewEngine: %v", err)
+	}
+	before := e.Current()
+
+	write(strings.Replace(testRules, "op: gt", "op: greater", 1))
+	e.reload()
+	if e.Current() != before {
+		t.Fatalf("rule set replaced by an invalid file: version %s", e.Current().Version)
+	}
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	e.reload()
+	if e.Current() != before {
+		t.Fatal("rule set replaced when the file went missing")
+	}
+
+	write(strings.Replace(testRules, `"v1"`, `"v2"`, 1))
+	e.reload()
+	if got := e.Current().Version; got != "v2" {
+		t.Fatalf("version %s after a valid edit, want v2", got)
+	}
+}
@@ -13,6 +13,8 @@ import (
 	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/health"
 	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/kafka"
 	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/metrics"
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/serde"
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/tracing"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
@@ -22,7 +24,7 @@ import (
 // Every service follows the same startup sequence:
 //   1. Load config
 //   2. Initialize logger
-//   3. Ensure topics exist
+//   3. Ensure topics exist, check schemas against the registry
 //   4. Start health server (K8s probes start hitting immediately)
 //   5. Start metrics server
 //   6. Start application logic (producer/consumer)
@@ -30,7 +32,9 @@ import (
 //   8. Graceful shutdown with deadline
 // -------------------------------------------------------------------------------
 
-type ServiceFunc func(ctx context.Context, cfg *config.Config, producer *kafka.Producer, logger *zap.Logger, healthSrv *health.Server) error
+// ServiceFunc is a service's application logic. producer serializes with codec;
+// consumers decode record values with it.
+type ServiceFunc func(ctx context.Context, cfg *config.Config, producer *kafka.Producer, codec serde.Deserializer, logger *zap.Logger, healthSrv *health.Server) error
 
 func Run(configPath string, serviceFn ServiceFunc) {
 	// Step 1 : Load Config
@@ -50,17 +54,42 @@ func Run(configPath string, serviceFn ServiceFunc) {
 		zap.String("env", cfg.Service.Env),
 	)
 
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing, cfg.Service)
+	if err != nil {
+		logger.Fatal("failed to set up tracing", zap.Error(err))
+	}
+	// Deferred before the producer is created, so it runs after producer.Close
+	// and flushes the spans of the last produced records too.
+	defer func() {
+		flushCtx, flushCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer flushCancel()
+		if err := shutdownTracing(flushCtx); err != nil {
+			logger.Warn("failed to flush traces", zap.Error(err))
+		}
+	}()
+
 	// Step 3 : Ensure Topics exist
 	if err := ensureTopics(cfg, logger); err != nil {
 		logger.Fatal("failed to ensure topics", zap.Error(err))
 	}
 
+	// An incompatible schema change stops the rollout here: the new pods
+	// never become ready, and the old ones keep processing.
+	codec, err := serde.NewCodec(cfg.SchemaRegistry)
+	if err != nil {
+		logger.Fatal("failed to set up serialization", zap.Error(err))
+	}
+	if err := codec.CheckCompatibility(); err != nil {
+		logger.Fatal("schema compatibility check failed", zap.Error(err))
+	}
+
 	// Step 4 : Create Shared producer
 	producer, err := kafka.NewProducer(&cfg.Kafka, logger.Named("producer"))
 	if err != nil {
 		logger.Fatal("failed to create producer", zap.Error(err))
 	}
 	defer producer.Close()
+	producer.UseSerializer(codec)
 
 	// Step 5 : Start health server
 	healthSrv := health.NewServer(cfg.Health.Port, logger.Named("health"))
@@ -92,13 +121,15 @@ func Run(configPath string, serviceFn ServiceFunc) {
 
 	errCh := make(chan error, 1)
 	go func() {
-		errCh <- serviceFn(ctx, cfg, producer, logger, healthSrv)
+		errCh <- serviceFn(ctx, cfg, producer, codec, logger, healthSrv)
 	}()
 
+	serviceDone := false
 	select {
 	case sig := <-sigCh:
 		logger.Info("recieved shutdown signal", zap.String("signal", sig.String()))
 	case err := <-errCh:
+		serviceDone = true
 		if err != nil {
 			logger.Error("service exited with error", zap.Error(err))
 		}
@@ -109,6 +140,18 @@ func Run(configPath string, serviceFn ServiceFunc) {
 	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 25*time.Second)
 	defer shutdownCancel()
 
+	// Let the service finish its own cleanup before the producer closes.
+	if !serviceDone {
+		select {
+		case err := <-errCh:
+			if err != nil {
+				logger.Error("service exited with error", zap.Error(err))
+			}
+		case <-shutdownCtx.Done():
+			logger.Warn("service did not stop before the shutdown deadline")
+		}
+	}
+
 	healthSrv.Shutdown(shutdownCtx)
 	logger.Info("service shutdown greacefully")
 }
@@ -141,13 +184,5 @@ func ensureTopics(cfg *config.Config, logger *zap.Logger) error {
 	}
 	defer admin.Close()
 
-	topics := []config.TopicDef{
-		cfg.Kafka.Topics.Transactions,
-		cfg.Kafka.Topics.FraudResults,
-		cfg.Kafka.Topics.EnrichedTransactions,
-		cfg.Kafka.Topics.Notifications,
-		cfg.Kafka.Topics.DLQ,
-	}
-
-	return admin.EnsureTopics(topics)
+	return admin.EnsureTopics(cfg.Kafka.Topics.All())
 }
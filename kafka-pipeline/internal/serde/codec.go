@@ -0,0 +1,106 @@
+package serde
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/config"
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/models"
+)
+
+// Codec serializes each topic according to the schema_registry config: topics
+// with a schema use the matching format, every other topic is plain JSON.
+type Codec struct {
+	serdes  map[string]topicSerde
+	writers map[string]*writerSchema // topic → writer schema, for the startup check
+	plain   *JSONSerde
+}
+
+type topicSerde interface {
+	Serializer
+	Deserializer
+}
+
+// NewCodec builds the codec for cfg. Schema files are read from models.Schemas.
+func NewCodec(cfg config.SchemaRegistryConfig) (*Codec, error) {
+	c := &Codec{
+		serdes:  make(map[string]topicSerde),
+		writers: make(map[string]*writerSchema),
+		plain:   &JSONSerde{},
+	}
+	if cfg.URL == "" {
+		return c, nil
+	}
+
+	registry := NewClient(cfg.URL, cfg.Username, cfg.Password, cfg.Timeout)
+	autoRegister := cfg.AutoRegister
+
+	for topic, file := range cfg.Schemas {
+		raw, err := fs.ReadFile(models.Schemas, path.Join("schemas", file))
+		if err != nil {
+			return nil, fmt.Errorf("reading schema for %s: %w", topic, err)
+		}
+		schema := string(raw)
+		subject := ValueSubject(topic)
+
+		var serde topicSerde
+		var writer *writerSchema
+		switch path.Ext(file) {
+		case ".avsc":
+			s, err := NewAvroSerde(registry, subject, schema, autoRegister)
+			if err != nil {
+				return nil, err
+			}
+			serde, writer = s, s.writer
+		case ".proto":
+			s := NewProtobufSerde(registry, subject, schema, autoRegister)
+			serde, writer = s, s.writer
+		case ".json":
+			s := NewJSONSerde(registry, subject, schema, autoRegister)
+			serde, writer = s, s.writer
+		default:
+			return nil, fmt.Errorf("schema %s for %s: unknown format (want .avsc, .proto or .json)", file, topic)
+		}
+		c.serdes[topic] = serde
+		c.writers[topic] = writer
+	}
+	return c, nil
+}
+
+func (c *Codec) Serialize(topic string, value any) ([]byte, error) {
+	return c.serdeFor(topic).Serialize(topic, value)
+}
+
+func (c *Codec) Deserialize(topic string, data []byte, v any) error {
+	return c.serdeFor(topic).Deserialize(topic, data, v)
+}
+
+func (c *Codec) serdeFor(topic string) topicSerde {
+	if s, ok := c.serdes[topic]; ok {
+		return s
+	}
+	return c.plain
+}
+
+// CheckCompatibility verifies every configured schema against the registry
+// before the service produces or consumes anything. An incompatible schema
+// change must fail the DEPLOY — the new pods never become ready and the old
+// ones keep running — instead of failing consumers one record at a time.
+func (c *Codec) CheckCompatibility() error {
+	topics := make([]string, 0, len(c.writers))
+	for topic := range c.writers {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+
+	var errs []error
+	for _, topic := range topics {
+		if err := c.writers[topic].check(); err != nil {
+			errs = append(errs, fmt.Errorf("topic %s: %w", topic, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,234 @@
+package serde
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/hamba/avro/v2"
+)
+
+// -------------------------------------------------------------------------------
+// FakeRegistry is an in-process schema registry for tests.
+//
+// It implements Registry directly, and http.Handler for the subset of the REST
+// API that Client uses — so the real client can be exercised against it with
+// httptest.NewServer(NewFakeRegistry()).
+//
+// Compatibility follows the registry default, BACKWARD against the latest
+// version: consumers on the new schema must be able to read data written with
+// the previous one. Only Avro is actually checked; schemas of other types
+// are always accepted.
+// -------------------------------------------------------------------------------
+type FakeRegistry struct {
+	mu       sync.Mutex
+	nextID   int
+	byID     map[int]Schema
+	subjects map[string][]int // subject → schema IDs, oldest first
+
+	mux *http.ServeMux
+}
+
+func NewFakeRegistry() *FakeRegistry {
+	r := &FakeRegistry{
+		nextID:   1,
+		byID:     make(map[int]Schema),
+		subjects: make(map[string][]int),
+		mux:      http.NewServeMux(),
+	}
+	r.mux.HandleFunc("POST /subjects/{subject}/versions", r.handleRegister)
+	r.mux.HandleFunc("POST /subjects/{subject}", r.handleLookup)
+	r.mux.HandleFunc("GET /schemas/ids/{id}", r.handleSchemaByID)
+	r.mux.HandleFunc("POST /compatibility/subjects/{subject}/versions/latest", r.handleCompatibility)
+	return r
+}
+
+func (r *FakeRegistry) Register(subject string, schema Schema) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if id, ok := r.lookup(subject, schema); ok {
+		return id, nil
+	}
+	if ok, messages := r.compatible(subject, schema); !ok {
+		return 0, fmt.Errorf("schema incompatible with latest version of %s: %v", subject, messages)
+	}
+
+	// IDs are global: the same schema under another subject keeps its ID.
+	id := 0
+	for existing, s := range r.byID {
+		if s == schema {
+			id = existing
+			break
+		}
+	}
+	if id == 0 {
+		id = r.nextID
+		r.nextID++
+		r.byID[id] = schema
+	}
+	r.subjects[subject] = append(r.subjects[subject], id)
+	return id, nil
+}
+
+func (r *FakeRegistry) LookupID(subject string, schema Schema) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if id, ok := r.lookup(subject, schema); ok {
+		return id, nil
+	}
+	return 0, fmt.Errorf("%w: subject %s", ErrSchemaNotFound, subject)
+}
+
+func (r *FakeRegistry) SchemaByID(id int) (Schema, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	schema, ok := r.byID[id]
+	if !ok {
+		return Schema{}, fmt.Errorf("%w: id %d", ErrSchemaNotFound, id)
+	}
+	return schema, nil
+}
+
+func (r *FakeRegistry) CheckCompatibility(subject string, schema Schema) (bool, []string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ok, messages := r.compatible(subject, schema)
+	return ok, messages, nil
+}
+
+func (r *FakeRegistry) lookup(subject string, schema Schema) (int, bool) {
+	for _, id := range r.subjects[subject] {
+		if r.byID[id] == schema {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+func (r *FakeRegistry) compatible(subject string, schema Schema) (bool, []string) {
+	versions := r.subjects[subject]
+	if len(versions) == 0 {
+		return true, nil
+	}
+	latest := r.byID[versions[len(versions)-1]]
+	if latest.Type != schema.Type {
+		return false, []string{fmt.Sprintf("schema type changed from %s to %s", latest.Type, schema.Type)}
+	}
+	if schema.Type != TypeAvro {
+		return true, nil
+	}
+
+	reader, err := avro.Parse(schema.Schema)
+	if err != nil {
+		return false, []string{err.Error()}
+	}
+	writer, err := avro.Parse(latest.Schema)
+	if err != nil {
+		return false, []string{err.Error()}
+	}
+	if err := avro.NewSchemaCompatibility().Compatible(reader, writer); err != nil {
+		return false, []string{err.Error()}
+	}
+	return true, nil
+}
+
+// ServeHTTP serves the registry REST API.
+func (r *FakeRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mux.ServeHTTP(w, req)
+}
+
+func (r *FakeRegistry) handleRegister(w http.ResponseWriter, req *http.Request) {
+	schema, ok := decodeSchemaRequest(w, req)
+	if !ok {
+		return
+	}
+	id, err := r.Register(req.PathValue("subject"), schema)
+	if err != nil {
+		writeRegistryError(w, http.StatusConflict, 409, err.Error())
+		return
+	}
+	writeRegistryJSON(w, schemaResponse{ID: id})
+}
+
+func (r *FakeRegistry) handleLookup(w http.ResponseWriter, req *http.Request) {
+	schema, ok := decodeSchemaRequest(w, req)
+	if !ok {
+		return
+	}
+	id, err := r.LookupID(req.PathValue("subject"), schema)
+	if err != nil {
+		writeRegistryError(w, http.StatusNotFound, codeSchemaNotFound, err.Error())
+		return
+	}
+	writeRegistryJSON(w, schemaResponse{ID: id, Schema: schema.Schema})
+}
+
+func (r *FakeRegistry) handleSchemaByID(w http.ResponseWriter, req *http.Request) {
+	id, err := strconv.Atoi(req.PathValue("id"))
+	if err != nil {
+		writeRegistryError(w, http.StatusBadRequest, 400, "invalid schema id")
+		return
+	}
+	schema, err := r.SchemaByID(id)
+	if err != nil {
+		writeRegistryError(w, http.StatusNotFound, codeSchemaNotFound, err.Error())
+		return
+	}
+	resp := schemaResponse{Schema: schema.Schema, SchemaType: schema.Type}
+	if resp.SchemaType == TypeAvro {
+		resp.SchemaType = ""
+	}
+	writeRegistryJSON(w, resp)
+}
+
+func (r *FakeRegistry) handleCompatibility(w http.ResponseWriter, req *http.Request) {
+	schema, ok := decodeSchemaRequest(w, req)
+	if !ok {
+		return
+	}
+	subject := req.PathValue("subject")
+
+	r.mu.Lock()
+	_, exists := r.subjects[subject]
+	r.mu.Unlock()
+	if !exists {
+		writeRegistryError(w, http.StatusNotFound, codeSubjectNotFound, "subject not found")
+		return
+	}
+
+	compatible, messages, _ := r.CheckCompatibility(subject, schema)
+	writeRegistryJSON(w, compatibilityResponse{IsCompatible: compatible, Messages: messages})
+}
+
+func decodeSchemaRequest(w http.ResponseWriter, req *http.Request) (Schema, bool) {
+	var body schemaRequest
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		writeRegistryError(w, http.StatusUnprocessableEntity, 42201, err.Error())
+		return Schema{}, false
+	}
+	schema := Schema{Type: body.SchemaType, Schema: body.Schema}
+	if schema.Type == "" {
+		schema.Type = TypeAvro
+	}
+	return schema, true
+}
+
+func writeRegistryJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", registryContentType)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeRegistryError(w http.ResponseWriter, status, code int, message string) {
+	w.Header().Set("Content-Type", registryContentType)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(registryError{Code: code, Message: message})
+}
+
+var _ Registry = (*FakeRegistry)(nil)
+var _ Registry = (*Client)(nil)
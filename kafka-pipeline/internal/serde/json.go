@@ -0,0 +1,52 @@
+package serde
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// JSONSerde writes JSON. With a writer schema (a JSON Schema registered in the
+// registry) values are framed in the wire format; the zero value writes plain
+// JSON, which is what every topic without a configured schema uses.
+//
+// Deserialize accepts both. The payload is not validated against the schema.
+type JSONSerde struct {
+	writer *writerSchema
+}
+
+// NewJSONSerde returns a JSON serde that writes with schema, a JSON Schema
+// document, registered under subject.
+func NewJSONSerde(registry Registry, subject, schema string, autoRegister bool) *JSONSerde {
+	return &JSONSerde{
+		writer: newWriterSchema(registry, subject, Schema{Type: TypeJSON, Schema: schema}, autoRegister),
+	}
+}
+
+func (s *JSONSerde) Serialize(topic string, value any) ([]byte, error) {
+	payload, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("marshaling JSON: %w", err)
+	}
+	if s.writer == nil {
+		return payload, nil
+	}
+	return s.writer.frame(payload)
+}
+
+func (s *JSONSerde) Deserialize(topic string, data []byte, v any) error {
+	_, payload, ok, err := parseWire(data)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		payload = data
+	}
+	return unmarshalJSON(payload, v)
+}
+
+func unmarshalJSON(data []byte, v any) error {
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%w: %w", ErrMalformed, err)
+	}
+	return nil
+}
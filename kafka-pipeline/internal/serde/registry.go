@@ -0,0 +1,226 @@
+package serde
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Registry is the subset of the Confluent Schema Registry API the pipeline uses.
+// Client talks to a real registry.
+type Registry interface {
+	// Register adds schema under subject (a no-op if it is already there) and
+	// returns its global ID.
+	Register(subject string, schema Schema) (int, error)
+	// LookupID returns the ID of schema if it is registered under subject,
+	// or ErrSchemaNotFound.
+	LookupID(subject string, schema Schema) (int, error)
+	// SchemaByID returns the schema with the given global ID.
+	SchemaByID(id int) (Schema, error)
+	// CheckCompatibility reports whether schema may be registered under subject
+	// given the subject's compatibility level. A subject with no versions yet
+	// accepts anything. messages explains an incompatibility.
+	CheckCompatibility(subject string, schema Schema) (compatible bool, messages []string, err error)
+}
+
+// ErrSchemaNotFound is returned when a subject or schema is not registered.
+var ErrSchemaNotFound = errors.New("schema not found")
+
+// Registry API error codes we act on.
+const (
+	codeSubjectNotFound = 40401
+	codeVersionNotFound = 40402
+	codeSchemaNotFound  = 40403
+)
+
+const registryContentType = "application/vnd.schemaregistry.v1+json"
+
+// -------------------------------------------------------------------------------
+// Client is an HTTP client for a Confluent-compatible schema registry.
+//
+// Schemas are immutable once registered, so every answer is cached forever:
+// after warm-up, serializing and deserializing never touches the network.
+// Failures are not cached — a registry blip must not poison the cache.
+// -------------------------------------------------------------------------------
+type Client struct {
+	baseURL  string
+	username string
+	password string
+	http     *http.Client
+
+	mu   sync.RWMutex
+	ids  map[string]int // subject + schema → ID
+	byID map[int]Schema
+}
+
+// NewClient creates a registry client for baseURL. username may be empty.
+func NewClient(baseURL, username, password string, timeout time.Duration) *Client {
+	return &Client{
+		baseURL:  strings.TrimRight(baseURL, "/"),
+		username: username,
+		password: password,
+		http:     &http.Client{Timeout: timeout},
+		ids:      make(map[string]int),
+		byID:     make(map[int]Schema),
+	}
+}
+
+type schemaRequest struct {
+	Schema     string     `json:"schema"`
+	SchemaType SchemaType `json:"schemaType,omitempty"`
+}
+
+type schemaResponse struct {
+	ID         int        `json:"id"`
+	Schema     string     `json:"schema"`
+	SchemaType SchemaType `json:"schemaType"`
+}
+
+type compatibilityResponse struct {
+	IsCompatible bool     `json:"is_compatible"`
+	Messages     []string `json:"messages"`
+}
+
+type registryError struct {
+	Code    int    `json:"error_code"`
+	Message string `json:"message"`
+}
+
+func (e *registryError) Error() string {
+	return fmt.Sprintf("schema registry error %d: %s", e.Code, e.Message)
+}
+
+func (c *Client) Register(subject string, schema Schema) (int, error) {
+	if id, ok := c.cachedID(subject, schema); ok {
+		return id, nil
+	}
+	var resp schemaResponse
+	if err := c.do(http.MethodPost, "/subjects/"+url.PathEscape(subject)+"/versions", newSchemaRequest(schema), &resp); err != nil {
+		return 0, fmt.Errorf("registering schema under %s: %w", subject, err)
+	}
+	c.cache(subject, schema, resp.ID)
+	return resp.ID, nil
+}
+
+func (c *Client) LookupID(subject string, schema Schema) (int, error) {
+	if id, ok := c.cachedID(subject, schema); ok {
+		return id, nil
+	}
+	var resp schemaResponse
+	if err := c.do(http.MethodPost, "/subjects/"+url.PathEscape(subject), newSchemaRequest(schema), &resp); err != nil {
+		return 0, fmt.Errorf("looking up schema under %s: %w", subject, err)
+	}
+	c.cache(subject, schema, resp.ID)
+	return resp.ID, nil
+}
+
+func (c *Client) SchemaByID(id int) (Schema, error) {
+	c.mu.RLock()
+	schema, ok := c.byID[id]
+	c.mu.RUnlock()
+	if ok {
+		return schema, nil
+	}
+
+	var resp schemaResponse
+	if err := c.do(http.MethodGet, "/schemas/ids/"+strconv.Itoa(id), nil, &resp); err != nil {
+		return Schema{}, fmt.Errorf("fetching schema %d: %w", id, err)
+	}
+	schema = Schema{Type: resp.SchemaType, Schema: resp.Schema}
+	if schema.Type == "" {
+		schema.Type = TypeAvro // the API omits the type for Avro
+	}
+
+	c.mu.Lock()
+	c.byID[id] = schema
+	c.mu.Unlock()
+	return schema, nil
+}
+
+func (c *Client) CheckCompatibility(subject string, schema Schema) (bool, []string, error) {
+	var resp compatibilityResponse
+	err := c.do(http.MethodPost, "/compatibility/subjects/"+url.PathEscape(subject)+"/versions/latest?verbose=true", newSchemaRequest(schema), &resp)
+	if errors.Is(err, ErrSchemaNotFound) {
+		return true, nil, nil // first version of the subject
+	}
+	if err != nil {
+		return false, nil, fmt.Errorf("checking compatibility of %s: %w", subject, err)
+	}
+	return resp.IsCompatible, resp.Messages, nil
+}
+
+func (c *Client) do(method, path string, body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		raw, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(raw)
+	}
+
+	req, err := http.NewRequest(method, c.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", registryContentType)
+	if body != nil {
+		req.Header.Set("Content-Type", registryContentType)
+	}
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		regErr := &registryError{Code: resp.StatusCode}
+		if err := json.NewDecoder(resp.Body).Decode(regErr); err != nil {
+			regErr.Message = resp.Status
+		}
+		switch regErr.Code {
+		case codeSubjectNotFound, codeVersionNotFound, codeSchemaNotFound:
+			return fmt.Errorf("%w: %v", ErrSchemaNotFound, regErr)
+		}
+		return regErr
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (c *Client) cachedID(subject string, schema Schema) (int, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	id, ok := c.ids[cacheKey(subject, schema)]
+	return id, ok
+}
+
+func (c *Client) cache(subject string, schema Schema, id int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ids[cacheKey(subject, schema)] = id
+	c.byID[id] = schema
+}
+
+func cacheKey(subject string, schema Schema) string {
+	return subject + "\x00" + string(schema.Type) + "\x00" + schema.Schema
+}
+
+func newSchemaRequest(schema Schema) schemaRequest {
+	req := schemaRequest{Schema: schema.Schema, SchemaType: schema.Type}
+	if req.SchemaType == TypeAvro {
+		req.SchemaType = "" // Avro is the default; older registries reject the field
+	}
+	return req
+}
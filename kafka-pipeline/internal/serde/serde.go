@@ -0,0 +1,168 @@
+package serde
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// -------------------------------------------------------------------------------
+// Serializers turn domain values into record values and back.
+//
+// WHY A SCHEMA REGISTRY:
+// With plain JSON, the only contract between a producer and its consumers is
+// "whatever the Go structs looked like when both were last deployed". Renaming a
+// field compiles, passes review, and breaks every consumer at 3am.
+// With a registry, every schema change is checked against the previous version
+// BEFORE it is used, and every record says exactly which schema wrote it.
+//
+// WIRE FORMAT (Confluent):
+//
+//	byte 0     magic byte, always 0
+//	bytes 1-4  schema ID, big-endian int32
+//	bytes 5-   payload (Avro binary, Protobuf, or JSON)
+//
+// Protobuf payloads are additionally prefixed with the index path of the
+// message type inside its .proto file — see protobuf.go.
+//
+// Plain JSON never starts with a 0 byte, so deserializers accept both plain JSON
+// and the wire format. That is what lets a topic move from JSON to a registry
+// format without a flag day.
+// -------------------------------------------------------------------------------
+
+// Serializer encodes value for a record on topic.
+type Serializer interface {
+	Serialize(topic string, value any) ([]byte, error)
+}
+
+// Deserializer decodes a record value read from topic into v.
+type Deserializer interface {
+	Deserialize(topic string, data []byte, v any) error
+}
+
+// ErrMalformed marks a payload that can never be decoded: truncated wire format,
+// bytes that don't match the writer schema, invalid JSON. Retrying won't help.
+// Registry lookups that fail are NOT malformed — the registry may be back soon.
+var ErrMalformed = errors.New("malformed payload")
+
+// SchemaType names a schema format the way the registry API does.
+type SchemaType string
+
+const (
+	TypeAvro     SchemaType = "AVRO"
+	TypeProtobuf SchemaType = "PROTOBUF"
+	TypeJSON     SchemaType = "JSON"
+)
+
+// Schema is a schema as stored in the registry.
+type Schema struct {
+	Type   SchemaType
+	Schema string
+}
+
+// ValueSubject returns the registry subject for the values of topic
+// (TopicNameStrategy — the Confluent default).
+func ValueSubject(topic string) string {
+	return topic + "-value"
+}
+
+const (
+	magicByte        = 0
+	wireHeaderLength = 5
+)
+
+// appendWireHeader appends the magic byte and schema ID to buf.
+func appendWireHeader(buf []byte, id int) []byte {
+	buf = append(buf, magicByte)
+	return binary.BigEndian.AppendUint32(buf, uint32(id))
+}
+
+// parseWire splits a wire-format record into schema ID and payload. ok is false
+// if data is not in the wire format at all (e.g. plain JSON).
+func parseWire(data []byte) (id int, payload []byte, ok bool, err error) {
+	if len(data) == 0 || data[0] != magicByte {
+		return 0, nil, false, nil
+	}
+	if len(data) < wireHeaderLength {
+		return 0, nil, true, fmt.Errorf("%w: %d bytes is shorter than the wire format header", ErrMalformed, len(data))
+	}
+	return int(binary.BigEndian.Uint32(data[1:wireHeaderLength])), data[wireHeaderLength:], true, nil
+}
+
+// writerSchema is the schema a serializer writes with. Its registry ID is
+// resolved on first use and cached; a failed lookup is retried next time.
+type writerSchema struct {
+	registry     Registry
+	subject      string
+	schema       Schema
+	autoRegister bool
+
+	mu sync.Mutex
+	id int
+}
+
+func newWriterSchema(registry Registry, subject string, schema Schema, autoRegister bool) *writerSchema {
+	return &writerSchema{
+		registry:     registry,
+		subject:      subject,
+		schema:       schema,
+		autoRegister: autoRegister,
+	}
+}
+
+// ID returns the registry ID of the schema, registering it if allowed.
+func (w *writerSchema) ID() (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.id != 0 {
+		return w.id, nil
+	}
+	var (
+		id  int
+		err error
+	)
+	if w.autoRegister {
+		id, err = w.registry.Register(w.subject, w.schema)
+	} else {
+		id, err = w.registry.LookupID(w.subject, w.schema)
+	}
+	if err != nil {
+		return 0, err
+	}
+	w.id = id
+	return id, nil
+}
+
+// check verifies that the schema may be used under its subject: compatible
+// with the latest registered version and — unless we may register it
+// ourselves — already registered.
+func (w *writerSchema) check() error {
+	compatible, messages, err := w.registry.CheckCompatibility(w.subject, w.schema)
+	if err != nil {
+		return err
+	}
+	if !compatible {
+		return fmt.Errorf("%s schema is incompatible with the latest registered version: %s",
+			w.subject, strings.Join(messages, "; "))
+	}
+	if _, err := w.ID(); err != nil {
+		if errors.Is(err, ErrSchemaNotFound) {
+			return fmt.Errorf("%s schema is not registered and auto_register is off", w.subject)
+		}
+		return err
+	}
+	return nil
+}
+
+// frame prepends the wire format header for the writer schema to payload.
+func (w *writerSchema) frame(payload []byte) ([]byte, error) {
+	id, err := w.ID()
+	if err != nil {
+		return nil, fmt.Errorf("resolving schema ID for %s: %w", w.subject, err)
+	}
+	out := make([]byte, 0, wireHeaderLength+len(payload))
+	return append(appendWireHeader(out, id), payload...), nil
+}
@@ -0,0 +1,343 @@
+package serde
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/config"
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/models"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	paymentV1 = `{"type": "record", "name": "Payment", "fields": [
+		{"name": "id", "type": "string"},
+		{"name": "amount", "type": "double"}
+	]}`
+	// V2 adds a field with a default: readers on V2 can read V1 records.
+	paymentV2 = `{"type": "record", "name": "Payment", "fields": [
+		{"name": "id", "type": "string"},
+		{"name": "amount", "type": "double"},
+		{"name": "currency", "type": "string", "default": "INR"}
+	]}`
+	// Broken adds a field without a default: V1 records lack it.
+	paymentBroken = `{"type": "record", "name": "Payment", "fields": [
+		{"name": "id", "type": "string"},
+		{"name": "amount", "type": "double"},
+		{"name": "merchant", "type": "string"}
+	]}`
+
+	paymentJSONSchema = `{"type": "object", "properties": {
+		"id": {"type": "string"},
+		"amount": {"type": "number"},
+		"currency": {"type": "string"}
+	}}`
+)
+
+type payment struct {
+	ID       string  `json:"id"`
+	Amount   float64 `json:"amount"`
+	Currency string  `json:"currency"`
+}
+
+// newTestClient returns a Client talking HTTP to a fresh FakeRegistry.
+func newTestClient(t *testing.T) *Client {
+	t.Helper()
+	srv := httptest.NewServer(NewFakeRegistry())
+	t.Cleanup(srv.Close)
+	return NewClient(srv.URL, "", "", 5*time.Second)
+}
+
+func TestClientRegister(t *testing.T) {
+	client := newTestClient(t)
+	schema := Schema{Type: TypeAvro, Schema: paymentV1}
+
+	if _, err := client.LookupID("payments-value", schema); !errors.Is(err, ErrSchemaNotFound) {
+		t.Fatalf("LookupID before Register: got %v, want ErrSchemaNotFound", err)
+	}
+
+	id, err := client.Register("payments-value", schema)
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	if again, err := client.Register("payments-value", schema); err != nil || again != id {
+		t.Fatalf("Register again: got %d, %v; want %d", again, err, id)
+	}
+	if looked, err := client.LookupID("payments-value", schema); err != nil || looked != id {
+		t.Fatalf("LookupID: got %d, %v; want %d", looked, err, id)
+	}
+	// IDs are global: the same schema under another subject keeps its ID.
+	if other, err := client.Register("refunds-value", schema); err != nil || other != id {
+		t.Fatalf("Register under another subject: got %d, %v; want %d", other, err, id)
+	}
+
+	got, err := client.SchemaByID(id)
+	if err != nil {
+		t.Fatalf("SchemaByID: %v", err)
+	}
+	if got != schema {
+		t.Fatalf("SchemaByID: got %+v, want %+v", got, schema)
+	}
+}
+
+func TestCompatibility(t *testing.T) {
+	client := newTestClient(t)
+	if _, err := client.Register("payments-value", Schema{Type: TypeAvro, Schema: paymentV1}); err != nil {
+		t.Fatalf("Register v1: %v", err)
+	}
+
+	ok, _, err := client.CheckCompatibility("payments-value", Schema{Type: TypeAvro, Schema: paymentV2})
+	if err != nil || !ok {
+		t.Fatalf("v2 with a defaulted field: compatible = %v, %v; want true", ok, err)
+	}
+
+	ok, messages, err := client.CheckCompatibility("payments-value", Schema{Type: TypeAvro, Schema: paymentBroken})
+	if err != nil || ok || len(messages) == 0 {
+		t.Fatalf("field without default: compatible = %v, %v, %v; want false with messages", ok, messages, err)
+	}
+	if _, err := client.Register("payments-value", Schema{Type: TypeAvro, Schema: paymentBroken}); err == nil {
+		t.Fatal("Register of an incompatible schema succeeded")
+	}
+
+	// The startup check fails the deploy on it.
+	broken, err := NewAvroSerde(client, "payments-value", paymentBroken, true)
+	if err != nil {
+		t.Fatalf("NewAvroSerde: %v", err)
+	}
+	if err := broken.writer.check(); err == nil || !strings.Contains(err.Error(), "incompatible") {
+		t.Fatalf("check: got %v, want an incompatibility error", err)
+	}
+
+	// Without auto-registration a compatible but unregistered schema fails too.
+	unregistered, err := NewAvroSerde(client, "payments-value", paymentV2, false)
+	if err != nil {
+		t.Fatalf("NewAvroSerde: %v", err)
+	}
+	if err := unregistered.writer.check(); err == nil || !strings.Contains(err.Error(), "not registered") {
+		t.Fatalf("check: got %v, want a not-registered error", err)
+	}
+}
+
+func TestAvroWireFormatRoundTrip(t *testing.T) {
+	client := newTestClient(t)
+	v1, err := NewAvroSerde(client, "payments-value", paymentV1, true)
+	if err != nil {
+		t.Fatalf("NewAvroSerde v1: %v", err)
+	}
+
+	data, err := v1.Serialize("payments", payment{ID: "p1", Amount: 42.5})
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	if len(data) < wireHeaderLength || data[0] != magicByte {
+		t.Fatalf("Serialize: %x is not in the wire format", data)
+	}
+	id, err := client.LookupID("payments-value", Schema{Type: TypeAvro, Schema: paymentV1})
+	if err != nil {
+		t.Fatalf("LookupID: %v", err)
+	}
+	if got := int(binary.BigEndian.Uint32(data[1:wireHeaderLength])); got != id {
+		t.Fatalf("schema ID in record: got %d, want %d", got, id)
+	}
+
+	var got payment
+	if err := v1.Deserialize("payments", data, &got); err != nil {
+		t.Fatalf("Deserialize: %v", err)
+	}
+	if want := (payment{ID: "p1", Amount: 42.5}); got != want {
+		t.Fatalf("Deserialize: got %+v, want %+v", got, want)
+	}
+
+	// A reader on v2 resolves the v1 writer schema: the new field gets its
+	// default.
+	v2, err := NewAvroSerde(client, "payments-value", paymentV2, true)
+	if err != nil {
+		t.Fatalf("NewAvroSerde v2: %v", err)
+	}
+	got = payment{}
+	if err := v2.Deserialize("payments", data, &got); err != nil {
+		t.Fatalf("Deserialize v1 record with v2: %v", err)
+	}
+	if want := (payment{ID: "p1", Amount: 42.5, Currency: "INR"}); got != want {
+		t.Fatalf("Deserialize v1 record with v2: got %+v, want %+v", got, want)
+	}
+
+	// Plain JSON written before the topic moved to Avro still reads.
+	got = payment{}
+	if err := v2.Deserialize("payments", []byte(`{"id":"p0","amount":1,"currency":"USD"}`), &got); err != nil {
+		t.Fatalf("Deserialize plain JSON: %v", err)
+	}
+	if want := (payment{ID: "p0", Amount: 1, Currency: "USD"}); got != want {
+		t.Fatalf("Deserialize plain JSON: got %+v, want %+v", got, want)
+	}
+
+	if err := v2.Deserialize("payments", []byte{magicByte, 0, 0}, &got); !errors.Is(err, ErrMalformed) {
+		t.Fatalf("Deserialize truncated header: got %v, want ErrMalformed", err)
+	}
+}
+
+func TestJSONSchemaWireFormat(t *testing.T) {
+	client := newTestClient(t)
+	s := NewJSONSerde(client, "payments-value", paymentJSONSchema, true)
+
+	data, err := s.Serialize("payments", payment{ID: "p1", Amount: 42.5, Currency: "INR"})
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	id, err := client.LookupID("payments-value", Schema{Type: TypeJSON, Schema: paymentJSONSchema})
+	if err != nil {
+		t.Fatalf("LookupID: %v", err)
+	}
+	if data[0] != magicByte || int(binary.BigEndian.Uint32(data[1:wireHeaderLength])) != id {
+		t.Fatalf("Serialize: %x is not in the wire format with schema %d", data, id)
+	}
+	if got, want := string(data[wireHeaderLength:]), `{"id":"p1","amount":42.5,"currency":"INR"}`; got != want {
+		t.Fatalf("payload: got %s, want %s", got, want)
+	}
+
+	for name, record := range map[string][]byte{
+		"wire format": data,
+		"plain JSON":  []byte(`{"id":"p1","amount":42.5,"currency":"INR"}`),
+	} {
+		var got payment
+		if err := s.Deserialize("payments", record, &got); err != nil {
+			t.Fatalf("Deserialize %s: %v", name, err)
+		}
+		if want := (payment{ID: "p1", Amount: 42.5, Currency: "INR"}); got != want {
+			t.Fatalf("Deserialize %s: got %+v, want %+v", name, got, want)
+		}
+	}
+
+	// The zero value is what topics without a schema use: no framing.
+	plain, err := (&JSONSerde{}).Serialize("payments", payment{ID: "p1"})
+	if err != nil || plain[0] == magicByte {
+		t.Fatalf("plain Serialize: got %q, %v", plain, err)
+	}
+}
+
+func TestProtobufWireFormatRoundTrip(t *testing.T) {
+	client := newTestClient(t)
+	for _, tt := range []struct {
+		name string
+		msg  proto.Message
+		// Zigzag varints: the path length, then the index at each level.
+		indexes []byte
+	}{
+		// Timestamp is the first message of timestamp.proto: path [0], one 0 byte.
+		{"first message", timestamppb.New(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)), []byte{0}},
+		// Value is the second message of struct.proto: path [1].
+		{"second message", structpb.NewStringValue("INR"), []byte{2, 2}},
+		// ReservedRange is nested in DescriptorProto, the third message of
+		// descriptor.proto: path [2, 1].
+		{"nested message", &descriptorpb.DescriptorProto_ReservedRange{Start: proto.Int32(1), End: proto.Int32(5)}, []byte{4, 4, 2}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			subject := strings.ReplaceAll(tt.name, " ", "-") + "-value"
+			source := `syntax = "proto3"; // ` + tt.name
+			s := NewProtobufSerde(client, subject, source, true)
+
+			data, err := s.Serialize("protos", tt.msg)
+			if err != nil {
+				t.Fatalf("Serialize: %v", err)
+			}
+			id, err := client.LookupID(subject, Schema{Type: TypeProtobuf, Schema: source})
+			if err != nil {
+				t.Fatalf("LookupID: %v", err)
+			}
+			if data[0] != magicByte || int(binary.BigEndian.Uint32(data[1:wireHeaderLength])) != id {
+				t.Fatalf("Serialize: %x is not in the wire format with schema %d", data, id)
+			}
+			payload := data[wireHeaderLength:]
+			if !bytes.HasPrefix(payload, tt.indexes) {
+				t.Fatalf("message indexes: payload %x, want prefix %x", payload, tt.indexes)
+			}
+
+			got := tt.msg.ProtoReflect().New().Interface()
+			if err := s.Deserialize("protos", data, got); err != nil {
+				t.Fatalf("Deserialize: %v", err)
+			}
+			if !proto.Equal(got, tt.msg) {
+				t.Fatalf("Deserialize: got %v, want %v", got, tt.msg)
+			}
+		})
+	}
+
+	s := NewProtobufSerde(client, "timestamps-value", `syntax = "proto3";`, true)
+	if _, err := s.Serialize("timestamps", payment{ID: "p1"}); err == nil {
+		t.Fatal("Serialize of a non-proto value succeeded")
+	}
+
+	// JSON written before the topic moved to Protobuf still reads.
+	var ts timestamppb.Timestamp
+	if err := s.Deserialize("timestamps", []byte(`"2026-01-02T03:04:05Z"`), &ts); err != nil {
+		t.Fatalf("Deserialize plain JSON: %v", err)
+	}
+	if got, want := ts.AsTime(), time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC); !got.Equal(want) {
+		t.Fatalf("Deserialize plain JSON: got %v, want %v", got, want)
+	}
+
+	// A varint cut short after the header.
+	if err := s.Deserialize("timestamps", []byte{magicByte, 0, 0, 0, 1, 0x80}, &ts); !errors.Is(err, ErrMalformed) {
+		t.Fatalf("Deserialize truncated indexes: got %v, want ErrMalformed", err)
+	}
+}
+
+func TestCodec(t *testing.T) {
+	srv := httptest.NewServer(NewFakeRegistry())
+	defer srv.Close()
+
+	codec, err := NewCodec(config.SchemaRegistryConfig{
+		URL:          srv.URL,
+		Timeout:      5 * time.Second,
+		AutoRegister: true,
+		Schemas:      map[string]string{"txn.raw.v1": "transaction.avsc"},
+	})
+	if err != nil {
+		t.Fatalf("NewCodec: %v", err)
+	}
+	if err := codec.CheckCompatibility(); err != nil {
+		t.Fatalf("CheckCompatibility: %v", err)
+	}
+
+	txn := models.Transaction{
+		ID:         "txn-1",
+		Amount:     250,
+		Currency:   "INR",
+		SenderID:   "user-1",
+		ReceiverID: "merchant_freshmart",
+		Type:       models.TypePayment,
+		Status:     models.StatusPending,
+		Metadata:   map[string]string{"channel": "upi"},
+		CreatedAt:  time.Date(2026, 1, 2, 3, 4, 5, 6000, time.UTC),
+	}
+	data, err := codec.Serialize("txn.raw.v1", txn)
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	if data[0] != magicByte {
+		t.Fatalf("Serialize: topic with a schema wrote %q, not the wire format", data)
+	}
+	var got models.Transaction
+	if err := codec.Deserialize("txn.raw.v1", data, &got); err != nil {
+		t.Fatalf("Deserialize: %v", err)
+	}
+	got.CreatedAt = got.CreatedAt.UTC()
+	if !reflect.DeepEqual(got, txn) {
+		t.Fatalf("round trip: got %+v, want %+v", got, txn)
+	}
+
+	// Topics without a schema stay plain JSON.
+	plain, err := codec.Serialize("txn.dlq.v1", map[string]string{"a": "b"})
+	if err != nil || string(plain) != `{"a":"b"}` {
+		t.Fatalf("Serialize without schema: got %q, %v", plain, err)
+	}
+}
@@ -0,0 +1,76 @@
+package statestore
+
+import (
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var boltBucket = []byte("kv")
+
+// BoltStore is a Store persisted to a single bbolt file. It survives process
+// restarts on the same host, so a restarted instance that gets its old
+// partitions back only has to replay the changelog tail it missed.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+func OpenBoltStore(path string) (*BoltStore, error) {
+	// Timeout: a second process holding the file lock (e.g. an old pod that has
+	// not exited yet) should fail startup instead of hanging it.
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("opening bolt store %s: %w", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("creating bolt bucket: %w", err)
+	}
+	return &BoltStore{db: db}, nil
+}
+
+func (s *BoltStore) Get(key string) ([]byte, bool, error) {
+	var value []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(boltBucket).Get([]byte(key))
+		if v != nil {
+			// bbolt memory is only valid inside the transaction.
+			value = append([]byte(nil), v...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return value, value != nil, nil
+}
+
+func (s *BoltStore) Put(key string, value []byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Put([]byte(key), value)
+	})
+}
+
+func (s *BoltStore) Delete(key string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Delete([]byte(key))
+	})
+}
+
+func (s *BoltStore) ForEach(fn func(key string, value []byte) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).ForEach(func(k, v []byte) error {
+			return fn(string(k), append([]byte(nil), v...))
+		})
+	})
+}
+
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
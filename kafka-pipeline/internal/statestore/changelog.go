@@ -0,0 +1,321 @@
+package statestore
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/IBM/sarama"
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/config"
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/kafka"
+	"go.uber.org/zap"
+)
+
+// -------------------------------------------------------------------------------
+// ChangelogStore makes a local Store fault tolerant the way Kafka Streams does:
+// every write is also produced to a COMPACTED changelog topic, keyed by the
+// store key. Deletes become tombstones. On partition assignment the new owner
+// replays the changelog for its partitions and ends up with the exact state the
+// previous owner had.
+//
+// CO-PARTITIONING:
+// Writes are pinned to the partition number of the input record that caused
+// them. The changelog must therefore have the same partition count as the
+// input topics — partition 3 of the input owns partition 3 of the changelog,
+// and restoring on assignment only has to read the partitions we were given.
+//
+// WRITE ORDER:
+// Changelog first, local store second. If we crash in between, the restore
+// on restart re-applies the write; the reverse order would lose it. Inside a
+// producer transaction the local write waits for the commit
+// (kafka.AfterCommit), so an aborted transaction leaves the store untouched.
+//
+// CHECKPOINTS:
+// Restore records, per partition, the last changelog offset it applied. A
+// persistent backend that gets its partitions back after a restart replays
+// only from the checkpoint instead of the whole changelog. Live writes do NOT
+// move the checkpoint: with concurrent writers, a later offset can be applied
+// locally before an earlier one, and checkpointing it would skip the earlier
+// write on the next restore. Replaying our own writes again is harmless.
+//
+// OWNERSHIP:
+// Only the instance that owns a partition may write its changelog partition:
+// a stale writer's tombstone deletes the new owner's state on its next
+// restore. Restore takes ownership of the partitions it is given and drops
+// the local state of every other partition; Revoke gives them all up when the
+// consumer session ends. Entries of partitions we do not own are invisible,
+// and writing them fails with ErrNotOwner.
+//
+// Handlers need nothing more — a session ends only after its handlers return.
+// Code that writes from outside a handler (eviction loops) must Hold the
+// partition for the duration, so Revoke waits for it.
+// -------------------------------------------------------------------------------
+type ChangelogStore struct {
+	local    Store
+	producer *kafka.Producer
+	kafkaCfg *config.KafkaConfig
+	topic    string
+	logger   *zap.Logger
+
+	mu      sync.Mutex
+	owned   map[int32]bool
+	holders sync.RWMutex // read-held by Hold, write-locked by Revoke to wait for them
+}
+
+// ErrNotOwner is returned for a write to a partition this instance does not
+// own.
+var ErrNotOwner = errors.New("changelog partition not owned by this instance")
+
+func NewChangelogStore(local Store, producer *kafka.Producer, kafkaCfg *config.KafkaConfig, topic string, logger *zap.Logger) *ChangelogStore {
+	return &ChangelogStore{
+		local:    local,
+		producer: producer,
+		kafkaCfg: kafkaCfg,
+		topic:    topic,
+		logger:   logger,
+	}
+}
+
+// Get returns the value of key, if its partition is owned.
+func (s *ChangelogStore) Get(key string) ([]byte, bool, error) {
+	raw, ok, err := s.local.Get(key)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	partition, value, err := unframe(key, raw)
+	if err != nil || !s.Owns(partition) {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+// ForEach visits every entry of the owned partitions.
+func (s *ChangelogStore) ForEach(fn func(key string, value []byte) error) error {
+	return s.local.ForEach(func(key string, raw []byte) error {
+		if strings.HasPrefix(key, checkpointPrefix) {
+			return nil
+		}
+		partition, value, err := unframe(key, raw)
+		if err != nil {
+			return err
+		}
+		if !s.Owns(partition) {
+			return nil
+		}
+		return fn(key, value)
+	})
+}
+
+// Put writes key to the changelog partition and then to the local store.
+func (s *ChangelogStore) Put(ctx context.Context, partition int32, key string, value []byte) error {
+	if !s.Owns(partition) {
+		return fmt.Errorf("writing %s to partition %d: %w", key, partition, ErrNotOwner)
+	}
+	if _, _, err := s.producer.ProduceRaw(ctx, s.topic, partition, key, value, nil); err != nil {
+		return fmt.Errorf("writing changelog: %w", err)
+	}
+	return kafka.AfterCommit(ctx, func() error {
+		return s.local.Put(key, frame(partition, value))
+	})
+}
+
+// Delete writes a tombstone for key and removes it locally.
+func (s *ChangelogStore) Delete(ctx context.Context, partition int32, key string) error {
+	if !s.Owns(partition) {
+		return fmt.Errorf("deleting %s from partition %d: %w", key, partition, ErrNotOwner)
+	}
+	if _, _, err := s.producer.ProduceRaw(ctx, s.topic, partition, key, nil, nil); err != nil {
+		return fmt.Errorf("writing changelog tombstone: %w", err)
+	}
+	return kafka.AfterCommit(ctx, func() error {
+		return s.local.Delete(key)
+	})
+}
+
+// Owns reports whether this instance owns partition.
+func (s *ChangelogStore) Owns(partition int32) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.owned[partition]
+}
+
+// Hold keeps partition owned until release is called: Revoke waits for it.
+// ok is false, and there is nothing to release, if the partition is not
+// owned.
+func (s *ChangelogStore) Hold(partition int32) (release func(), ok bool) {
+	s.holders.RLock()
+	if !s.Owns(partition) {
+		s.holders.RUnlock()
+		return nil, false
+	}
+	return s.holders.RUnlock, true
+}
+
+// Revoke gives up every partition, waiting for current holders. Call it when
+// the consumer session ends, before another instance can own them.
+func (s *ChangelogStore) Revoke() {
+	s.mu.Lock()
+	s.owned = nil
+	s.mu.Unlock()
+
+	s.holders.Lock()
+	defer s.holders.Unlock()
+}
+
+// Restore takes ownership of partitions — the whole assignment — and replays
+// their changelog into the local store. The local state of every other
+// partition is dropped: its owner may have changed it since.
+// Replaying is idempotent, so it is safe to call on every assignment even if
+// the local store already holds some of the state.
+func (s *ChangelogStore) Restore(ctx context.Context, partitions []int32) error {
+	if err := s.dropExcept(partitions); err != nil {
+		return err
+	}
+	if len(partitions) == 0 {
+		return nil
+	}
+
+	from := make(map[int32]int64, len(partitions))
+	for _, p := range partitions {
+		offset, ok, err := s.checkpointOf(p)
+		if err != nil {
+			return err
+		}
+		from[p] = sarama.OffsetOldest
+		if ok {
+			from[p] = offset + 1
+		}
+	}
+
+	var applied int
+	err := kafka.ReplayPartitions(ctx, s.kafkaCfg, s.topic, from, func(msg *sarama.ConsumerMessage) error {
+		applied++
+		var err error
+		if msg.Value == nil {
+			err = s.local.Delete(string(msg.Key))
+		} else {
+			err = s.local.Put(string(msg.Key), frame(msg.Partition, msg.Value))
+		}
+		if err != nil {
+			return err
+		}
+		return s.checkpoint(msg.Partition, msg.Offset)
+	})
+	if err != nil {
+		return fmt.Errorf("restoring from changelog %s: %w", s.topic, err)
+	}
+
+	s.logger.Info("state store restored from changelog",
+		zap.String("changelog", s.topic),
+		zap.Int32s("partitions", partitions),
+		zap.Int("records_applied", applied),
+	)
+
+	owned := make(map[int32]bool, len(partitions))
+	for _, p := range partitions {
+		owned[p] = true
+	}
+	s.mu.Lock()
+	s.owned = owned
+	s.mu.Unlock()
+	return nil
+}
+
+// dropExcept deletes the entries and checkpoints of every partition not in
+// keep, so a partition we get back later is restored from scratch.
+func (s *ChangelogStore) dropExcept(keep []int32) error {
+	kept := make(map[int32]bool, len(keep))
+	for _, p := range keep {
+		kept[p] = true
+	}
+
+	// Collect first, delete after: ForEach may hold a read transaction that a
+	// nested write would deadlock against.
+	var stale []string
+	var entries int
+	err := s.local.ForEach(func(key string, raw []byte) error {
+		if p, ok := strings.CutPrefix(key, checkpointPrefix); ok {
+			partition, err := strconv.Atoi(p)
+			if err == nil && !kept[int32(partition)] {
+				stale = append(stale, key)
+			}
+			return nil
+		}
+		partition, _, err := unframe(key, raw)
+		if err != nil {
+			return err
+		}
+		if !kept[partition] {
+			stale = append(stale, key)
+			entries++
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("scanning %s state for revoked partitions: %w", s.topic, err)
+	}
+	for _, key := range stale {
+		if err := s.local.Delete(key); err != nil {
+			return fmt.Errorf("dropping %s state of revoked partition: %w", s.topic, err)
+		}
+	}
+	if entries > 0 {
+		s.logger.Info("dropped local state of partitions no longer owned",
+			zap.String("changelog", s.topic),
+			zap.Int("entries", entries),
+		)
+	}
+	return nil
+}
+
+func (s *ChangelogStore) Close() error {
+	return s.local.Close()
+}
+
+const checkpointPrefix = "__changelog_checkpoint/"
+
+// Local entries are stored as the 4-byte partition they belong to followed by
+// the value, so revoked partitions can be told apart and dropped.
+func frame(partition int32, value []byte) []byte {
+	raw := make([]byte, 4+len(value))
+	binary.BigEndian.PutUint32(raw, uint32(partition))
+	copy(raw[4:], value)
+	return raw
+}
+
+func unframe(key string, raw []byte) (int32, []byte, error) {
+	if len(raw) < 4 {
+		return 0, nil, fmt.Errorf("corrupt local entry %s: %d bytes", key, len(raw))
+	}
+	return int32(binary.BigEndian.Uint32(raw)), raw[4:], nil
+}
+
+func (s *ChangelogStore) checkpoint(partition int32, offset int64) error {
+	return putCheckpoint(s.local, partition, offset)
+}
+
+func (s *ChangelogStore) checkpointOf(partition int32) (int64, bool, error) {
+	return readCheckpoint(s.local, partition)
+}
+
+// putCheckpoint records offset as the last record of partition applied to
+// local.
+func putCheckpoint(local Store, partition int32, offset int64) error {
+	return local.Put(checkpointPrefix+strconv.Itoa(int(partition)), []byte(strconv.FormatInt(offset, 10)))
+}
+
+func readCheckpoint(local Store, partition int32) (int64, bool, error) {
+	raw, ok, err := local.Get(checkpointPrefix + strconv.Itoa(int(partition)))
+	if err != nil || !ok {
+		return 0, false, err
+	}
+	offset, err := strconv.ParseInt(string(raw), 10, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("corrupt checkpoint for partition %d: %w", partition, err)
+	}
+	return offset, true, nil
+}
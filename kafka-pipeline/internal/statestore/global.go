@@ -0,0 +1,102 @@
+package statestore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/IBM/sarama"
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/config"
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/kafka"
+	"go.uber.org/zap"
+)
+
+// -------------------------------------------------------------------------------
+// GlobalTable is a read-only local copy of a whole compacted topic — the Go
+// equivalent of a Kafka Streams GlobalKTable. A ChangelogStore holds only the
+// partitions this instance was assigned; a GlobalTable holds every key on
+// every instance, so a processor can look up any key whatever it consumes.
+// Use it for small reference data another service owns.
+//
+// Restore catches up to the high-water mark and is meant to run before the
+// processor starts consuming; Follow then tails the topic for the life of the
+// service. Lookups are eventually consistent: an update becomes visible once
+// Follow has applied it.
+//
+// Checkpoints work as in ChangelogStore. Follow is the only writer and applies
+// each partition in offset order, so here it does move them, and a persistent
+// backend replays only the tail after a restart.
+// -------------------------------------------------------------------------------
+type GlobalTable struct {
+	local    Store
+	kafkaCfg *config.KafkaConfig
+	topic    string
+	logger   *zap.Logger
+
+	applied atomic.Int64
+}
+
+func NewGlobalTable(local Store, kafkaCfg *config.KafkaConfig, topic string, logger *zap.Logger) *GlobalTable {
+	return &GlobalTable{
+		local:    local,
+		kafkaCfg: kafkaCfg,
+		topic:    topic,
+		logger:   logger,
+	}
+}
+
+// Get returns the latest value of key; found is false for keys never written
+// or deleted by a tombstone.
+func (t *GlobalTable) Get(key string) ([]byte, bool, error) {
+	return t.local.Get(key)
+}
+
+// Restore applies every record from the checkpoints up to the high-water mark.
+func (t *GlobalTable) Restore(ctx context.Context) error {
+	before := t.applied.Load()
+	if err := kafka.ReplayTopicFrom(ctx, t.kafkaCfg, t.topic, t.startOffset, t.apply); err != nil {
+		return fmt.Errorf("restoring global table %s: %w", t.topic, err)
+	}
+	t.logger.Info("global table restored",
+		zap.String("topic", t.topic),
+		zap.Int64("records_applied", t.applied.Load()-before),
+	)
+	return nil
+}
+
+// Follow applies new records until ctx is done. It returns nil on
+// cancellation and an error if the topic can no longer be read.
+func (t *GlobalTable) Follow(ctx context.Context) error {
+	err := kafka.FollowTopic(ctx, t.kafkaCfg, t.topic, t.startOffset, t.apply)
+	if err != nil && !errors.Is(err, context.Canceled) {
+		return fmt.Errorf("following global table %s: %w", t.topic, err)
+	}
+	return nil
+}
+
+func (t *GlobalTable) Close() error {
+	return t.local.Close()
+}
+
+func (t *GlobalTable) startOffset(partition int32) (int64, error) {
+	offset, ok, err := readCheckpoint(t.local, partition)
+	if err != nil || !ok {
+		return sarama.OffsetOldest, err
+	}
+	return offset + 1, nil
+}
+
+func (t *GlobalTable) apply(msg *sarama.ConsumerMessage) error {
+	var err error
+	if msg.Value == nil {
+		err = t.local.Delete(string(msg.Key))
+	} else {
+		err = t.local.Put(string(msg.Key), msg.Value)
+	}
+	if err != nil {
+		return err
+	}
+	t.applied.Add(1)
+	return putCheckpoint(t.local, msg.Partition, msg.Offset)
+}
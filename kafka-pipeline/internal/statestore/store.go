@@ -0,0 +1,91 @@
+package statestore
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/config"
+)
+
+// -------------------------------------------------------------------------------
+// Store is the local half of a stateful stream processor — the Go equivalent of
+// a Kafka Streams RocksDB store. It is a plain byte-oriented key/value map;
+// callers own serialization.
+//
+// A Store on its own is NOT fault tolerant: the in-memory backend dies with the
+// process, and the on-disk backend does not follow partitions to another
+// instance on rebalance. Wrap it in a ChangelogStore for that.
+// -------------------------------------------------------------------------------
+type Store interface {
+	Get(key string) (value []byte, found bool, err error)
+	Put(key string, value []byte) error
+	Delete(key string) error
+	// ForEach visits every entry. fn must not modify the store.
+	ForEach(fn func(key string, value []byte) error) error
+	Close() error
+}
+
+// Open creates the backend selected in cfg. name identifies the store and,
+// for on-disk backends, becomes the file name.
+func Open(cfg config.StateStoreConfig, name string) (Store, error) {
+	switch cfg.Backend {
+	case "", "memory":
+		return NewMemoryStore(), nil
+	case "bolt":
+		if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+			return nil, fmt.Errorf("creating state dir %s: %w", cfg.Dir, err)
+		}
+		return OpenBoltStore(filepath.Join(cfg.Dir, name+".db"))
+	default:
+		return nil, fmt.Errorf("unknown state store backend %q", cfg.Backend)
+	}
+}
+
+// MemoryStore is a Store backed by a map. State is lost on restart unless it
+// is rebuilt from a changelog.
+type MemoryStore struct {
+	mu    sync.RWMutex
+	items map[string][]byte
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{items: make(map[string][]byte)}
+}
+
+func (s *MemoryStore) Get(key string) ([]byte, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	v, ok := s.items[key]
+	return v, ok, nil
+}
+
+func (s *MemoryStore) Put(key string, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.items[key] = value
+	return nil
+}
+
+func (s *MemoryStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.items, key)
+	return nil
+}
+
+func (s *MemoryStore) ForEach(fn func(key string, value []byte) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for k, v := range s.items {
+		if err := fn(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
@@ -0,0 +1,75 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+)
+
+// -------------------------------------------------------------------------------
+// Setup installs the global OpenTelemetry propagator and, when tracing is
+// enabled, a tracer provider exporting spans over OTLP/HTTP.
+//
+// The W3C propagator is installed unconditionally: internal/kafka always
+// copies traceparent/tracestate from consumed records onto what it produces,
+// so a service with tracing off is transparent rather than a break in the
+// trace.
+//
+// The returned shutdown flushes buffered spans; call it on the way out.
+// -------------------------------------------------------------------------------
+func Setup(ctx context.Context, cfg config.TracingConfig, service config.ServiceConfig) (shutdown func(context.Context) error, err error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	if !cfg.Enabled {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	var opts []otlptracehttp.Option
+	if cfg.Endpoint != "" {
+		opts = append(opts, otlptracehttp.WithEndpointURL(cfg.Endpoint))
+	}
+	exporter, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("creating OTLP exporter: %w", err)
+	}
+
+	res, err := newResource(service)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// newResource describes the service on every span. resource.Merge fails when
+// the two schema URLs differ, so semconv must be the version the SDK's
+// resource.Default uses — bump the import together with the SDK.
+func newResource(service config.ServiceConfig) (*resource.Resource, error) {
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(service.Name),
+		semconv.ServiceVersion(service.Version),
+		semconv.DeploymentEnvironmentName(service.Env),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("building trace resource: %w", err)
+	}
+	return res, nil
+}
@@ -0,0 +1,45 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/config"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/resource"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+)
+
+func TestNewResource(t *testing.T) {
+	if got, want := semconv.SchemaURL, resource.Default().SchemaURL(); got != want {
+		t.Fatalf("semconv schema %s differs from the SDK's %s; resource.Merge would fail", got, want)
+	}
+
+	res, err := newResource(config.ServiceConfig{Name: "enricher", Version: "1.2.3", Env: "production"})
+	if err != nil {
+		t.Fatalf("newResource: %v", err)
+	}
+	for key, want := range map[attribute.Key]string{
+		semconv.ServiceNameKey:               "enricher",
+		semconv.ServiceVersionKey:            "1.2.3",
+		semconv.DeploymentEnvironmentNameKey: "production",
+	} {
+		if got, ok := res.Set().Value(key); !ok || got.AsString() != want {
+			t.Errorf("%s = %q, want %q", key, got.AsString(), want)
+		}
+	}
+}
+
+func TestSetupEnabled(t *testing.T) {
+	shutdown, err := Setup(context.Background(), config.TracingConfig{
+		Enabled:     true,
+		Endpoint:    "http://127.0.0.1:4318",
+		SampleRatio: 1,
+	}, config.ServiceConfig{Name: "enricher", Version: "1.2.3", Env: "dev"})
+	if err != nil {
+		t.Fatalf("Setup: %v", err)
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+}
@@ -1,6 +1,7 @@
 package circuitbreaker
 
 import (
+	"context"
 	"errors"
 	"sync"
 	"time"
@@ -8,6 +9,8 @@ import (
 	"github.com/dmehra2102/prod-golang-projects/kafka-pipeline/internal/metrics"
 )
 
+// ErrCircuitOpen is returned without calling fn while the circuit is open, or
+// half-open with every probe slot taken.
 var ErrCircuitOpen = errors.New("circuit breaker is open")
 
 type State int
@@ -18,42 +21,119 @@ const (
 	StateOpen     State = 2
 )
 
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateHalfOpen:
+		return "half_open"
+	case StateOpen:
+		return "open"
+	default:
+		return "unknown"
+	}
+}
+
+// windowBuckets is how many buckets the rolling window is split into. Calls
+// age out of the window a bucket at a time.
+const windowBuckets = 10
+
+// -------------------------------------------------------------------------------
+// CircuitBreaker stops calling a dependency that is failing or too slow, and
+// probes it until it recovers.
+//
+//   - Closed: every call goes through. Outcomes are counted in a rolling
+//     window; once it holds at least MinRequests calls and the failure rate
+//     or the slow-call rate reaches its threshold, the circuit opens.
+//   - Open: calls fail fast with ErrCircuitOpen for Timeout.
+//   - Half-open: at most HalfOpenMaxCalls probes run at a time; everything
+//     else is still rejected. SuccessThreshold good probes close the circuit
+//     with an empty window; one failed or slow probe opens it again.
+//
+// A call that started in an earlier state (a slow call admitted just before
+// the circuit opened) is not counted when it finishes in a later one.
+// -------------------------------------------------------------------------------
 type CircuitBreaker struct {
-	name             string
-	mu               sync.RWMutex
-	state            State
-	failureCount     int
-	successCount     int
-	failureThreshold int
-	successThreshold int
-	timeout          time.Duration
-	lastFailure      time.Time
+	name string
+	cfg  Config
+
+	mu             sync.Mutex
+	state          State
+	generation     uint64 // bumped on every transition
+	openedAt       time.Time
+	buckets        [windowBuckets]bucket
+	probes         int // half-open calls in flight
+	probeSuccesses int // successful half-open calls since the circuit half-opened
+}
+
+// bucket counts the calls of one slice of the window. epoch identifies the
+// slice; a bucket whose epoch is too old holds nothing.
+type bucket struct {
+	epoch    int64
+	total    int
+	failures int
+	slow     int
 }
 
 type Config struct {
-	Name             string
-	FailureThreshold int           // failures before opening (default: 5)
-	SuccessThreshold int           // successes in half-open before closing (default: 2)
-	Timeout          time.Duration // time in open state before half-open (default: 30s)
+	Name string
+	// Window: the failure and slow-call rates are over the calls of the last
+	// Window (default: 30s).
+	Window time.Duration
+	// MinRequests: below this many calls in the window the circuit stays
+	// closed whatever the rates (default: 10).
+	MinRequests int
+	// FailureRateThreshold: the fraction of failed calls that opens the
+	// circuit (default: 0.5).
+	FailureRateThreshold float64
+	// SlowCallDuration: calls taking at least this long are slow. 0 = the
+	// slow-call rate is not tracked.
+	SlowCallDuration time.Duration
+	// SlowCallRateThreshold: the fraction of slow calls that opens the
+	// circuit (default: 1 — every call in the window).
+	SlowCallRateThreshold float64
+	Timeout               time.Duration // time in open state before half-open (default: 30s)
+	HalfOpenMaxCalls      int           // concurrent probes in half-open (default: 1)
+	SuccessThreshold      int           // successful probes before closing (default: 2)
+	// IsFailure decides which errors count against the dependency. Errors it
+	// rejects — a validation error, a not-found — count as successes, and are
+	// still returned to the caller. Default: every non-nil error.
+	IsFailure func(err error) bool
+	// OnStateChange is called after every transition, outside the breaker's
+	// lock. It must not block.
+	OnStateChange func(name string, from, to State)
 }
 
 func New(cfg Config) *CircuitBreaker {
-	if cfg.FailureThreshold == 0 {
-		cfg.FailureThreshold = 5
+	if cfg.Window == 0 {
+		cfg.Window = 30 * time.Second
 	}
-	if cfg.SuccessThreshold == 0 {
-		cfg.SuccessThreshold = 2
+	if cfg.MinRequests == 0 {
+		cfg.MinRequests = 10
+	}
+	if cfg.FailureRateThreshold == 0 {
+		cfg.FailureRateThreshold = 0.5
+	}
+	if cfg.SlowCallRateThreshold == 0 {
+		cfg.SlowCallRateThreshold = 1
 	}
 	if cfg.Timeout == 0 {
 		cfg.Timeout = 30 * time.Second
 	}
+	if cfg.HalfOpenMaxCalls == 0 {
+		cfg.HalfOpenMaxCalls = 1
+	}
+	if cfg.SuccessThreshold == 0 {
+		cfg.SuccessThreshold = 2
+	}
+	if cfg.IsFailure == nil {
+		cfg.IsFailure = func(err error) bool { return err != nil }
+	}
 
 	cb := &CircuitBreaker{
-		name:             cfg.Name,
-		state:            StateClosed,
-		failureThreshold: cfg.FailureThreshold,
-		successThreshold: cfg.SuccessThreshold,
-		timeout:          cfg.Timeout,
+		name:  cfg.Name,
+		cfg:   cfg,
+		state: StateClosed,
 	}
 
 	metrics.CircuitBreakerState.WithLabelValues(cfg.Name).Set(0)
@@ -63,81 +143,211 @@ func New(cfg Config) *CircuitBreaker {
 // Execute runs fn if the circuit allows it. Returns ErrCircuitOpen if the
 // circuit is open. Tracks successes/failures to manage state transitions.
 func (cb *CircuitBreaker) Execute(fn func() error) error {
-	if !cb.allowRequest() {
-		return ErrCircuitOpen
+	return cb.ExecuteCtx(context.Background(), func(context.Context) error {
+		return fn()
+	})
+}
+
+// ExecuteCtx is Execute for calls that take a context. A ctx that is already
+// done fails the call without running it; a call cut short because the
+// caller cancelled ctx says nothing about the dependency and is not counted.
+// A deadline that expires is — the dependency was too slow for the caller.
+// A panic in fn counts as a failure and is passed on to the caller.
+func (cb *CircuitBreaker) ExecuteCtx(ctx context.Context, fn func(ctx context.Context) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	generation, err := cb.before()
+	if err != nil {
+		metrics.CircuitBreakerCalls.WithLabelValues(cb.name, "rejected").Inc()
+		return err
 	}
 
-	err := fn()
-	cb.recordResult(err)
+	start := time.Now()
+	returned := false
+	defer func() {
+		if returned {
+			return
+		}
+		// Without an outcome a half-open probe would hold its slot forever,
+		// and the circuit would reject every call from then on.
+		r := recover()
+		cb.after(generation, outcomeFailure, time.Since(start))
+		panic(r)
+	}()
+	err = fn(ctx)
+	returned = true
+	elapsed := time.Since(start)
+
+	if errors.Is(ctx.Err(), context.Canceled) {
+		cb.after(generation, outcomeIgnored, elapsed)
+		return err
+	}
+	outcome := outcomeSuccess
+	if err != nil && cb.cfg.IsFailure(err) {
+		outcome = outcomeFailure
+	}
+	cb.after(generation, outcome, elapsed)
 	return err
 }
 
-func (cb *CircuitBreaker) allowRequest() bool {
-	cb.mu.RLock()
-	defer cb.mu.RUnlock()
+type outcome int
+
+const (
+	outcomeSuccess outcome = iota
+	outcomeFailure
+	outcomeIgnored
+)
+
+// before admits a call, returning the generation it runs in.
+func (cb *CircuitBreaker) before() (uint64, error) {
+	cb.mu.Lock()
+	now := time.Now()
+	var changed func()
+	if cb.state == StateOpen && now.Sub(cb.openedAt) >= cb.cfg.Timeout {
+		changed = cb.setState(StateHalfOpen, now)
+	}
 
+	var err error
 	switch cb.state {
-	case StateClosed:
-		return true
 	case StateOpen:
-		if time.Since(cb.lastFailure) > cb.timeout {
-			cb.mu.RUnlock()
-			cb.mu.Lock()
-
-			if cb.state == StateOpen && time.Since(cb.lastFailure) > cb.timeout {
-				cb.state = StateHalfOpen
-				cb.successCount = 0
-				metrics.CircuitBreakerState.WithLabelValues(cb.name).Set(float64(StateHalfOpen))
+		err = ErrCircuitOpen
+	case StateHalfOpen:
+		if cb.probes >= cb.cfg.HalfOpenMaxCalls {
+			err = ErrCircuitOpen
+		} else {
+			cb.probes++
+		}
+	}
+	generation := cb.generation
+	cb.mu.Unlock()
+
+	if changed != nil {
+		changed()
+	}
+	return generation, err
+}
+
+// after records the outcome of a call admitted in generation.
+func (cb *CircuitBreaker) after(generation uint64, o outcome, elapsed time.Duration) {
+	slow := cb.cfg.SlowCallDuration > 0 && elapsed >= cb.cfg.SlowCallDuration
+	switch {
+	case o == outcomeFailure:
+		metrics.CircuitBreakerCalls.WithLabelValues(cb.name, "failure").Inc()
+	case o == outcomeSuccess:
+		metrics.CircuitBreakerCalls.WithLabelValues(cb.name, "success").Inc()
+	}
+	if slow && o != outcomeIgnored {
+		metrics.CircuitBreakerSlowCalls.WithLabelValues(cb.name).Inc()
+	}
+
+	cb.mu.Lock()
+	if generation != cb.generation {
+		cb.mu.Unlock()
+		return
+	}
+	now := time.Now()
+	var changed func()
+	switch cb.state {
+	case StateClosed:
+		if o == outcomeIgnored {
+			break
+		}
+		b := cb.bucket(now)
+		b.total++
+		if o == outcomeFailure {
+			b.failures++
+		}
+		if slow {
+			b.slow++
+		}
+		if cb.tripped(now) {
+			changed = cb.setState(StateOpen, now)
+		}
+	case StateHalfOpen:
+		cb.probes--
+		switch {
+		case o == outcomeIgnored:
+		case o == outcomeFailure || slow:
+			changed = cb.setState(StateOpen, now)
+		default:
+			cb.probeSuccesses++
+			if cb.probeSuccesses >= cb.cfg.SuccessThreshold {
+				changed = cb.setState(StateClosed, now)
 			}
-			cb.mu.Unlock()
-			cb.mu.RLock()
-			return cb.state == StateHalfOpen
 		}
+	}
+	cb.mu.Unlock()
+
+	if changed != nil {
+		changed()
+	}
+}
+
+// bucket returns the bucket for now, emptying it if it held an older slice.
+// The caller must hold cb.mu.
+func (cb *CircuitBreaker) bucket(now time.Time) *bucket {
+	epoch := now.UnixNano() / int64(cb.bucketWidth())
+	b := &cb.buckets[epoch%windowBuckets]
+	if b.epoch != epoch {
+		*b = bucket{epoch: epoch}
+	}
+	return b
+}
+
+func (cb *CircuitBreaker) bucketWidth() time.Duration {
+	return max(cb.cfg.Window/windowBuckets, time.Millisecond)
+}
+
+// tripped reports whether the window's rates call for opening the circuit.
+// The caller must hold cb.mu.
+func (cb *CircuitBreaker) tripped(now time.Time) bool {
+	oldest := now.UnixNano()/int64(cb.bucketWidth()) - windowBuckets + 1
+	var total, failures, slow int
+	for _, b := range cb.buckets {
+		if b.epoch >= oldest {
+			total += b.total
+			failures += b.failures
+			slow += b.slow
+		}
+	}
+	if total == 0 || total < cb.cfg.MinRequests {
 		return false
-	case StateHalfOpen:
+	}
+	if float64(failures)/float64(total) >= cb.cfg.FailureRateThreshold {
 		return true
-	default:
-		return false
 	}
+	return cb.cfg.SlowCallDuration > 0 && float64(slow)/float64(total) >= cb.cfg.SlowCallRateThreshold
 }
 
-func (cb *CircuitBreaker) recordResult(err error) {
-	cb.mu.Lock()
-	defer cb.mu.Unlock()
+// setState moves the breaker to state and returns the notification to run
+// once cb.mu is released. The caller must hold cb.mu.
+func (cb *CircuitBreaker) setState(state State, now time.Time) func() {
+	from := cb.state
+	cb.state = state
+	cb.generation++
+	cb.probes = 0
+	cb.probeSuccesses = 0
+	switch state {
+	case StateOpen:
+		cb.openedAt = now
+	case StateClosed:
+		cb.buckets = [windowBuckets]bucket{}
+	}
 
-	if err != nil {
-		cb.failureCount++
-		cb.lastFailure = time.Now()
-		cb.successCount = 0
-
-		switch cb.state {
-		case StateClosed:
-			if cb.failureCount >= cb.failureThreshold {
-				cb.state = StateOpen
-				metrics.CircuitBreakerState.WithLabelValues(cb.name).Set(float64(StateOpen))
-			}
-		case StateHalfOpen:
-			cb.state = StateOpen
-			metrics.CircuitBreakerState.WithLabelValues(cb.name).Set(float64(StateOpen))
-		}
-	} else {
-		cb.successCount++
-
-		switch cb.state {
-		case StateHalfOpen:
-			if cb.successCount >= cb.successThreshold {
-				cb.state = StateClosed
-				cb.failureCount = 0
-				metrics.CircuitBreakerState.WithLabelValues(cb.name).Set(float64(StateClosed))
-			}
-		case StateClosed:
-			cb.failureCount = 0
+	metrics.CircuitBreakerState.WithLabelValues(cb.name).Set(float64(state))
+	metrics.CircuitBreakerTransitions.WithLabelValues(cb.name, from.String(), state.String()).Inc()
+	return func() {
+		if cb.cfg.OnStateChange != nil {
+			cb.cfg.OnStateChange(cb.name, from, state)
 		}
 	}
 }
 
+// State returns the current state. An open circuit whose timeout has passed
+// reports open until the next call half-opens it.
 func (cb *CircuitBreaker) State() State {
-	cb.mu.RLock()
-	defer cb.mu.RUnlock()
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
 	return cb.state
 }
@@ -0,0 +1,230 @@
+package circuitbreaker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+var (
+	errDown     = errors.New("dependency down")
+	errNotFound = errors.New("not found")
+)
+
+func succeed() error { return nil }
+func fail() error    { return errDown }
+
+// run makes n calls of fn through cb, all of which must be admitted.
+func run(t *testing.T, cb *CircuitBreaker, n int, fn func() error) {
+	t.Helper()
+	for range n {
+		if err := cb.Execute(fn); errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("call rejected in state %s", cb.State())
+		}
+	}
+}
+
+func wantState(t *testing.T, cb *CircuitBreaker, want State) {
+	t.Helper()
+	if got := cb.State(); got != want {
+		t.Fatalf("state %s, want %s", got, want)
+	}
+}
+
+// trip opens cb, whose MinRequests must be at most 2, and waits out its timeout.
+func trip(t *testing.T, cb *CircuitBreaker) {
+	t.Helper()
+	run(t, cb, 2, fail)
+	wantState(t, cb, StateOpen)
+	time.Sleep(cb.cfg.Timeout + 5*time.Millisecond)
+}
+
+func TestFailureRateOpensCircuit(t *testing.T) {
+	cb := New(Config{Name: "test", MinRequests: 4, FailureRateThreshold: 0.5})
+	run(t, cb, 2, succeed)
+	run(t, cb, 1, fail)
+	wantState(t, cb, StateClosed)
+
+	// 2 failures out of 4 calls.
+	run(t, cb, 1, fail)
+	wantState(t, cb, StateOpen)
+	if err := cb.Execute(func() error {
+		t.Error("open circuit ran the call")
+		return nil
+	}); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("got %v, want ErrCircuitOpen", err)
+	}
+}
+
+func TestMinRequestsFloor(t *testing.T) {
+	cb := New(Config{Name: "test", MinRequests: 5})
+	run(t, cb, 4, fail)
+	wantState(t, cb, StateClosed)
+	run(t, cb, 1, fail)
+	wantState(t, cb, StateOpen)
+}
+
+func TestFailuresAgeOutOfWindow(t *testing.T) {
+	cb := New(Config{Name: "test", Window: 100 * time.Millisecond, MinRequests: 2})
+	run(t, cb, 1, fail)
+	time.Sleep(120 * time.Millisecond)
+
+	// The first failure left the window: one call in it, below MinRequests.
+	run(t, cb, 1, fail)
+	wantState(t, cb, StateClosed)
+	run(t, cb, 1, fail)
+	wantState(t, cb, StateOpen)
+}
+
+func TestSlowCallRateOpensCircuit(t *testing.T) {
+	cb := New(Config{
+		Name:                  "test",
+		MinRequests:           2,
+		SlowCallDuration:      10 * time.Millisecond,
+		SlowCallRateThreshold: 0.5,
+	})
+	slow := func() error {
+		time.Sleep(15 * time.Millisecond)
+		return nil
+	}
+	run(t, cb, 1, succeed)
+	wantState(t, cb, StateClosed)
+
+	// Successful, but half the calls were slow.
+	run(t, cb, 1, slow)
+	wantState(t, cb, StateOpen)
+}
+
+func TestHalfOpenProbeCap(t *testing.T) {
+	cb := New(Config{Name: "test", MinRequests: 2, Timeout: 20 * time.Millisecond, HalfOpenMaxCalls: 1, SuccessThreshold: 2})
+	trip(t, cb)
+
+	release := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- cb.Execute(func() error {
+			<-release
+			return nil
+		})
+	}()
+	// Wait for the probe to take its slot.
+	for cb.State() != StateHalfOpen {
+		time.Sleep(time.Millisecond)
+	}
+	if err := cb.Execute(succeed); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("second probe: got %v, want ErrCircuitOpen", err)
+	}
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatalf("probe: %v", err)
+	}
+	wantState(t, cb, StateHalfOpen)
+	run(t, cb, 1, succeed)
+	wantState(t, cb, StateClosed)
+}
+
+func TestFailedProbeReopensCircuit(t *testing.T) {
+	cb := New(Config{Name: "test", MinRequests: 2, Timeout: 20 * time.Millisecond})
+	trip(t, cb)
+	run(t, cb, 1, fail)
+	wantState(t, cb, StateOpen)
+}
+
+func TestStaleOutcomeIgnored(t *testing.T) {
+	cb := New(Config{Name: "test", MinRequests: 2, Timeout: 20 * time.Millisecond})
+
+	// A call admitted while closed, which fails only once the circuit has
+	// opened and half-opened since.
+	started := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- cb.Execute(func() error {
+			close(started)
+			<-release
+			return errDown
+		})
+	}()
+	<-started
+
+	trip(t, cb)
+	probe := make(chan struct{})
+	probeDone := make(chan error)
+	go func() {
+		probeDone <- cb.Execute(func() error {
+			<-probe
+			return nil
+		})
+	}()
+	for cb.State() != StateHalfOpen {
+		time.Sleep(time.Millisecond)
+	}
+
+	close(release)
+	if err := <-done; !errors.Is(err, errDown) {
+		t.Fatalf("stale call: got %v, want %v", err, errDown)
+	}
+	// Its failure is not the probe's: the circuit stays half-open.
+	wantState(t, cb, StateHalfOpen)
+
+	close(probe)
+	if err := <-probeDone; err != nil {
+		t.Fatalf("probe: %v", err)
+	}
+	wantState(t, cb, StateHalfOpen)
+}
+
+func TestIsFailure(t *testing.T) {
+	cb := New(Config{
+		Name:        "test",
+		MinRequests: 2,
+		IsFailure:   func(err error) bool { return !errors.Is(err, errNotFound) },
+	})
+	for range 5 {
+		if err := cb.Execute(func() error { return errNotFound }); !errors.Is(err, errNotFound) {
+			t.Fatalf("got %v, want the call's own error", err)
+		}
+	}
+	wantState(t, cb, StateClosed)
+
+	run(t, cb, 5, fail)
+	wantState(t, cb, StateOpen)
+}
+
+func TestCancelledCallNotCounted(t *testing.T) {
+	cb := New(Config{Name: "test", MinRequests: 2})
+	for range 5 {
+		ctx, cancel := context.WithCancel(context.Background())
+		err := cb.ExecuteCtx(ctx, func(ctx context.Context) error {
+			cancel()
+			return ctx.Err()
+		})
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("got %v, want context.Canceled", err)
+		}
+	}
+	wantState(t, cb, StateClosed)
+}
+
+func TestPanicReleasesProbe(t *testing.T) {
+	cb := New(Config{Name: "test", MinRequests: 2, Timeout: 20 * time.Millisecond})
+	trip(t, cb)
+
+	func() {
+		defer func() {
+			if r := recover(); r != "probe exploded" {
+				t.Fatalf("recovered %v, want the probe's panic", r)
+			}
+		}()
+		_ = cb.Execute(func() error { panic("probe exploded") })
+	}()
+	// Counted as a failed probe.
+	wantState(t, cb, StateOpen)
+
+	time.Sleep(cb.cfg.Timeout + 5*time.Millisecond)
+	if err := cb.Execute(succeed); err != nil {
+		t.Fatalf("next probe: %v", err)
+	}
+}